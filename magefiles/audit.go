@@ -0,0 +1,348 @@
+//go:build mage
+
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Layer whiteout markers (OCI image spec, "Representing Changes").
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// Audit finding kinds.
+const (
+	findingSetuid        = "setuid"
+	findingSetgid        = "setgid"
+	findingWorldWritable = "world-writable-dir"
+	findingRootOwned     = "root-owned-in-factorio"
+)
+
+// auditEntry is the state of one path in the merged image filesystem.
+type auditEntry struct {
+	Mode  int64
+	UID   int
+	GID   int
+	Type  byte
+	Layer int // index of the layer that last wrote the path
+}
+
+// AuditFinding is one suspicious path in the final image filesystem.
+type AuditFinding struct {
+	Kind     string `json:"Kind"`
+	Path     string `json:"Path"`
+	Mode     string `json:"Mode"`
+	Owner    string `json:"Owner"`
+	Layer    int    `json:"Layer"`
+	Hardened bool   `json:"Hardened"` // introduced by our layers rather than upstream
+	Blocking bool   `json:"Blocking"` // fails the audit
+}
+
+// AuditChange is a path added, modified or removed by the hardening layers.
+type AuditChange struct {
+	Path   string `json:"Path"`
+	Change string `json:"Change"` // added, modified or removed
+	Layer  int    `json:"Layer"`
+}
+
+// ImageAuditReport is the machine-readable result of Build:Audit.
+type ImageAuditReport struct {
+	Image      string         `json:"Image"`
+	Digest     string         `json:"Digest"`
+	Platform   string         `json:"Platform"`
+	BaseLayers int            `json:"BaseLayers"`
+	Layers     int            `json:"Layers"`
+	Passed     bool           `json:"Passed"`
+	Findings   []AuditFinding `json:"Findings"`
+	Changes    []AuditChange  `json:"Changes"`
+	AuditedAt  time.Time      `json:"AuditedAt"`
+}
+
+// Audit walks every layer tarball of an image, merges them into the final
+// filesystem and reports setuid/setgid files, world-writable directories,
+// root-owned files under /factorio and the files our hardening layers add,
+// change or remove relative to upstream. The audit fails on setuid/setgid files
+// introduced by our layers, root-owned paths under /factorio, and non-sticky
+// world-writable directories outside /factorio. Differences from the previous
+// audit are printed so Dockerfile regressions stand out.
+// image may be an image reference, or "test"/"prod" for the last build of that env.
+func (Build) Audit(image string) (err error) {
+	run := startTarget("build:audit")
+	defer func() { run.finish(err) }()
+
+	image, reportDir, err := resolveBuildImage(image)
+	if err != nil {
+		return err
+	}
+	reportPath := filepath.Join(reportDir, "audit.json")
+
+	logInfo("🧮 Auditing image layers of %s...", image)
+	report, err := auditImage(image)
+	if err != nil {
+		return err
+	}
+	printAuditReport(report)
+
+	if previous, err := readAuditReport(reportPath); err == nil {
+		printAuditRegressions(previous, report)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logWarn("Could not compare with previous audit: %v", err)
+	}
+
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode audit report: %v", err)
+	}
+	if err := writeFile(reportPath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write audit report: %v", err)
+	}
+	logInfo("🧾 Audit report written → %s", reportPath)
+
+	if !report.Passed {
+		return fmt.Errorf("filesystem audit of %s found blocking issues", image)
+	}
+	logInfo("✅ Filesystem audit passed.")
+	return nil
+}
+
+// auditImage opens image, merges its layers and collects findings and changes.
+func auditImage(image string) (*ImageAuditReport, error) {
+	art, err := openImage(image, "")
+	if err != nil {
+		return nil, err
+	}
+	defer art.Close()
+
+	_, baseCount, err := hardeningLayers(art)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := make(map[string]auditEntry)
+	var changes []AuditChange
+	for i, layer := range art.Layers {
+		hardened := i >= baseCount
+		origin := "upstream"
+		if hardened {
+			origin = "hardening"
+		}
+		logDebug("layer %d/%d %s (%s)", i+1, len(art.Layers), layer.DiffID, origin)
+		err := walkLayer(layer, func(name string, hdr *tar.Header) error {
+			dir, base := path.Split(name)
+			dir = path.Clean(dir)
+			switch {
+			case base == whiteoutOpaque:
+				removeTree(fs, dir, i, false)
+				if hardened {
+					changes = append(changes, AuditChange{Path: dir + "/*", Change: "removed", Layer: i})
+				}
+				return nil
+			case strings.HasPrefix(base, whiteoutPrefix):
+				target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+				removeTree(fs, target, i, true)
+				if hardened {
+					changes = append(changes, AuditChange{Path: target, Change: "removed", Layer: i})
+				}
+				return nil
+			}
+
+			if hardened && hdr.Typeflag != tar.TypeDir {
+				change := "added"
+				if _, exists := fs[name]; exists {
+					change = "modified"
+				}
+				changes = append(changes, AuditChange{Path: name, Change: change, Layer: i})
+			}
+			fs[name] = auditEntry{Mode: hdr.Mode, UID: hdr.Uid, GID: hdr.Gid, Type: hdr.Typeflag, Layer: i}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ImageAuditReport{
+		Image:      image,
+		Digest:     art.Digest,
+		Platform:   art.Platform,
+		BaseLayers: baseCount,
+		Layers:     len(art.Layers),
+		Passed:     true,
+		Findings:   auditFindings(fs, baseCount),
+		Changes:    changes,
+		AuditedAt:  time.Now().UTC(),
+	}
+	for _, f := range report.Findings {
+		if f.Blocking {
+			report.Passed = false
+		}
+	}
+	return report, nil
+}
+
+// removeTree deletes p's children written by layers below layer, and p itself
+// when self is set, applying a whiteout.
+func removeTree(fs map[string]auditEntry, p string, layer int, self bool) {
+	if self {
+		delete(fs, p)
+	}
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for name, e := range fs {
+		if strings.HasPrefix(name, prefix) && e.Layer < layer {
+			delete(fs, name)
+		}
+	}
+}
+
+// auditFindings inspects the merged filesystem and returns findings sorted by path.
+func auditFindings(fs map[string]auditEntry, baseCount int) []AuditFinding {
+	var findings []AuditFinding
+	add := func(kind, name string, e auditEntry, blocking bool) {
+		findings = append(findings, AuditFinding{
+			Kind:     kind,
+			Path:     name,
+			Mode:     fmt.Sprintf("%04o", e.Mode&07777),
+			Owner:    fmt.Sprintf("%d:%d", e.UID, e.GID),
+			Layer:    e.Layer,
+			Hardened: e.Layer >= baseCount,
+			Blocking: blocking,
+		})
+	}
+
+	for name, e := range fs {
+		if e.Type == tar.TypeSymlink {
+			continue
+		}
+		inFactorio := name == writableRoot || strings.HasPrefix(name, writableRoot+"/")
+		hardened := e.Layer >= baseCount
+		setuid, worldWritable := hardeningIssues(name, e.Type, e.Mode)
+
+		// Build:Verify fails on the same setuid and world-writable paths when
+		// they come from our layers; upstream ones are reported for context.
+		if setuid {
+			add(findingSetuid, name, e, hardened)
+		}
+		if e.Mode&02000 != 0 && e.Type != tar.TypeDir {
+			add(findingSetgid, name, e, hardened)
+		}
+		if e.Mode&0o002 != 0 {
+			add(findingWorldWritable, name, e, worldWritable && hardened)
+		}
+		if inFactorio && e.UID == 0 {
+			add(findingRootOwned, name, e, true)
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Kind != findings[j].Kind {
+			return findings[i].Kind < findings[j].Kind
+		}
+		return findings[i].Path < findings[j].Path
+	})
+	return findings
+}
+
+// readAuditReport loads a previously written audit report.
+func readAuditReport(path string) (*ImageAuditReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report ImageAuditReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &report, nil
+}
+
+// printAuditReport prints findings grouped by kind and a summary of hardening changes.
+func printAuditReport(r *ImageAuditReport) {
+	fmt.Printf("  %-24s %-6s %-9s %-5s %-9s %s\n", "FINDING", "MODE", "OWNER", "LAYER", "STATUS", "PATH")
+	for _, f := range r.Findings {
+		status := "info"
+		if f.Blocking {
+			status = "BLOCKING"
+		}
+		origin := "upstream"
+		if f.Hardened {
+			origin = "ours"
+		}
+		fmt.Printf("  %-24s %-6s %-9s %-5d %-9s %s (%s)\n", f.Kind, f.Mode, f.Owner, f.Layer, status, f.Path, origin)
+	}
+
+	counts := map[string]int{}
+	for _, c := range r.Changes {
+		counts[c.Change]++
+	}
+	logInfo("📂 Hardening layers (%d of %d): %d added, %d modified, %d removed",
+		r.Layers-r.BaseLayers, r.Layers, counts["added"], counts["modified"], counts["removed"])
+}
+
+// printAuditRegressions lists findings and hardening changes that differ from the previous audit.
+func printAuditRegressions(prev, cur *ImageAuditReport) {
+	key := func(f AuditFinding) string { return f.Kind + " " + f.Path }
+	prevFindings := make(map[string]bool, len(prev.Findings))
+	for _, f := range prev.Findings {
+		prevFindings[key(f)] = true
+	}
+	curFindings := make(map[string]bool, len(cur.Findings))
+	for _, f := range cur.Findings {
+		curFindings[key(f)] = true
+	}
+
+	changeKey := func(c AuditChange) string { return c.Change + " " + c.Path }
+	prevChanges := make(map[string]bool, len(prev.Changes))
+	for _, c := range prev.Changes {
+		prevChanges[changeKey(c)] = true
+	}
+	curChanges := make(map[string]bool, len(cur.Changes))
+	for _, c := range cur.Changes {
+		curChanges[changeKey(c)] = true
+	}
+
+	var lines []string
+	for k := range curFindings {
+		if !prevFindings[k] {
+			lines = append(lines, "   + finding "+k)
+		}
+	}
+	for k := range prevFindings {
+		if !curFindings[k] {
+			lines = append(lines, "   - finding "+k)
+		}
+	}
+	for k := range curChanges {
+		if !prevChanges[k] {
+			lines = append(lines, "   + "+k)
+		}
+	}
+	for k := range prevChanges {
+		if !curChanges[k] {
+			lines = append(lines, "   - "+k)
+		}
+	}
+
+	if len(lines) == 0 {
+		logInfo("ℹ️  No changes since previous audit (%s).", prev.Digest)
+		return
+	}
+	sort.Strings(lines)
+	logInfo("🔁 Changes since previous audit (%s):", prev.Digest)
+	for _, l := range lines {
+		logInfo("%s", l)
+	}
+}
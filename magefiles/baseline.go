@@ -0,0 +1,129 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// digestPattern matches a canonical sha256 content digest.
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// BaselineError lists every schema problem found in a baseline file, one per field.
+type BaselineError struct {
+	File     string
+	Problems []string
+}
+
+// Error formats the problems as an indented list under the file name.
+func (e *BaselineError) Error() string {
+	return fmt.Sprintf("invalid baseline %s:\n  - %s", e.File, strings.Join(e.Problems, "\n  - "))
+}
+
+// Validate checks the baseline schema: repository and tag present, a sha256
+// manifest list digest, and a sha256 digest for every required architecture.
+func (m MultiArchMetadata) Validate() []string {
+	var problems []string
+
+	if strings.TrimSpace(m.Repository) == "" {
+		problems = append(problems, "repository: must not be empty")
+	}
+	if strings.TrimSpace(m.Tag) == "" {
+		problems = append(problems, "tag: must not be empty")
+	}
+	switch {
+	case m.ManifestList == "":
+		problems = append(problems, "manifest_list: must not be empty")
+	case !digestPattern.MatchString(m.ManifestList):
+		problems = append(problems, fmt.Sprintf("manifest_list: %q is not a sha256:<64 hex> digest", m.ManifestList))
+	}
+
+	for _, arch := range supportedArches {
+		digest, ok := m.Digests[arch]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("digests.%s: missing required architecture", arch))
+			continue
+		}
+		if !digestPattern.MatchString(digest) {
+			problems = append(problems, fmt.Sprintf("digests.%s: %q is not a sha256:<64 hex> digest", arch, digest))
+		}
+	}
+
+	var extra []string
+	for arch := range m.Digests {
+		if !isValidArch(arch) {
+			extra = append(extra, arch)
+		}
+	}
+	sort.Strings(extra)
+	for _, arch := range extra {
+		problems = append(problems, fmt.Sprintf("digests.%s: unsupported architecture (allowed: %s)", arch, strings.Join(supportedArches, ", ")))
+	}
+
+	return problems
+}
+
+// decodeBaseline parses baseline data written either as YAML or as JSON
+// (JSON is valid YAML) and rejects unknown fields so typos are reported.
+func decodeBaseline(data []byte) (*MultiArchMetadata, error) {
+	var meta MultiArchMetadata
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&meta); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("file is empty")
+		}
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// loadBaseline loads and validates the upstream digest baseline generated by SrcDigest.Sync().
+// A missing file is reported with an error wrapping os.ErrNotExist.
+func loadBaseline() (*MultiArchMetadata, error) {
+	data, err := os.ReadFile(baselineFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read baseline file: %w", err)
+	}
+
+	meta, err := decodeBaseline(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", baselineFile, err)
+	}
+	if problems := meta.Validate(); len(problems) > 0 {
+		return nil, &BaselineError{File: baselineFile, Problems: problems}
+	}
+	return meta, nil
+}
+
+// saveBaseline validates meta and writes it to baseline.yaml as YAML.
+func saveBaseline(meta MultiArchMetadata) error {
+	if problems := meta.Validate(); len(problems) > 0 {
+		return &BaselineError{File: baselineFile, Problems: problems}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Upstream Factorio digests pinned by mage srcdigest:sync. Hand edits must keep this schema.\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(meta); err != nil {
+		return fmt.Errorf("failed to encode baseline metadata: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to encode baseline metadata: %v", err)
+	}
+
+	if err := writeFile(baselineFile, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write baseline file: %v", err)
+	}
+	return nil
+}
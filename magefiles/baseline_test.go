@@ -0,0 +1,113 @@
+//go:build mage
+
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	testListDigest  = "sha256:feeebedf6754969a43d7c08a48fc9508b2d91b34f5cbf1e120740b3fbdf3a75e"
+	testAmd64Digest = "sha256:66dc5854594d69001ae2b16107d7b595bdb6a1f4b99f2b2a4515841fe9dcf2c3"
+	testArm64Digest = "sha256:e6b99a934497925e36ba368d9e7b1c3b2b6a9a5e254b071a561205017748ff1b"
+)
+
+func TestDecodeBaseline(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"yaml", "repository: factoriotools/factorio\ntag: 2.0.69\nmanifest_list: " + testListDigest +
+			"\ndigests:\n  amd64: " + testAmd64Digest + "\n  arm64: " + testArm64Digest + "\n", ""},
+		{"legacy json", `{"repository":"factoriotools/factorio","tag":"2.0.69","manifest_list":"` + testListDigest +
+			`","digests":{"amd64":"` + testAmd64Digest + `","arm64":"` + testArm64Digest + `"},"updated_at":"2025-10-26T20:51:55Z"}`, ""},
+		{"unknown field", "repository: factoriotools/factorio\ntag: 2.0.69\nmanifest_lsit: " + testListDigest + "\n", "field manifest_lsit not found"},
+		{"empty", "", "file is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := decodeBaseline([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decodeBaseline error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeBaseline: %v", err)
+			}
+			if meta.Tag != "2.0.69" || meta.ManifestList != testListDigest || meta.Digests["arm64"] != testArm64Digest {
+				t.Errorf("decoded %+v", meta)
+			}
+			if problems := meta.Validate(); len(problems) != 0 {
+				t.Errorf("Validate = %v, want none", problems)
+			}
+		})
+	}
+}
+
+func TestBaselineValidate(t *testing.T) {
+	valid := func() MultiArchMetadata {
+		return MultiArchMetadata{
+			Repository:   "factoriotools/factorio",
+			Tag:          "2.0.69",
+			ManifestList: testListDigest,
+			Digests:      map[string]string{"amd64": testAmd64Digest, "arm64": testArm64Digest},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(m *MultiArchMetadata)
+		want   []string
+	}{
+		{"valid", func(m *MultiArchMetadata) {}, nil},
+		{"missing arch", func(m *MultiArchMetadata) { delete(m.Digests, "arm64") },
+			[]string{"digests.arm64: missing required architecture"}},
+		{"unsupported arch", func(m *MultiArchMetadata) { m.Digests["s390x"] = testAmd64Digest },
+			[]string{"digests.s390x: unsupported architecture"}},
+		{"malformed arch digest", func(m *MultiArchMetadata) { m.Digests["amd64"] = "sha256:66DC" },
+			[]string{`digests.amd64: "sha256:66DC" is not a sha256:<64 hex> digest`}},
+		{"malformed manifest list", func(m *MultiArchMetadata) { m.ManifestList = "md5:feee" },
+			[]string{`manifest_list: "md5:feee" is not a sha256:<64 hex> digest`}},
+		{"every problem", func(m *MultiArchMetadata) { *m = MultiArchMetadata{} },
+			[]string{"repository: must not be empty", "tag: must not be empty", "manifest_list: must not be empty",
+				"digests.amd64: missing required architecture", "digests.arm64: missing required architecture"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := valid()
+			tt.modify(&m)
+			got := m.Validate()
+			if len(got) != len(tt.want) {
+				t.Fatalf("Validate = %q, want %d problems %q", got, len(tt.want), tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("problem %d = %q, want %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadBaselineReportsEveryProblem(t *testing.T) {
+	newTestProject(t)
+	data := "repository: factoriotools/factorio\ntag: 2.0.69\nmanifest_list: " + testListDigest +
+		"\ndigests:\n  amd64: sha256:short\n  riscv64: " + testArm64Digest + "\n"
+	if err := os.WriteFile(baselineFile, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := loadBaseline()
+	var be *BaselineError
+	if !errors.As(err, &be) {
+		t.Fatalf("loadBaseline error = %v, want a *BaselineError", err)
+	}
+	if len(be.Problems) != 3 {
+		t.Errorf("problems = %q, want the amd64 digest, missing arm64 and unsupported riscv64", be.Problems)
+	}
+}
@@ -6,11 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
-	"time"
 
 	"github.com/magefile/mage/mg"
 )
@@ -20,73 +17,41 @@ import (
 type Build mg.Namespace
 
 const (
-	imageRepo          = "ghcr.io/henryhall897/factorio-hardened"
 	buildDataDir       = "builddata"
 	baselineFile       = buildDataDir + "/baseline.yaml" // output from SrcDigest
-	outputDockerfile   = "docker/output.Dockerfile"
+	outputDockerfile   = "docker/output.Dockerfile"      // pinned by Hardened:Prepare
 	hardenedDockerfile = "docker/Dockerfile"
 	localTestTag       = "factorio-hardened:dev"
 )
 
-// BuildMetadata stores internal build information.
-type BuildMetadata struct {
-	Version    string `json:"version"`
-	BaseDigest string `json:"base_digest"`
-	UpdatedAt  string `json:"updated_at"`
-}
-
 // --- helpers ---
 
-// loadBaseline loads the upstream digest baseline generated by SrcDigest.Sync().
-func loadBaseline() (*MultiArchMetadata, error) {
-	data, err := os.ReadFile(baselineFile)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read baseline file: %v", err)
-	}
-	var meta MultiArchMetadata
-	if err := json.Unmarshal(data, &meta); err != nil {
-		return nil, fmt.Errorf("failed to parse baseline.yaml: %v", err)
-	}
-	return &meta, nil
-}
-
 // getFactorioVersion extracts the Factorio version from baseline.yaml, labels,
 // or by invoking the binary with --version in the container (safe for headless).
 func getFactorioVersion(imageRef string) (string, error) {
 	// 1️⃣ Try from baseline.yaml
-	if _, err := os.Stat(baselineFile); err == nil {
-		data, err := os.ReadFile(baselineFile)
-		if err == nil {
-			var meta struct {
-				Version string `json:"version,omitempty"`
-				Tag     string `json:"tag,omitempty"`
-			}
-			if jsonErr := json.Unmarshal(data, &meta); jsonErr == nil {
-				if strings.TrimSpace(meta.Version) != "" {
-					fmt.Printf("📘 Using Factorio version from baseline: %s\n", meta.Version)
-					return strings.TrimSpace(meta.Version), nil
-				}
-				if meta.Tag != "" && meta.Tag != "latest" {
-					fmt.Printf("📘 Using Factorio tag from baseline: %s\n", meta.Tag)
-					return meta.Tag, nil
-				}
-			}
+	if meta, err := loadBaseline(); err == nil {
+		if strings.TrimSpace(meta.Version) != "" {
+			logInfo("📘 Using Factorio version from baseline: %s", meta.Version)
+			return strings.TrimSpace(meta.Version), nil
+		}
+		if meta.Tag != "" && meta.Tag != "latest" {
+			logInfo("📘 Using Factorio tag from baseline: %s", meta.Tag)
+			return meta.Tag, nil
 		}
 	}
 
 	// 2️⃣ Try from label
-	cmd := exec.Command("docker", "inspect", "--format", "{{index .Config.Labels \"org.opencontainers.image.version\"}}", imageRef)
-	out, err := cmd.CombinedOutput()
+	out, err := cmdCombinedOutput("docker", "inspect", "--format", "{{index .Config.Labels \"org.opencontainers.image.version\"}}", imageRef)
 	if err == nil && strings.TrimSpace(string(out)) != "" {
 		version := strings.TrimSpace(string(out))
-		fmt.Printf("📦 Using Factorio version from image label: %s\n", version)
+		logInfo("📦 Using Factorio version from image label: %s", version)
 		return version, nil
 	}
 
 	// 3️⃣ Fallback: run the binary directly with --version (headless-safe)
-	fmt.Println("ℹ️  Extracting Factorio version via binary output...")
-	cmd = exec.Command("docker", "run", "--rm", "--entrypoint", "/opt/factorio/bin/x64/factorio", imageRef, "--version")
-	out, err = cmd.CombinedOutput()
+	logInfo("ℹ️  Extracting Factorio version via binary output...")
+	out, err = cmdCombinedOutput("docker", "run", "--rm", "--entrypoint", "/opt/factorio/bin/x64/factorio", imageRef, "--version")
 	if err != nil {
 		return "", fmt.Errorf("failed to extract Factorio version: %v\n%s", err, string(out))
 	}
@@ -101,44 +66,135 @@ func getFactorioVersion(imageRef string) (string, error) {
 	return "unknown", nil
 }
 
-// runCmd runs a command and prints its stdout/stderr inline.
-func runCmd(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// verifyKyverno evaluates the restricted Pod spec for image against the bundled
+// Kyverno policies and fails on any violation.
+func verifyKyverno(image string) error {
+	logInfo("🔒 Verifying Kyverno policy compliance...")
+	if _, err := checkPolicy(image); err != nil {
+		return err
+	}
+	logInfo("✅ Kyverno policies passed. Report → %s", policyReportPath())
+	return nil
 }
 
-// verifyKyverno runs kyverno CLI checks to confirm security policies are satisfied.
-func verifyKyverno(image string) error {
-	fmt.Println("🔒 Verifying Kyverno policy compliance...")
-	// Example: using kyverno-cli verifyimage or policy reports
-	return runCmd("kyverno", "verifyimage", image)
+// Kinds of manifests recorded for a pushed image index.
+const (
+	manifestKindImage       = "image"
+	manifestKindAttestation = "attestation"
+)
+
+// ManifestRecord is one child manifest of the pushed image index.
+type ManifestRecord struct {
+	Digest    string `json:"Digest"`
+	MediaType string `json:"MediaType"`
+	Kind      string `json:"Kind"`               // "image" or "attestation"
+	Platform  string `json:"Platform,omitempty"` // os/arch[/variant]; for attestations, the subject's platform
+	Subject   string `json:"Subject,omitempty"`  // attestations only: digest of the image manifest described
+}
+
+// pushedIndex is the verified state of a multi-arch image in the registry.
+type pushedIndex struct {
+	Digest      string
+	ArchDigests map[string]string
+	Manifests   []ManifestRecord
+}
+
+// readBuildxDigest returns the image index digest buildx reported in its --metadata-file.
+func readBuildxDigest(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read buildx metadata: %v", err)
+	}
+	var md struct {
+		Digest string `json:"containerimage.digest"`
+	}
+	if err := json.Unmarshal(data, &md); err != nil {
+		return "", fmt.Errorf("failed to parse buildx metadata: %v", err)
+	}
+	if !digestPattern.MatchString(md.Digest) {
+		return "", fmt.Errorf("buildx metadata has no image digest")
+	}
+	return md.Digest, nil
+}
+
+// resolvePushedIndex fetches the image index that tag points at, checks it
+// matches the digest buildx reported (if any), re-fetches it by digest and
+// confirms every child manifest resolves, then returns the verified digests.
+func resolvePushedIndex(tag, expected string) (*pushedIndex, error) {
+	ref, err := parseImageRef(tag)
+	if err != nil {
+		return nil, err
+	}
+	client := newAuthenticatedRegistryClient(ref)
+
+	_, digest, err := client.GetIndex(ref.Repository, ref.Reference)
+	if err != nil {
+		return nil, err
+	}
+	if expected != "" && digest != expected {
+		return nil, fmt.Errorf("%s points at %s but buildx pushed %s", tag, digest, expected)
+	}
+
+	idx, byDigest, err := client.GetIndex(ref.Repository, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-fetch index by digest: %v", err)
+	}
+	if byDigest != digest {
+		return nil, fmt.Errorf("index digest changed between fetches (%s vs %s)", digest, byDigest)
+	}
+
+	platforms := make(map[string]string)
+	for _, m := range idx.Manifests {
+		if !m.IsAttestation() {
+			platforms[m.Digest] = m.PlatformString()
+		}
+	}
+
+	result := &pushedIndex{Digest: digest, ArchDigests: idx.ArchDigests()}
+	for _, m := range idx.Manifests {
+		got, err := client.HeadManifest(ref.Repository, m.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s listed in index is not retrievable: %v", m.Digest, err)
+		}
+		if got != m.Digest {
+			return nil, fmt.Errorf("registry returned %s for manifest %s", got, m.Digest)
+		}
+
+		rec := ManifestRecord{Digest: m.Digest, MediaType: m.MediaType, Kind: manifestKindImage, Platform: m.PlatformString()}
+		if m.IsAttestation() {
+			rec.Kind = manifestKindAttestation
+			rec.Subject = m.Annotations["vnd.docker.reference.digest"]
+			rec.Platform = platforms[rec.Subject]
+		}
+		result.Manifests = append(result.Manifests, rec)
+	}
+
+	for _, arch := range supportedArches {
+		if _, ok := result.ArchDigests[arch]; !ok {
+			return nil, fmt.Errorf("pushed index %s has no linux/%s image", digest, arch)
+		}
+	}
+	return result, nil
 }
 
 // ensureDirs creates the required builddata directory structure.
 // It guarantees that builddata/, builddata/test/, and builddata/prod/ exist.
 func ensureDirs() error {
-	dirs := []string{
+	return makeDirs(
 		buildDataDir,
 		filepath.Join(buildDataDir, "test"),
 		filepath.Join(buildDataDir, "prod"),
-	}
-
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0o755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
-		}
-	}
-	return nil
+	)
 }
 
 // --- Targets ---
 
 // Test builds a single-arch local image (amd64) for developer validation.
 // It does not push to GHCR and intentionally avoids tagging with game version.
-func (Build) Test() error {
-	fmt.Println("🧪 Running Build:Test (local single-arch build)...")
+func (Build) Test() (err error) {
+	run := startTarget("build:test")
+	defer func() { run.finish(err) }()
+	logInfo("🧪 Running Build:Test (local single-arch build)...")
 
 	meta, err := loadBaseline()
 	if err != nil {
@@ -148,16 +204,16 @@ func (Build) Test() error {
 	if baseDigest == "" {
 		return fmt.Errorf("no amd64 digest found in baseline")
 	}
-	dockerfile := hardenedDockerfile
-	if _, err := os.Stat(outputDockerfile); err == nil {
-		dockerfile = outputDockerfile
+	dockerfile, err := buildDockerfile(meta)
+	if err != nil {
+		return err
 	}
 
 	// Step 1: Build local single-arch image
-	if err := runCmd("docker", "buildx", "build",
+	run.step("build")
+	if err := runMutating(planBuild, "docker", "buildx", "build",
 		"--platform", "linux/amd64",
 		"--file", dockerfile,
-		"--build-arg", fmt.Sprintf("BASE_IMAGE_DIGEST=%s", baseDigest),
 		"--no-cache",
 		"--tag", localTestTag,
 		"--load",
@@ -165,90 +221,92 @@ func (Build) Test() error {
 	); err != nil {
 		return fmt.Errorf("local build failed: %v", err)
 	}
+	if dryRun() {
+		// Everything after the build inspects the image it would produce.
+		plan(planCheck, "Trivy scan of %s against %s", localTestTag, vulnPolicyPath)
+		plan(planCheck, "Kyverno policies against %s", localTestTag)
+		plan(planRun, "docker run --rm --read-only --name factorio-test %s --version", localTestTag)
+		plan(planWrite, "%s", buildRecordPath(envTest))
+		return nil
+	}
 
 	// Step 2: Run Trivy scan on *local* tag
-	os.Setenv("IMAGE", localTestTag)
-	if err := (Trivy{}.ImageScan)(); err != nil {
+	run.step("scan")
+	scan, err := scanImageVulns(localTestTag, dockerfile, filepath.Join(buildDataDir, envTest))
+	if err != nil {
 		return fmt.Errorf("Trivy scan failed: %v", err)
 	}
 
-	// Step 3: (Optional) Kyverno check
+	// Step 3: Kyverno policy check
+	run.step("policy")
 	if err := verifyKyverno(localTestTag); err != nil {
-		fmt.Println("⚠️  Kyverno verification skipped or failed:", err)
+		return fmt.Errorf("Kyverno policy check failed: %v", err)
 	}
 
 	// Step 4: (Optional) smoke test run
-	fmt.Println("🚀 Launching short Factorio container test...")
-	_ = exec.Command("docker", "rm", "-f", "factorio-test").Run()
-	run := exec.Command("docker", "run", "--rm", "--read-only",
+	run.step("smoke test")
+	logInfo("🚀 Launching short Factorio container test...")
+	_ = runQuiet("docker", "rm", "-f", "factorio-test")
+	_ = runCmd("docker", "run", "--rm", "--read-only",
 		"--name", "factorio-test", localTestTag, "--version")
-	run.Stdout = os.Stdout
-	run.Stderr = os.Stderr
-	_ = run.Run()
 
 	// Step 5: Print image digest for verification
-	fmt.Println("🔎 Inspecting built image digest...")
-	inspectCmd := exec.Command("docker", "inspect", "--format", "{{index .RepoDigests 0}}", localTestTag)
-	digestOut, err := inspectCmd.CombinedOutput()
+	run.step("inspect digest")
+	logInfo("🔎 Inspecting built image digest...")
+	digestOut, err := cmdCombinedOutput("docker", "inspect", "--format", "{{index .RepoDigests 0}}", localTestTag)
 	var imageDigest string
 
 	if err != nil || len(strings.TrimSpace(string(digestOut))) == 0 {
-		fmt.Println("ℹ️  No RepoDigest found (image not pushed). Using local image ID instead...")
-		idCmd := exec.Command("docker", "inspect", "--format", "{{.Id}}", localTestTag)
-		idOut, idErr := idCmd.CombinedOutput()
+		logInfo("ℹ️  No RepoDigest found (image not pushed). Using local image ID instead...")
+		idOut, idErr := cmdCombinedOutput("docker", "inspect", "--format", "{{.Id}}", localTestTag)
 		if idErr != nil {
-			fmt.Printf("⚠️  Failed to retrieve local image ID: %v\n", idErr)
+			logWarn("Failed to retrieve local image ID: %v", idErr)
 			imageDigest = "unknown"
 		} else {
 			imageDigest = strings.TrimSpace(string(idOut))
-			fmt.Printf("📦 Local image ID: %s\n", imageDigest)
+			logInfo("📦 Local image ID: %s", imageDigest)
 		}
 	} else {
 		imageDigest = strings.TrimSpace(string(digestOut))
-		fmt.Printf("📦 Local image digest: %s\n", imageDigest)
+		logInfo("📦 Local image digest: %s", imageDigest)
 	}
 
 	// Step 6: Write metadata snapshot (always fresh)
-	if err := ensureDirs(); err != nil {
+	run.step("write record")
+	rec := newBuildRecord(envTest, meta, dockerfile, "")
+	rec.BaseDigest = meta.ManifestList
+	rec.Arch = "amd64"
+	rec.Version = "dev"
+	rec.Tag = localTestTag
+	rec.Digest = imageDigest
+	rec.Scan = scanSummary(scan)
+
+	if err := writeBuildRecord(rec); err != nil {
 		return err
 	}
+	buildDataPath := buildRecordPath(envTest)
 
-	buildDataPath := filepath.Join(buildDataDir, "test", "builddata.json")
-
-	metaOut := map[string]any{
-		"BaseDigest": baseDigest,
-		"Arch":       "amd64",
-		"Version":    "dev",
-		"Tag":        localTestTag,
-		"Digest":     imageDigest, // ✅ include digest
-		"BuiltAt":    time.Now().UTC().Format(time.RFC3339Nano),
-	}
-
-	file, err := os.Create(buildDataPath)
-	if err != nil {
-		return fmt.Errorf("failed to write test builddata: %v", err)
-	}
-	defer file.Close()
-
-	enc := json.NewEncoder(file)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(metaOut); err != nil {
-		return fmt.Errorf("failed to encode metadata: %v", err)
-	}
-
-	fmt.Printf("🧾 Test build metadata written → %s\n", buildDataPath)
-	fmt.Printf("📦 Digest recorded: %s\n", imageDigest)
-	fmt.Println("✅ Local test build and scan completed successfully.")
+	logInfo("🧾 Test build metadata written → %s", buildDataPath)
+	logInfo("📦 Digest recorded: %s", imageDigest)
+	logInfo("✅ Local test build and scan completed successfully.")
 
 	return nil
 }
 
 // Prod performs a production-grade multi-arch build (amd64 + arm64),
-// runs security scans and Kyverno verification, and pushes to GHCR.
-func (Build) Prod() error {
-	fmt.Println("🧱 Running Build:Prod (multi-arch CI build)...")
+// runs security scans and Kyverno verification, pushes to GHCR, and signs the result.
+func (Build) Prod() (err error) {
+	run := startTarget("build:prod")
+	defer func() { run.finish(err) }()
+	logInfo("🧱 Running Build:Prod (multi-arch CI build)...")
+
+	cfg, err := projectConfig()
+	if err != nil {
+		return err
+	}
 
 	// Step 1: Load baseline (source of truth from SrcDigest)
+	run.step("load baseline")
 	meta, err := loadBaseline()
 	if err != nil {
 		return err
@@ -259,140 +317,141 @@ func (Build) Prod() error {
 	}
 
 	// Step 2: Detect Factorio version from upstream image
-	version, err := getFactorioVersion(upstreamImage)
+	run.step("detect version")
+	version, err := getFactorioVersion(cfg.UpstreamImage)
 	if err != nil {
 		return fmt.Errorf("failed to detect Factorio version: %v", err)
 	}
-	tag := fmt.Sprintf("%s:%s", imageRepo, version)
+	tag := fmt.Sprintf("%s:%s", cfg.ImageRepo, version)
 
-	fmt.Printf("🎮 Detected Factorio version: %s\n", version)
-	fmt.Printf("📘 Using upstream manifest list: %s\n", baseDigest)
+	logInfo("🎮 Detected Factorio version: %s", version)
+	logInfo("📘 Using upstream manifest list: %s", baseDigest)
 
 	// Step 3: Build amd64 image locally for scanning
-	dockerfilePath, err := filepath.Abs(hardenedDockerfile)
+	run.step("build amd64")
+	dockerfile, err := buildDockerfile(meta)
 	if err != nil {
-		return fmt.Errorf("failed to resolve Dockerfile path: %v", err)
+		return err
 	}
-	if _, err := os.Stat(dockerfilePath); err != nil {
-		return fmt.Errorf("Dockerfile not found at %s", dockerfilePath)
+	dockerfilePath, err := filepath.Abs(dockerfile)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Dockerfile path: %v", err)
 	}
-	fmt.Printf("📄 Using Dockerfile: %s\n", dockerfilePath)
+	logInfo("📄 Using Dockerfile: %s", dockerfilePath)
 
-	fmt.Println("🧩 Building amd64 variant for vulnerability scan...")
-	if err := runCmd("docker", "buildx", "build",
-		"--builder", "hardened-builder",
+	logInfo("🧩 Building amd64 variant for vulnerability scan...")
+	if err := runMutating(planBuild, "docker", "buildx", "build",
+		"--builder", cfg.Builder,
 		"--platform", "linux/amd64",
 		"--file", dockerfilePath,
-		"--build-arg", fmt.Sprintf("BASE_IMAGE_DIGEST=%s", baseDigest),
 		"--tag", tag+"-amd64",
 		"--load",
 		".",
 	); err != nil {
 		return fmt.Errorf("amd64 prebuild failed: %v", err)
 	}
+	if dryRun() {
+		// Everything after the prebuild depends on images that were not built.
+		plan(planCheck, "Trivy scan of %s-amd64 against %s", tag, vulnPolicyPath)
+		plan(planCheck, "Kyverno policies against %s-amd64", tag)
+		plan(planBuild, "linux/amd64,linux/arm64 image from %s", dockerfilePath)
+		plan(planPush, "%s", tag)
+		plan(planTag, "%s → new image index digest", tag)
+		plan(planSign, "image index of %s → push its .sig tag", tag)
+		plan(planPush, "SBOMs of %s → its .sbom tag", tag)
+		plan(planWrite, "%s", buildRecordPath(envProd))
+		return nil
+	}
 
 	// Step 4: Run Trivy scan
-	fmt.Println("🔍 Running Trivy vulnerability scan...")
-	if err := runCmd("trivy", "image", "--severity", "HIGH,CRITICAL", "--exit-code", "1", tag+"-amd64"); err != nil {
+	run.step("scan")
+	scan, err := scanImageVulns(tag+"-amd64", dockerfile, filepath.Join(buildDataDir, envProd))
+	if err != nil {
 		return fmt.Errorf("Trivy scan failed: %v", err)
 	}
 
 	// Step 5: Verify Kyverno compliance
-	fmt.Println("🔒 Verifying Kyverno policy compliance...")
+	run.step("policy")
 	if err := verifyKyverno(tag + "-amd64"); err != nil {
-		fmt.Println("⚠️  Kyverno verification skipped or failed:", err)
+		return fmt.Errorf("Kyverno policy check failed: %v", err)
 	}
 
 	// Step 6: Push multi-arch image to GHCR
-	fmt.Println("🚀 Building and pushing multi-arch image (amd64 + arm64)...")
+	run.step("push")
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	metadataFile := filepath.Join(buildDataDir, envProd, "buildx-metadata.json")
+	logInfo("🚀 Building and pushing multi-arch image (amd64 + arm64)...")
 	if err := runCmd("docker", "buildx", "build",
 		"--no-cache",
 		"--progress", "plain",
 		"--platform", "linux/amd64,linux/arm64",
-		"--file", hardenedDockerfile,
-		"--build-arg", fmt.Sprintf("BASE_IMAGE_DIGEST=%s", baseDigest),
+		"--file", dockerfilePath,
 		"--tag", tag,
+		"--metadata-file", metadataFile,
 		"--push",
 		".",
 	); err != nil {
 		return fmt.Errorf("multi-arch push failed: %v", err)
 	}
 
-	// Step 7: Inspect final GHCR digest
-	fmt.Println("🔎 Inspecting pushed image digest...")
-	inspectCmd := exec.Command("docker", "buildx", "imagetools", "inspect", tag, "--raw")
-	digestOut, err := inspectCmd.CombinedOutput()
-
-	var imageDigest string
+	// Step 7: Resolve and verify the pushed image index from the registry
+	run.step("verify push")
+	logInfo("🔎 Verifying pushed image index against the registry...")
+	pushedDigest, err := readBuildxDigest(metadataFile)
 	if err != nil {
-		fmt.Printf("⚠️  Failed to inspect image digest: %v\n%s\n", err, string(digestOut))
-		imageDigest = "unknown"
-	} else {
-		// Parse raw JSON and extract top-level manifest digest
-		var manifest struct {
-			SchemaVersion int    `json:"schemaVersion"`
-			MediaType     string `json:"mediaType"`
-			Config        struct {
-				Digest string `json:"digest"`
-			} `json:"config"`
-			Manifests []struct {
-				Digest string `json:"digest"`
-			} `json:"manifests"`
-		}
-		if err := json.Unmarshal(digestOut, &manifest); err == nil {
-			if len(manifest.Manifests) > 0 {
-				imageDigest = strings.TrimSpace(manifest.Manifests[0].Digest)
-			} else if manifest.Config.Digest != "" {
-				imageDigest = strings.TrimSpace(manifest.Config.Digest)
-			}
-		}
-
-		if imageDigest == "" {
-			// fallback to regex (for Buildx log output)
-			re := regexp.MustCompile(`sha256:[a-f0-9]{64}`)
-			if m := re.FindString(string(digestOut)); m != "" {
-				imageDigest = m
-			}
-		}
-
-		if imageDigest == "" {
-			imageDigest = "unknown"
-			fmt.Println("⚠️  Could not parse image digest from output.")
-		} else {
-			fmt.Printf("📦 Multi-arch manifest digest: %s\n", imageDigest)
-		}
+		logWarn("%v; relying on registry lookup only.", err)
 	}
-
-	// Step 8: Write metadata snapshot
-	if err := ensureDirs(); err != nil {
-		return err
+	pushed, err := resolvePushedIndex(tag, pushedDigest)
+	if err != nil {
+		return fmt.Errorf("pushed image verification failed: %v", err)
 	}
-
-	buildDataPath := filepath.Join(buildDataDir, "prod", "builddata.json")
-	metaOut := map[string]any{
-		"BaseDigest": baseDigest,
-		"Arch":       "multi-arch",
-		"Version":    version,
-		"Tag":        tag,
-		"Digest":     imageDigest,
-		"BuiltAt":    time.Now().UTC().Format(time.RFC3339Nano),
+	imageDigest := pushed.Digest
+	logInfo("📦 Image index digest: %s", imageDigest)
+	for _, m := range pushed.Manifests {
+		if m.Kind == manifestKindAttestation {
+			logInfo("   attestation for %s: %s", m.Subject, m.Digest)
+		} else {
+			logInfo("   %s: %s", m.Platform, m.Digest)
+		}
 	}
 
-	file, err := os.Create(buildDataPath)
+	// Step 8: Sign the pushed index and verify the signature before declaring success
+	run.step("sign")
+	logInfo("✍️  Signing pushed image index...")
+	signature, err := signPushedImage(tag, imageDigest)
 	if err != nil {
-		return fmt.Errorf("failed to write prod builddata: %v", err)
-	}
-	defer file.Close()
-
-	enc := json.NewEncoder(file)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(metaOut); err != nil {
-		return fmt.Errorf("failed to encode metadata: %v", err)
+		return fmt.Errorf("image signing failed: %v", err)
+	}
+	logInfo("🔏 Signature verified: %s", signature)
+
+	// Step 9: Generate SBOMs and attach them to the pushed index
+	run.step("sbom")
+	rec := newBuildRecord(envProd, meta, dockerfilePath, cfg.Builder)
+	rec.BaseDigest = baseDigest
+	rec.Arch = "multi-arch"
+	rec.Version = version
+	rec.Tag = tag
+	rec.Digest = imageDigest
+	rec.ArchDigests = pushed.ArchDigests
+	rec.Manifests = pushed.Manifests
+	rec.Signature = signature
+	rec.Scan = scanSummary(scan)
+	if rec.SBOM, err = publishSboms(rec); err != nil {
+		return fmt.Errorf("SBOM attachment failed: %v", err)
+	}
+
+	// Step 10: Write metadata snapshot
+	run.step("write record")
+	if err := writeBuildRecord(rec); err != nil {
+		return err
 	}
+	buildDataPath := buildRecordPath(envProd)
 
-	fmt.Printf("🧾 Prod build metadata written → %s\n", buildDataPath)
-	fmt.Printf("📦 Digest recorded: %s\n", imageDigest)
-	fmt.Printf("✅ Multi-arch image %s pushed successfully.\n", tag)
+	logInfo("🧾 Prod build metadata written → %s", buildDataPath)
+	logInfo("📦 Digest recorded: %s", imageDigest)
+	logInfo("✅ Multi-arch image %s@%s pushed successfully.", tag, imageDigest)
 
 	return nil
 }
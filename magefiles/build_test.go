@@ -0,0 +1,323 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Where the fake runner pretends the scanners are installed.
+const (
+	fakeTrivy   = "/fake/bin/trivy"
+	fakeKyverno = "/fake/bin/kyverno"
+)
+
+// useProjectConfig drops the cached project configuration so that settings
+// from the test's environment apply, and drops it again afterwards.
+func useProjectConfig(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		loadProjectConfigOnce = sync.OnceValues(func() (*ProjectConfig, error) {
+			return loadProjectConfig(configPath())
+		})
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// newScanProject is newTestProject plus what scanning and policy checks
+// need: the repository's vulnerability policy, a fresh pinned Trivy DB and a
+// tools lockfile pinning trivy, which the fake runner finds at fakeTrivy.
+func newScanProject(t *testing.T) {
+	t.Helper()
+	policy, err := os.ReadFile(filepath.Join("..", vulnPolicyPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTestProject(t)
+	writePolicy(t, string(policy))
+	writeTrivyDB(t)
+	lock := fmt.Sprintf("tools:\n  trivy:\n    version: 0.65.0\n    url: https://example.invalid/trivy.tar.gz\n    sha256:\n      %s: %s\n",
+		hostPlatform(), strings.Repeat("a", 64))
+	if err := os.WriteFile("tools.lock.yaml", []byte(lock), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOOLS_LOCK", "tools.lock.yaml")
+	t.Setenv("TOOLS_BIN", t.TempDir())
+	useProjectConfig(t)
+}
+
+// cleanTrivyReport is a Trivy JSON report of image without findings, built
+// on the single-layer base image that the docker response in scanResponses reports.
+func cleanTrivyReport(image string) string {
+	return fmt.Sprintf(`{
+  "SchemaVersion": 2,
+  "ArtifactName": %q,
+  "ArtifactType": "container_image",
+  "Metadata": {
+    "ImageID": %q,
+    "DiffIDs": ["sha256:base"],
+    "ImageConfig": {"architecture": "amd64", "os": "linux", "history": [{"created_by": "/bin/sh -c #(nop) ADD file:0123 in / "}]}
+  },
+  "Results": [{"Target": "debian 12.7", "Class": "os-pkgs", "Type": "debian"}]
+}`, image, testImageID)
+}
+
+// scanResponses answers the Trivy scan of image into reportDir and the
+// Kyverno check that follow every build.
+func scanResponses(image, reportDir string) []FakeResponse {
+	return []FakeResponse{
+		{Match: fakeTrivy + " --version", Stdout: "Version: 0.65.0\n"},
+		{Match: fakeTrivy + " image --quiet --cache-dir", Files: map[string]string{
+			filepath.Join(reportDir, "trivy.json"): cleanTrivyReport(image),
+		}},
+		{Match: "docker image inspect --format {{json .RootFS.Layers}}", Stdout: `["sha256:base"]`},
+		{Match: fakeKyverno + " apply", Stdout: "apiVersion: wgpolicyk8s.io/v1alpha2\nkind: ClusterPolicyReport\nresults:\n- policy: restricted\n  rule: run-as-non-root\n  result: pass\n"},
+		// Build records note the toolchain and repository state.
+		{Match: "git rev-parse HEAD", Stdout: "0123456789abcdef\n"},
+		{Match: "git status --porcelain"},
+		{Match: "docker version", Stdout: "27.3.1\n"},
+		{Match: "docker buildx version", Stdout: "github.com/docker/buildx v0.18.0\n"},
+	}
+}
+
+// newScanRunner returns a fake runner with both scanners on its PATH.
+func newScanRunner(responses ...FakeResponse) *FakeRunner {
+	return &FakeRunner{
+		Responses: responses,
+		Paths:     map[string]string{"trivy": fakeTrivy, "kyverno": fakeKyverno},
+	}
+}
+
+// assertRan fails unless every line in want was run, exactly and in that order.
+func assertRan(t *testing.T, fake *FakeRunner, want ...string) {
+	t.Helper()
+	calls := fake.Calls()
+	i := 0
+	for _, line := range calls {
+		if i < len(want) && line == want[i] {
+			i++
+		}
+	}
+	if i < len(want) {
+		t.Errorf("command %q not run in order; ran:\n  %s", want[i], strings.Join(calls, "\n  "))
+	}
+}
+
+// readRecord loads the build record written for env.
+func readRecord(t *testing.T, env string) *BuildRecord {
+	t.Helper()
+	data, err := os.ReadFile(buildRecordPath(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec BuildRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	return &rec
+}
+
+func TestBuildTest(t *testing.T) {
+	newScanProject(t)
+	const localDigest = "factorio-hardened@sha256:4444444444444444444444444444444444444444444444444444444444444444"
+	fake := newScanRunner(append([]FakeResponse{
+		{Match: "docker buildx build"},
+		{Match: "docker rm -f factorio-test"},
+		{Match: "docker run --rm --read-only", Stdout: "2.0.69 (build 83123)\n"},
+		{Match: "docker inspect --format {{index .RepoDigests 0}}", Stdout: localDigest + "\n"},
+	}, scanResponses(localTestTag, "builddata/test")...)...)
+	defer useRunner(fake)()
+
+	var err error
+	out := captureStdout(t, func() { err = (Build{}).Test() })
+	if err != nil {
+		t.Fatalf("Build:Test: %v\n%s", err, out)
+	}
+
+	assertRan(t, fake,
+		"docker buildx build --platform linux/amd64 --file docker/output.Dockerfile --no-cache --tag factorio-hardened:dev --load .",
+		fakeTrivy+" image --quiet --cache-dir .cache/trivy --skip-db-update --scanners vuln --format json --output builddata/test/trivy.json factorio-hardened:dev",
+		fakeKyverno+" apply policies/kyverno --resource builddata/policy/pod.yaml --policy-report",
+		"docker rm -f factorio-test",
+		"docker run --rm --read-only --name factorio-test factorio-hardened:dev --version",
+		"docker inspect --format {{index .RepoDigests 0}} factorio-hardened:dev",
+	)
+	rec := readRecord(t, envTest)
+	if rec.Digest != localDigest || rec.Tag != localTestTag {
+		t.Errorf("record is for %s (%s), want %s (%s)", rec.Tag, rec.Digest, localTestTag, localDigest)
+	}
+	wantReport := filepath.Join("builddata/test", "trivy-"+strings.TrimPrefix(testImageID, "sha256:")+".json")
+	if rec.Scan == nil || !rec.Scan.Passed || rec.Scan.Report != wantReport || rec.Scan.ImageID != testImageID {
+		t.Errorf("record scan = %+v, want a passed scan stored at %s", rec.Scan, wantReport)
+	}
+}
+
+func TestBuildTestBuildFails(t *testing.T) {
+	newScanProject(t)
+	fake := newScanRunner(append([]FakeResponse{
+		{Match: "docker buildx build", ExitCode: 1},
+	}, scanResponses(localTestTag, "builddata/test")...)...)
+	defer useRunner(fake)()
+
+	var err error
+	captureStdout(t, func() { err = (Build{}).Test() })
+	if err == nil || !strings.Contains(err.Error(), "local build failed") || !strings.Contains(err.Error(), "exit status 1") {
+		t.Fatalf("Build:Test error = %v, want the failed build", err)
+	}
+	if fake.Ran(fakeTrivy+" image") || fake.Ran("docker run") {
+		t.Errorf("kept going after the build failed: %v", fake.Calls())
+	}
+	if _, err := os.Stat(buildRecordPath(envTest)); !os.IsNotExist(err) {
+		t.Errorf("wrote %s after the build failed", buildRecordPath(envTest))
+	}
+}
+
+// prodBuild is a Build:Prod setup: a fake registry holding what the
+// multi-arch push leaves behind, and the commands the build runs.
+type prodBuild struct {
+	reg         *fakeRegistry
+	repo, tag   string
+	index       string
+	archDigests map[string]string
+	responses   []FakeResponse // prebuild, push and SBOM scans; add scanResponses last
+}
+
+func newProdBuild(t *testing.T) *prodBuild {
+	t.Helper()
+	newScanProject(t)
+	t.Setenv("HOME", t.TempDir())
+	p := &prodBuild{reg: newFakeRegistry(t), repo: "henryhall897/factorio-hardened", archDigests: map[string]string{}}
+	t.Setenv("IMAGE_REPO", p.reg.host()+"/"+p.repo)
+	captureStdout(t, func() {
+		if err := (Sign{}).Keygen(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	idx := ImageIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex}
+	for _, arch := range supportedArches {
+		digest := p.reg.putManifest(p.repo, "", mediaTypeOCIManifest, []byte(fmt.Sprintf(`{"schemaVersion":2,"arch":%q}`, arch)))
+		p.archDigests[arch] = digest
+		idx.Manifests = append(idx.Manifests, IndexManifest{MediaType: mediaTypeOCIManifest, Digest: digest,
+			Platform: &ImagePlatform{OS: "linux", Architecture: arch}})
+	}
+	p.index = p.reg.putJSONManifest(p.repo, "2.0.69", mediaTypeOCIIndex, idx)
+
+	p.tag = p.reg.host() + "/" + p.repo + ":2.0.69"
+	p.responses = []FakeResponse{
+		{Match: "docker buildx build --builder hardened-builder --platform linux/amd64 "},
+		{Match: "docker buildx build --no-cache", Files: map[string]string{
+			"builddata/prod/buildx-metadata.json": fmt.Sprintf(`{"containerimage.digest":%q}`, p.index),
+		}},
+	}
+	for _, arch := range supportedArches {
+		for _, f := range sbomFormats {
+			out := filepath.Join(sbomDir(envProd), arch+"."+f.suffix)
+			p.responses = append(p.responses, FakeResponse{
+				Match: fakeTrivy + " image --quiet --format " + f.format + " --output " + out,
+				Files: map[string]string{out: fmt.Sprintf(`{"format":%q,"arch":%q}`, f.format, arch)},
+			})
+		}
+	}
+	return p
+}
+
+// fakeRunner returns a FakeRunner for responses followed by the scan of the prebuilt image.
+func (p *prodBuild) fakeRunner(responses []FakeResponse) *FakeRunner {
+	return newScanRunner(append(responses, scanResponses(p.tag+"-amd64", "builddata/prod")...)...)
+}
+
+func TestBuildProd(t *testing.T) {
+	p := newProdBuild(t)
+	fake := p.fakeRunner(p.responses)
+	defer useRunner(fake)()
+
+	var err error
+	out := captureStdout(t, func() { err = (Build{}).Prod() })
+	if err != nil {
+		t.Fatalf("Build:Prod: %v\n%s", err, out)
+	}
+
+	dockerfile, err := filepath.Abs(outputDockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := p.reg.host() + "/" + p.repo
+	assertRan(t, fake,
+		"docker buildx build --builder hardened-builder --platform linux/amd64 --file "+dockerfile+" --tag "+p.tag+"-amd64 --load .",
+		fakeTrivy+" image --quiet --cache-dir .cache/trivy --skip-db-update --scanners vuln --format json --output builddata/prod/trivy.json "+p.tag+"-amd64",
+		fakeKyverno+" apply policies/kyverno --resource builddata/policy/pod.yaml --policy-report",
+		"docker buildx build --no-cache --progress plain --platform linux/amd64,linux/arm64 --file "+dockerfile+
+			" --tag "+p.tag+" --metadata-file builddata/prod/buildx-metadata.json --push .",
+		fakeTrivy+" image --quiet --format spdx-json --output builddata/prod/sbom/amd64.spdx.json --platform linux/amd64 "+image+"@"+p.archDigests["amd64"],
+		fakeTrivy+" image --quiet --format cyclonedx --output builddata/prod/sbom/amd64.cdx.json --platform linux/amd64 "+image+"@"+p.archDigests["amd64"],
+		fakeTrivy+" image --quiet --format spdx-json --output builddata/prod/sbom/arm64.spdx.json --platform linux/arm64 "+image+"@"+p.archDigests["arm64"],
+		fakeTrivy+" image --quiet --format cyclonedx --output builddata/prod/sbom/arm64.cdx.json --platform linux/arm64 "+image+"@"+p.archDigests["arm64"],
+	)
+
+	rec := readRecord(t, envProd)
+	if rec.Tag != p.tag || rec.Digest != p.index || rec.Version != "2.0.69" {
+		t.Errorf("record is for %s@%s (%s), want %s@%s (2.0.69)", rec.Tag, rec.Digest, rec.Version, p.tag, p.index)
+	}
+	for arch, digest := range p.archDigests {
+		if rec.ArchDigests[arch] != digest {
+			t.Errorf("recorded %s digest %s, want %s", arch, rec.ArchDigests[arch], digest)
+		}
+	}
+	if _, ok := p.reg.manifest(p.repo, rec.Signature); !ok || rec.Signature == "" {
+		t.Errorf("recorded signature %q is not in the registry", rec.Signature)
+	}
+	if m, ok := p.reg.manifest(p.repo, sbomTag(p.index)); !ok || digestOf(m.body) != rec.SBOM {
+		t.Errorf("recorded SBOM %q is not the manifest at %s", rec.SBOM, sbomTag(p.index))
+	}
+}
+
+func TestBuildProdFailureStopsTheBuild(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, p *prodBuild)
+		wantErr string
+	}{
+		{"push fails", func(_ *testing.T, p *prodBuild) {
+			p.responses[1] = FakeResponse{Match: "docker buildx build --no-cache", ExitCode: 1}
+		}, "multi-arch push failed: exit status 1"},
+		{"pushed index does not resolve", func(_ *testing.T, p *prodBuild) {
+			p.responses[1].Files = map[string]string{
+				"builddata/prod/buildx-metadata.json": `{"containerimage.digest":"` + testListDigest + `"}`,
+			}
+		}, "pushed image verification failed"},
+		{"signing fails", func(t *testing.T, _ *prodBuild) {
+			t.Setenv("SIGNING_KEY", filepath.Join(t.TempDir(), "missing.key"))
+		}, "image signing failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProdBuild(t)
+			tt.setup(t, p)
+			fake := p.fakeRunner(p.responses)
+			defer useRunner(fake)()
+
+			var err error
+			out := captureStdout(t, func() { err = (Build{}).Prod() })
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Build:Prod error = %v, want %q\n%s", err, tt.wantErr, out)
+			}
+			if fake.Ran(fakeTrivy + " image --quiet --format") {
+				t.Errorf("generated SBOMs after the failure: %v", fake.Calls())
+			}
+			if p.reg.sawRequest("PUT ") || p.reg.sawRequest("POST ") {
+				t.Error("uploaded a signature or SBOM after the failure")
+			}
+			if _, err := os.Stat(buildRecordPath(envProd)); !os.IsNotExist(err) {
+				t.Errorf("wrote %s after the failure", buildRecordPath(envProd))
+			}
+		})
+	}
+}
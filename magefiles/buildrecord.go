@@ -0,0 +1,266 @@
+//go:build mage
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Build environments, each with its own builddata/<env>/builddata.json.
+const (
+	envTest = "test"
+	envProd = "prod"
+)
+
+// BuildRecord is the typed content of builddata/<env>/builddata.json written by
+// Build.Test and Build.Prod. Field names keep the keys earlier records used so
+// existing files remain readable.
+type BuildRecord struct {
+	Env            string            `json:"Env"`
+	BaseImage      string            `json:"BaseImage"`                // upstream repository:tag
+	BaseDigest     string            `json:"BaseDigest"`               // digest the pinned Dockerfile builds from
+	BaseDigests    map[string]string `json:"BaseDigests,omitempty"`    // upstream per-arch digests from the baseline
+	Arch           string            `json:"Arch"`                     // "amd64" or "multi-arch"
+	Version        string            `json:"Version"`                  // Factorio version, "dev" for test builds
+	Tag            string            `json:"Tag"`                      // image tag that was built
+	Digest         string            `json:"Digest"`                   // pushed digest, or local image ID for test builds
+	ArchDigests    map[string]string `json:"ArchDigests,omitempty"`    // per-arch digests of the built image
+	Manifests      []ManifestRecord  `json:"Manifests,omitempty"`      // every child of the pushed index, attestations labelled
+	Signature      string            `json:"Signature,omitempty"`      // digest of the cosign signature manifest
+	SBOM           string            `json:"SBOM,omitempty"`           // digest of the attached SBOM manifest
+	GitCommit      string            `json:"GitCommit,omitempty"`      // HEAD of this repository at build time
+	GitDirty       bool              `json:"GitDirty,omitempty"`       // uncommitted changes were present
+	Dockerfile     string            `json:"Dockerfile,omitempty"`     // Dockerfile path relative to the repo root
+	DockerfileHash string            `json:"DockerfileHash,omitempty"` // sha256 of the Dockerfile contents
+	Scan           *ScanSummary      `json:"Scan,omitempty"`
+	Builder        BuilderInfo       `json:"Builder"`
+	BuiltAt        time.Time         `json:"BuiltAt"`
+}
+
+// ScanSummary records how the vulnerability scan of a build was run and its outcome.
+type ScanSummary struct {
+	Scanner       string         `json:"Scanner"`
+	Image         string         `json:"Image"`
+	ImageID       string         `json:"ImageID,omitempty"` // image ID Trivy reported for Image
+	Policy        string         `json:"Policy,omitempty"`  // vulnerability policy file the scan was evaluated against
+	Severities    string         `json:"Severities"`
+	IgnoreUnfixed bool           `json:"IgnoreUnfixed"`
+	Passed        bool           `json:"Passed"`
+	Counts        map[string]int `json:"Counts,omitempty"`      // findings per severity, when known
+	Blocking      []string       `json:"Blocking,omitempty"`    // IDs that violated the policy
+	Allowlisted   []string       `json:"Allowlisted,omitempty"` // IDs accepted by the allowlist
+	Report        string         `json:"Report,omitempty"`      // raw Trivy JSON report, keyed by ImageID
+}
+
+// BuilderInfo describes the machine and toolchain that produced a build.
+type BuilderInfo struct {
+	Host          string `json:"Host,omitempty"`
+	OS            string `json:"OS"`
+	Arch          string `json:"Arch"`
+	GoVersion     string `json:"GoVersion"`
+	DockerVersion string `json:"DockerVersion,omitempty"`
+	BuildxVersion string `json:"BuildxVersion,omitempty"`
+	BuildxBuilder string `json:"BuildxBuilder,omitempty"`
+	CI            string `json:"CI,omitempty"` // CI run identifier, e.g. GitHub Actions run URL
+}
+
+// buildRecordPath returns the builddata.json path for env.
+func buildRecordPath(env string) string {
+	return filepath.Join(buildDataDir, env, "builddata.json")
+}
+
+// newBuildRecord starts a record for env, filling in everything that is known
+// before the image is built: baseline digests, git state, Dockerfile hash and builder.
+func newBuildRecord(env string, meta *MultiArchMetadata, dockerfile, builder string) *BuildRecord {
+	rec := &BuildRecord{
+		Env:     env,
+		Builder: collectBuilderInfo(builder),
+		BuiltAt: time.Now().UTC(),
+	}
+
+	if meta != nil {
+		rec.BaseImage = fmt.Sprintf("%s:%s", meta.Repository, meta.Tag)
+		rec.BaseDigests = make(map[string]string, len(meta.Digests))
+		for arch, digest := range meta.Digests {
+			rec.BaseDigests[arch] = digest
+		}
+	}
+
+	rec.GitCommit, rec.GitDirty = gitState()
+
+	if dockerfile != "" {
+		rec.Dockerfile = dockerfile
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, dockerfile); err == nil && !strings.HasPrefix(rel, "..") {
+				rec.Dockerfile = rel
+			}
+		}
+		if hash, err := fileSHA256(dockerfile); err == nil {
+			rec.DockerfileHash = hash
+		} else {
+			logWarn("Could not hash Dockerfile %s: %v", dockerfile, err)
+		}
+	}
+	return rec
+}
+
+// writeBuildRecord writes rec to builddata/<env>/builddata.json, replacing any previous record.
+func writeBuildRecord(rec *BuildRecord) error {
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %v", err)
+	}
+	if err := writeFile(buildRecordPath(rec.Env), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s builddata: %v", rec.Env, err)
+	}
+	return nil
+}
+
+// readBuildRecord loads the most recent build record for env.
+func readBuildRecord(env string) (*BuildRecord, error) {
+	path := buildRecordPath(env)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read build record: %w", err)
+	}
+
+	var rec BuildRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if rec.Env == "" {
+		rec.Env = env
+	}
+	return &rec, nil
+}
+
+// gitState returns the current commit of the repository and whether the worktree is dirty.
+func gitState() (string, bool) {
+	out, err := cmdOutput("git", "rev-parse", "HEAD")
+	if err != nil {
+		return "", false
+	}
+	commit := strings.TrimSpace(string(out))
+
+	status, err := cmdOutput("git", "status", "--porcelain")
+	if err != nil {
+		return commit, false
+	}
+	return commit, strings.TrimSpace(string(status)) != ""
+}
+
+// fileSHA256 returns the "sha256:<hex>" digest of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// collectBuilderInfo gathers host and toolchain versions. Missing tools are left blank.
+func collectBuilderInfo(builder string) BuilderInfo {
+	info := BuilderInfo{
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		GoVersion:     runtime.Version(),
+		BuildxBuilder: builder,
+	}
+	if host, err := os.Hostname(); err == nil {
+		info.Host = host
+	}
+	if out, err := cmdOutput("docker", "version", "--format", "{{.Server.Version}}"); err == nil {
+		info.DockerVersion = strings.TrimSpace(string(out))
+	}
+	if out, err := cmdOutput("docker", "buildx", "version"); err == nil {
+		info.BuildxVersion = strings.TrimSpace(string(out))
+	}
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		info.CI = fmt.Sprintf("%s/%s/actions/runs/%s",
+			os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"))
+	}
+	return info
+}
+
+// Record prints the build record for an environment ("test" or "prod").
+func (Build) Record(env string) (err error) {
+	run := startTarget("build:record")
+	defer func() { run.finish(err) }()
+
+	if env != envTest && env != envProd {
+		return fmt.Errorf("unknown build environment %q (expected %s or %s)", env, envTest, envProd)
+	}
+
+	rec, err := readBuildRecord(env)
+	if err != nil {
+		return err
+	}
+
+	logInfo("Build record (%s):", buildRecordPath(env))
+	fmt.Printf("  Tag:         %s\n", rec.Tag)
+	fmt.Printf("  Version:     %s\n", rec.Version)
+	fmt.Printf("  Digest:      %s\n", rec.Digest)
+	printDigestMap("  Arch digest", rec.ArchDigests)
+	for _, m := range rec.Manifests {
+		if m.Kind == manifestKindAttestation {
+			fmt.Printf("  Attestation: %s (for %s)\n", m.Digest, m.Subject)
+		}
+	}
+	if rec.Signature != "" {
+		fmt.Printf("  Signature:   %s\n", rec.Signature)
+	}
+	if rec.SBOM != "" {
+		fmt.Printf("  SBOM:        %s\n", rec.SBOM)
+	}
+	fmt.Printf("  Base image:  %s\n", rec.BaseImage)
+	fmt.Printf("  Base digest: %s\n", rec.BaseDigest)
+	if rec.GitCommit != "" {
+		dirty := ""
+		if rec.GitDirty {
+			dirty = " (dirty)"
+		}
+		fmt.Printf("  Git commit:  %s%s\n", rec.GitCommit, dirty)
+	}
+	if rec.Dockerfile != "" {
+		fmt.Printf("  Dockerfile:  %s (%s)\n", rec.Dockerfile, rec.DockerfileHash)
+	}
+	if rec.Scan != nil {
+		status := "failed"
+		if rec.Scan.Passed {
+			status = "passed"
+		}
+		fmt.Printf("  Scan:        %s %s [%s]\n", rec.Scan.Scanner, status, rec.Scan.Severities)
+		if len(rec.Scan.Allowlisted) > 0 {
+			fmt.Printf("  Allowlisted: %s\n", strings.Join(rec.Scan.Allowlisted, ", "))
+		}
+	}
+	fmt.Printf("  Built at:    %s on %s (%s/%s, %s)\n",
+		rec.BuiltAt.Format(time.RFC3339), rec.Builder.Host, rec.Builder.OS, rec.Builder.Arch, rec.Builder.GoVersion)
+	return nil
+}
+
+// printDigestMap prints arch → digest pairs in a stable order.
+func printDigestMap(label string, digests map[string]string) {
+	for _, k := range sortedKeys(digests) {
+		fmt.Printf("%s (%s): %s\n", label, k, digests[k])
+	}
+}
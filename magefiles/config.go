@@ -0,0 +1,255 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magefile/mage/mg"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile holds the project settings; FACTORIO_HARDENED_CONFIG overrides its path.
+const defaultConfigFile = "factorio-hardened.yaml"
+
+// Config namespace inspects the project configuration shared by all targets.
+type Config mg.Namespace
+
+// ProjectConfig is the effective project configuration: built-in defaults,
+// overridden by factorio-hardened.yaml, overridden by environment variables.
+type ProjectConfig struct {
+	ImageRepo       string `yaml:"imageRepo"`       // where Build:Prod pushes, e.g. ghcr.io/owner/factorio-hardened
+	GithubRepo      string `yaml:"githubRepo"`      // owner/name checked by Github:VerifyRepoAccess
+	GithubUser      string `yaml:"githubUser"`      // registry login written by Docker:Deps
+	UpstreamImage   string `yaml:"upstreamImage"`   // image the hardened build derives from
+	FactorioChannel string `yaml:"factorioChannel"` // "stable" or "experimental"
+	FactorioTag     string `yaml:"factorioTag"`     // upstream tag to pin; empty follows the baseline or channel
+	Builder         string `yaml:"builder"`         // buildx builder used for release builds
+
+	// Timeouts bounds each kind of external command or HTTP call, keyed by
+	// the names in timeoutSettings; values are Go durations such as "45m".
+	Timeouts map[string]string `yaml:"timeouts"`
+
+	// Path is the config file read, empty when none exists.
+	Path string `yaml:"-"`
+	// Sources records where each key's value came from.
+	Sources map[string]string `yaml:"-"`
+}
+
+// configSetting describes one configuration key.
+type configSetting struct {
+	Key      string
+	Env      string
+	Default  string
+	Field    func(*ProjectConfig) *string
+	Validate func(string) error
+}
+
+var (
+	imageRepoPattern  = regexp.MustCompile(`^[a-z0-9.-]+(:[0-9]+)?(/[a-z0-9._-]+)+$`)
+	githubRepoPattern = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+	githubUserPattern = regexp.MustCompile(`^[A-Za-z0-9](-?[A-Za-z0-9])*$`)
+	upstreamPattern   = regexp.MustCompile(`^([a-z0-9.-]+:[0-9]+/)?[a-z0-9._/-]+$`) // a mirror may name its registry port
+	builderPattern    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// configSettings lists every key in display order.
+var configSettings = []configSetting{
+	{"imageRepo", "IMAGE_REPO", "ghcr.io/henryhall897/factorio-hardened",
+		func(c *ProjectConfig) *string { return &c.ImageRepo },
+		matchPattern(imageRepoPattern, "registry/owner/name in lower case, without tag or digest")},
+	{"githubRepo", "GITHUB_REPO", "henryhall897/factorio-hardened",
+		func(c *ProjectConfig) *string { return &c.GithubRepo },
+		matchPattern(githubRepoPattern, "owner/name")},
+	{"githubUser", "GHCR_USER", "henryhall897",
+		func(c *ProjectConfig) *string { return &c.GithubUser },
+		matchPattern(githubUserPattern, "a GitHub user name")},
+	{"upstreamImage", "UPSTREAM_IMAGE", "factoriotools/factorio",
+		func(c *ProjectConfig) *string { return &c.UpstreamImage },
+		matchPattern(upstreamPattern, "a repository without tag or digest")},
+	{"factorioChannel", "FACTORIO_CHANNEL", "stable",
+		func(c *ProjectConfig) *string { return &c.FactorioChannel },
+		func(v string) error {
+			if v != "stable" && v != "experimental" {
+				return fmt.Errorf("expected stable or experimental")
+			}
+			return nil
+		}},
+	{"factorioTag", "FACTORIO_TAG", "",
+		func(c *ProjectConfig) *string { return &c.FactorioTag },
+		nil},
+	{"builder", "BUILDX_BUILDER", "hardened-builder",
+		func(c *ProjectConfig) *string { return &c.Builder },
+		matchPattern(builderPattern, "letters, digits, '-' or '_'")},
+}
+
+// timeoutSetting describes one key of the timeouts section. Each can be
+// overridden with TIMEOUT_<KEY>, e.g. TIMEOUT_BUILD=90m.
+type timeoutSetting struct {
+	Key     string
+	Default time.Duration
+	Covers  string
+}
+
+// timeoutSettings lists every timeout key in display order.
+var timeoutSettings = []timeoutSetting{
+	{"build", 60 * time.Minute, "docker build and docker buildx build"},
+	{"transfer", 30 * time.Minute, "docker pull/push/save, curl downloads, tool and layer downloads"},
+	{"scan", 30 * time.Minute, "trivy scans and vulnerability database downloads"},
+	{"install", 30 * time.Minute, "apt-get and installers run through sudo or bash"},
+	{"api", 30 * time.Second, "GitHub and registry API calls, curl queries"},
+	{"command", 10 * time.Minute, "any other external command"},
+}
+
+// matchPattern returns a validator requiring values to match re.
+func matchPattern(re *regexp.Regexp, want string) func(string) error {
+	return func(v string) error {
+		if !re.MatchString(v) {
+			return fmt.Errorf("expected %s", want)
+		}
+		return nil
+	}
+}
+
+// loadProjectConfigOnce caches the configuration for the lifetime of the mage run.
+var loadProjectConfigOnce = sync.OnceValues(func() (*ProjectConfig, error) {
+	return loadProjectConfig(configPath())
+})
+
+// projectConfig returns the effective configuration, loading it on first use.
+func projectConfig() (*ProjectConfig, error) {
+	return loadProjectConfigOnce()
+}
+
+// configPath returns the config file in use.
+func configPath() string {
+	if p := os.Getenv("FACTORIO_HARDENED_CONFIG"); p != "" {
+		return p
+	}
+	return defaultConfigFile
+}
+
+// loadProjectConfig resolves every setting from defaults, the file at path
+// (if it exists) and the environment, then validates the result.
+func loadProjectConfig(path string) (*ProjectConfig, error) {
+	var file ProjectConfig
+	cfg := &ProjectConfig{Sources: make(map[string]string)}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		cfg.Path = path
+	case errors.Is(err, os.ErrNotExist) && os.Getenv("FACTORIO_HARDENED_CONFIG") == "":
+		// No project file: defaults and environment only.
+	default:
+		return nil, fmt.Errorf("cannot read project config: %v", err)
+	}
+
+	var problems []string
+	cfg.Timeouts = make(map[string]string, len(timeoutSettings))
+	for _, t := range timeoutSettings {
+		v, src := t.Default.String(), "default"
+		if fv, ok := file.Timeouts[t.Key]; ok && strings.TrimSpace(fv) != "" {
+			v, src = strings.TrimSpace(fv), path
+		}
+		env := "TIMEOUT_" + strings.ToUpper(t.Key)
+		if ev, ok := os.LookupEnv(env); ok && strings.TrimSpace(ev) != "" {
+			v, src = strings.TrimSpace(ev), "env "+env
+		}
+		cfg.Timeouts[t.Key] = v
+		cfg.Sources["timeouts."+t.Key] = src
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			problems = append(problems, fmt.Sprintf("timeouts.%s %q (from %s): expected a positive duration such as 45m", t.Key, v, src))
+		}
+	}
+	for key := range file.Timeouts {
+		if cfg.Sources["timeouts."+key] == "" {
+			problems = append(problems, fmt.Sprintf("timeouts.%s (from %s): unknown key", key, path))
+		}
+	}
+	for _, s := range configSettings {
+		v, src := s.Default, "default"
+		if fv := strings.TrimSpace(*s.Field(&file)); fv != "" {
+			v, src = fv, path
+		}
+		if ev, ok := os.LookupEnv(s.Env); ok && strings.TrimSpace(ev) != "" {
+			v, src = strings.TrimSpace(ev), "env "+s.Env
+		}
+		*s.Field(cfg) = v
+		cfg.Sources[s.Key] = src
+		if s.Validate != nil && v != "" {
+			if err := s.Validate(v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %q (from %s): %v", s.Key, v, src, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid project config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return cfg, nil
+}
+
+// Registry returns the registry host of ImageRepo, e.g. "ghcr.io".
+func (c *ProjectConfig) Registry() string {
+	return strings.SplitN(c.ImageRepo, "/", 2)[0]
+}
+
+// Timeout returns the configured timeout for key, or the built-in default
+// when key is unknown.
+func (c *ProjectConfig) Timeout(key string) time.Duration {
+	if d, err := time.ParseDuration(c.Timeouts[key]); err == nil && d > 0 {
+		return d
+	}
+	return defaultTimeout(key)
+}
+
+// defaultTimeout returns the built-in timeout for key, falling back to "command".
+func defaultTimeout(key string) time.Duration {
+	for _, t := range timeoutSettings {
+		if t.Key == key {
+			return t.Default
+		}
+	}
+	return defaultTimeout("command")
+}
+
+// Show prints the effective configuration and where each value came from.
+func (Config) Show() (err error) {
+	run := startTarget("config:show")
+	defer func() { run.finish(err) }()
+
+	cfg, err := projectConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Path != "" {
+		logInfo("⚙️  Project config: %s", cfg.Path)
+	} else {
+		logInfo("⚙️  Project config: %s not found; using defaults", configPath())
+	}
+	for _, s := range configSettings {
+		v := *s.Field(cfg)
+		if v == "" {
+			v = "(unset)"
+		}
+		fmt.Printf("  %-16s %-45s %s\n", s.Key, v, cfg.Sources[s.Key])
+	}
+	fmt.Println("  timeouts:")
+	for _, t := range timeoutSettings {
+		fmt.Printf("    %-10s %-8s %-24s %s\n", t.Key, cfg.Timeouts[t.Key], cfg.Sources["timeouts."+t.Key], t.Covers)
+	}
+	return nil
+}
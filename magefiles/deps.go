@@ -10,14 +10,16 @@ import (
 
 // Deps namespace coordinates installation and configuration of all dependencies
 // required for building, testing, and publishing the Factorio-Hardened project.
-// It delegates to subsystem namespaces (System, Go, Docker, Github, Lint, Trivy)
+// It delegates to subsystem namespaces (System, Go, Docker, Github, Lint, Tools)
 // and ensures the environment is self-healing and reproducible.
 type Deps mg.Namespace
 
 // All runs all dependency checks and installation routines in sequence.
 // It ensures the host environment is fully configured before builds or CI runs.
-func (Deps) All() error {
-	fmt.Println("Ensuring all dependencies for Factorio-Hardened are installed and verified...")
+func (Deps) All() (err error) {
+	run := startTarget("deps:all")
+	defer func() { run.finish(err) }()
+	logInfo("Ensuring all dependencies for Factorio-Hardened are installed and verified...")
 
 	steps := []struct {
 		name string
@@ -27,18 +29,22 @@ func (Deps) All() error {
 		{"Go toolchain", func() error { return (Go{}).Deps() }},
 		{"Docker engine and GHCR authentication", func() error { return (Docker{}).Deps() }},
 		{"GolangCI-Lint installation", func() error { return (Lint{}).Deps() }},
-		{"Trivy vulnerability scanner", func() error { return (Trivy{}).Deps() }},
+		{"Pinned tools (tools.lock.yaml)", func() error { return (Tools{}).Install() }},
 		{"GitHub authentication and token scopes", func() error { return (Github{}).Deps() }},
 	}
 
 	for _, step := range steps {
-		fmt.Printf("Starting: %s...\n", step.name)
+		run.step(step.name)
+		logInfo("Starting: %s...", step.name)
 		if err := step.fn(); err != nil {
 			return fmt.Errorf("%s failed: %w", step.name, err)
 		}
-		fmt.Printf("Completed: %s verified successfully.\n\n", step.name)
+		logInfo("Completed: %s verified successfully.", step.name)
 	}
 
-	fmt.Println("All dependencies are installed, configured, and verified successfully.")
+	if dryRun() {
+		return nil
+	}
+	logInfo("All dependencies are installed, configured, and verified successfully.")
 	return nil
 }
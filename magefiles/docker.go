@@ -3,11 +3,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -18,68 +18,73 @@ import (
 type Docker mg.Namespace
 
 // Verify checks that Docker is installed and the daemon is reachable.
-func (Docker) Verify() error {
-	fmt.Println("Verifying Docker installation...")
+func (Docker) Verify() (err error) {
+	run := startTarget("docker:verify")
+	defer func() { run.finish(err) }()
+
+	logInfo("Verifying Docker installation...")
 	if err := verifyDockerInstallation(); err != nil {
 		return err
 	}
-	fmt.Println("Docker installation verified successfully.")
+	logInfo("Docker installation verified successfully.")
 	return nil
 }
 
 // Deps ensures Docker, Buildx, and GHCR authentication are configured and functional.
-func (Docker) Deps() error {
-	fmt.Println("Ensuring Docker dependencies...")
+func (Docker) Deps() (err error) {
+	run := startTarget("docker:deps")
+	defer func() { run.finish(err) }()
+
+	logInfo("Ensuring Docker dependencies...")
 
 	// 1. Ensure Docker itself is installed
 	if err := (Docker{}).Verify(); err != nil {
-		fmt.Println("Docker not detected or unhealthy. Installing official Docker Engine...")
+		logWarn("Docker not detected or unhealthy. Installing official Docker Engine...")
 		if err := installDocker(); err != nil {
 			return fmt.Errorf("failed to install Docker: %w", err)
 		}
 
-		fmt.Println("Re-verifying Docker installation...")
-		if err := (Docker{}).Verify(); err != nil {
+		logInfo("Re-verifying Docker installation...")
+		if err := (Docker{}).Verify(); err != nil && !dryRun() {
 			return fmt.Errorf("Docker installation did not verify successfully: %w", err)
 		}
 	}
 
 	// 2. Ensure Buildx is available and configured
-	fmt.Println("Verifying Docker Buildx availability...")
+	logInfo("Verifying Docker Buildx availability...")
 	if err := verifyBuildx(); err != nil {
-		fmt.Println("Docker Buildx not detected or misconfigured. Attempting to set up...")
+		logWarn("Docker Buildx not detected or misconfigured. Attempting to set up...")
 		if err := ensureBuildx(); err != nil {
 			return fmt.Errorf("failed to configure Docker Buildx: %w", err)
 		}
 	}
 
 	// 3. Verify Docker GHCR authentication
-	fmt.Println("Verifying Docker authentication for GHCR...")
+	logInfo("Verifying Docker authentication for GHCR...")
 	if err := (Docker{}).VerifyAuth(); err != nil {
-		fmt.Println("Docker GHCR authentication is missing or invalid. Configuring credentials...")
+		logWarn("Docker GHCR authentication is missing or invalid. Configuring credentials...")
 		if err := ensureDockerAuth(); err != nil {
 			return fmt.Errorf("failed to configure Docker authentication: %w", err)
 		}
 	}
 
-	fmt.Println("Docker successfully installed, Buildx configured, and GHCR authentication verified.")
+	logInfo("Docker successfully installed, Buildx configured, and GHCR authentication verified.")
 	return nil
 }
 
 // verifyDockerInstallation checks that the Docker CLI and daemon are functional.
 func verifyDockerInstallation() error {
-	if _, err := exec.LookPath("docker"); err != nil {
+	if _, err := runner.LookPath("docker"); err != nil {
 		return fmt.Errorf("docker binary not found in PATH")
 	}
 
-	cmd := exec.Command("docker", "version", "--format", "{{.Server.Version}}")
-	out, err := cmd.CombinedOutput()
+	out, err := cmdCombinedOutput("docker", "version", "--format", "{{.Server.Version}}")
 	if err != nil {
 		return fmt.Errorf("docker daemon unreachable or not running: %w", err)
 	}
 
 	version := strings.TrimSpace(string(out))
-	fmt.Printf("Docker is installed and running (version: %s)\n", version)
+	logInfo("Docker is installed and running (version: %s)", version)
 	return nil
 }
 
@@ -87,13 +92,12 @@ func verifyDockerInstallation() error {
 // with a containerized builder (driver = docker-container).
 func verifyBuildx() error {
 	// Check plugin availability
-	if err := exec.Command("docker", "buildx", "version").Run(); err != nil {
+	if err := runQuiet("docker", "buildx", "version"); err != nil {
 		return fmt.Errorf("docker buildx not installed: %w", err)
 	}
 
 	// Inspect active builder
-	cmd := exec.Command("docker", "buildx", "inspect")
-	out, err := cmd.CombinedOutput()
+	out, err := cmdCombinedOutput("docker", "buildx", "inspect")
 	if err != nil {
 		return fmt.Errorf("failed to inspect buildx builder: %w", err)
 	}
@@ -106,20 +110,20 @@ func verifyBuildx() error {
 	lines := strings.Split(output, "\n")
 	for _, line := range lines {
 		if strings.Contains(line, "Driver:") && strings.Contains(line, "docker-container") {
-			fmt.Println("Docker Buildx is available and correctly configured.")
+			logInfo("Docker Buildx is available and correctly configured.")
 			return nil
 		}
 	}
 
 	// If we got here, it's installed but wrong driver
-	fmt.Println("Docker Buildx found, but using docker driver instead of docker-container.")
+	logWarn("Docker Buildx found, but using docker driver instead of docker-container.")
 	return fmt.Errorf("buildx not using docker-container driver (reconfiguration required)")
 }
 
 // ensureBuildx ensures that Docker Buildx is installed and configured.
 // Delegates actual setup to scripts/buildx.sh for reproducible host-level behavior.
 func ensureBuildx() error {
-	fmt.Println("Configuring Docker Buildx...")
+	logInfo("Configuring Docker Buildx...")
 
 	// Determine script path relative to project root
 	scriptPath := filepath.Join("scripts", "buildx.sh")
@@ -127,31 +131,44 @@ func ensureBuildx() error {
 		return fmt.Errorf("missing helper script: %s", scriptPath)
 	}
 
-	// Run the setup script
-	cmd := exec.Command("bash", scriptPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = append(os.Environ(), "HOME="+os.Getenv("HOME"))
+	project, err := projectConfig()
+	if err != nil {
+		return err
+	}
 
-	if err := cmd.Run(); err != nil {
+	// Run the setup script
+	if dryRun() {
+		plan(planRun, "bash %s (install Buildx, QEMU emulation and builder %s)", scriptPath, project.Builder)
+		return nil
+	}
+	env := []string{"HOME=" + os.Getenv("HOME"), "BUILDX_BUILDER_NAME=" + project.Builder}
+	if err := runCommand(Cmd{Name: "bash", Args: []string{scriptPath}, Env: env, Stdout: os.Stdout, Stderr: os.Stderr}); err != nil {
 		return fmt.Errorf("failed to configure Buildx via %s: %w", scriptPath, err)
 	}
 
 	// Verify final Buildx status
-	verify := exec.Command("docker", "buildx", "inspect")
-	verify.Env = append(os.Environ(), "HOME="+os.Getenv("HOME"))
-	out, err := verify.CombinedOutput()
-	if err != nil {
+	var out bytes.Buffer
+	if err := runCommand(Cmd{Name: "docker", Args: []string{"buildx", "inspect"}, Env: env, Stdout: &out, Stderr: &out}); err != nil {
 		return fmt.Errorf("failed to verify Buildx configuration: %w", err)
 	}
 
-	fmt.Println(string(out))
-	fmt.Println("Docker Buildx successfully configured for multi-platform builds.")
+	logDebug("%s", strings.TrimSpace(out.String()))
+	logInfo("Docker Buildx successfully configured for multi-platform builds.")
 	return nil
 }
 
-// VerifyAuth validates Docker authentication for GHCR (GitHub Container Registry).
-func (Docker) VerifyAuth() error {
+// VerifyAuth validates Docker authentication for the registry of the configured
+// imageRepo (GHCR by default).
+func (Docker) VerifyAuth() (err error) {
+	run := startTarget("docker:verifyAuth")
+	defer func() { run.finish(err) }()
+
+	project, err := projectConfig()
+	if err != nil {
+		return err
+	}
+	registry := project.Registry()
+
 	const configPath = ".docker/config.json"
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -175,8 +192,8 @@ func (Docker) VerifyAuth() error {
 
 	if ch, ok := cfg["credHelpers"]; ok {
 		if helpers, ok := ch.(map[string]interface{}); ok {
-			if gh, ok := helpers["ghcr.io"]; ok && gh != "" {
-				return fmt.Errorf("per-registry helper for ghcr.io (%v) is active — should be an empty string", gh)
+			if gh, ok := helpers[registry]; ok && gh != "" {
+				return fmt.Errorf("per-registry helper for %s (%v) is active — should be an empty string", registry, gh)
 			}
 		}
 	}
@@ -186,9 +203,10 @@ func (Docker) VerifyAuth() error {
 		return fmt.Errorf("no 'auths' section found in Docker configuration: %s", path)
 	}
 
-	ghcrEntry, ok := auths["ghcr.io"].(map[string]interface{})
+	ghcrEntry, ok := auths[registry].(map[string]interface{})
 	if !ok || ghcrEntry["auth"] == nil {
-		return fmt.Errorf("missing authentication for ghcr.io — run: echo $GHCR_TOKEN | docker login ghcr.io -u henryhall897 --password-stdin")
+		return fmt.Errorf("missing authentication for %s — run: echo $GHCR_TOKEN | docker login %s -u %s --password-stdin",
+			registry, registry, project.GithubUser)
 	}
 
 	authB64, _ := ghcrEntry["auth"].(string)
@@ -199,24 +217,35 @@ func (Docker) VerifyAuth() error {
 
 	parts := strings.SplitN(string(decoded), ":", 2)
 	if len(parts) != 2 || parts[0] == "" || len(parts[1]) < 10 {
-		return fmt.Errorf("ghcr.io credentials appear invalid or incomplete; please re-authenticate")
+		return fmt.Errorf("%s credentials appear invalid or incomplete; please re-authenticate", registry)
 	}
 
-	fmt.Println("Docker GHCR authentication verification complete.")
-	fmt.Printf("  User: %s\n", parts[0])
-	fmt.Println("  Credential helper: disabled (expected configuration)")
-	fmt.Println("  Note: GitHub PATs for GHCR typically expire every 90 days. Renew before expiration to avoid disruptions.")
+	logInfo("Docker %s authentication verification complete.", registry)
+	logInfo("  User: %s", parts[0])
+	logInfo("  Credential helper: disabled (expected configuration)")
+	logInfo("  Note: GitHub PATs for GHCR typically expire every 90 days. Renew before expiration to avoid disruptions.")
 
 	return nil
 }
 
-// ensureDockerAuth ensures that GHCR authentication exists in the Docker configuration file.
+// ensureDockerAuth ensures that authentication for the configured registry
+// exists in the Docker configuration file, logging in as githubUser.
 func ensureDockerAuth() error {
+	project, err := projectConfig()
+	if err != nil {
+		return err
+	}
+	registry := project.Registry()
+
 	configPath := fmt.Sprintf("%s/.docker/config.json", os.Getenv("HOME"))
+	if dryRun() {
+		plan(planWrite, "%s (prompt for a token and add %s credentials for %s)", configPath, registry, project.GithubUser)
+		return nil
+	}
 
 	data, err := os.ReadFile(configPath)
 	if os.IsNotExist(err) {
-		fmt.Println("Docker configuration not found. Creating ~/.docker/config.json ...")
+		logInfo("Docker configuration not found. Creating ~/.docker/config.json ...")
 		if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
 			return fmt.Errorf("failed to create Docker config directory: %w", err)
 		}
@@ -230,8 +259,8 @@ func ensureDockerAuth() error {
 	_ = json.Unmarshal(data, &cfg)
 
 	auths, _ := cfg["auths"].(map[string]interface{})
-	if auths == nil || auths["ghcr.io"] == nil {
-		fmt.Println("\nGHCR credentials not found in Docker config.")
+	if auths == nil || auths[registry] == nil {
+		logWarn("%s credentials not found in Docker config.", registry)
 		fmt.Println("To push or pull images, you need a GitHub Personal Access Token (classic) with `read:packages` and `write:packages` scopes.")
 		fmt.Println("1. Visit: https://github.com/settings/tokens")
 		fmt.Println("2. Generate a new token with those scopes.")
@@ -244,21 +273,21 @@ func ensureDockerAuth() error {
 			return fmt.Errorf("no token provided; cannot configure GHCR access")
 		}
 
-		auth := base64.StdEncoding.EncodeToString([]byte("henryhall897:" + token))
+		auth := base64.StdEncoding.EncodeToString([]byte(project.GithubUser + ":" + token))
 		if auths == nil {
 			auths = make(map[string]interface{})
 			cfg["auths"] = auths
 		}
-		auths["ghcr.io"] = map[string]interface{}{"auth": auth}
+		auths[registry] = map[string]interface{}{"auth": auth}
 
 		updated, _ := json.MarshalIndent(cfg, "", "  ")
 		if err := os.WriteFile(configPath, updated, 0600); err != nil {
 			return fmt.Errorf("failed to write Docker config: %w", err)
 		}
 
-		fmt.Println("\nDocker GHCR authentication configured successfully.")
+		logInfo("Docker %s authentication configured successfully.", registry)
 	} else {
-		fmt.Println("Docker GHCR credentials already exist.")
+		logInfo("Docker %s credentials already exist.", registry)
 	}
 
 	return nil
@@ -267,16 +296,13 @@ func ensureDockerAuth() error {
 // installDocker ensures the official Docker Engine (with Buildx and Compose) is installed.
 // If the system is using Ubuntu's legacy `docker.io` package, it will be replaced.
 func installDocker() error {
-	fmt.Println("Installing official Docker Engine and Buildx plugin...")
+	logInfo("Installing official Docker Engine and Buildx plugin...")
 
 	// Detect whether the current docker binary is the Ubuntu version
-	cmd := exec.Command("docker", "--version")
-	out, err := cmd.CombinedOutput()
+	out, err := cmdCombinedOutput("docker", "--version")
 	if err == nil && strings.Contains(string(out), "Ubuntu") {
-		fmt.Println("Detected Ubuntu-provided Docker package (docker.io). Removing it before installing official Docker...")
-		remove := exec.Command("sudo", "apt-get", "remove", "-y", "docker.io", "docker-doc", "podman-docker", "containerd", "runc")
-		remove.Stdout, remove.Stderr = os.Stdout, os.Stderr
-		if err := remove.Run(); err != nil {
+		logInfo("Detected Ubuntu-provided Docker package (docker.io). Removing it before installing official Docker...")
+		if err := runMutating(planRemove, "sudo", "apt-get", "remove", "-y", "docker.io", "docker-doc", "podman-docker", "containerd", "runc"); err != nil {
 			return fmt.Errorf("failed to remove legacy Docker packages: %w", err)
 		}
 	}
@@ -292,18 +318,18 @@ func installDocker() error {
 	}
 
 	for _, args := range cmds {
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-		if err := cmd.Run(); err != nil {
+		if err := runMutating(planRun, args[0], args[1:]...); err != nil {
 			return fmt.Errorf("failed running %v: %w", args, err)
 		}
 	}
 
-	cmd = exec.Command("docker", "version", "--format", "{{.Server.Version}}")
-	if err := cmd.Run(); err != nil {
+	if dryRun() {
+		return nil
+	}
+	if err := runQuiet("docker", "version", "--format", "{{.Server.Version}}"); err != nil {
 		return fmt.Errorf("docker installation failed or daemon not reachable: %w", err)
 	}
 
-	fmt.Println("Official Docker Engine and Buildx successfully installed.")
+	logInfo("Official Docker Engine and Buildx successfully installed.")
 	return nil
 }
@@ -0,0 +1,59 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDockerVerifyAuth(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("henryhall897:ghp_0123456789abcdef"))
+	tests := []struct {
+		name    string
+		config  string // "" leaves ~/.docker/config.json missing
+		wantErr string
+	}{
+		{"logged in", `{"auths":{"ghcr.io":{"auth":"` + auth + `"}}}`, ""},
+		{"no config", "", "docker config not found"},
+		{"creds store", `{"credsStore":"desktop","auths":{"ghcr.io":{"auth":"` + auth + `"}}}`, "global credsStore (desktop) is active"},
+		{"cred helper", `{"credHelpers":{"ghcr.io":"gh"},"auths":{"ghcr.io":{"auth":"` + auth + `"}}}`, "per-registry helper for ghcr.io"},
+		{"other registry", `{"auths":{"docker.io":{"auth":"` + auth + `"}}}`, "missing authentication for ghcr.io"},
+		{"short token", `{"auths":{"ghcr.io":{"auth":"` + base64.StdEncoding.EncodeToString([]byte("henryhall897:short")) + `"}}}`, "appear invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			useProjectConfig(t)
+			if tt.config != "" {
+				path := filepath.Join(home, ".docker", "config.json")
+				if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fake := &FakeRunner{}
+			defer useRunner(fake)()
+
+			var err error
+			captureStdout(t, func() { err = (Docker{}).VerifyAuth() })
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Docker:VerifyAuth: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Docker:VerifyAuth error = %v, want %q", err, tt.wantErr)
+			}
+			// The check reads the config file only; it never runs docker login.
+			if calls := fake.Calls(); len(calls) != 0 {
+				t.Errorf("ran commands: %v", calls)
+			}
+		})
+	}
+}
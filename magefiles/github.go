@@ -14,18 +14,16 @@ import (
 	"github.com/magefile/mage/mg"
 )
 
-const (
-	GithubRepo        = "henryhall897/factorio-hardened"
-	GithubHTTPTimeout = 10 * time.Second
-)
-
 // Github namespace handles GitHub-related tasks such as GHCR token validation and API access checks.
 type Github mg.Namespace
 
 // Verify checks that a valid GitHub Personal Access Token (PAT) is available
 // and that it has not expired.
-func (Github) Verify() error {
-	fmt.Println("Verifying GitHub authentication token...")
+func (Github) Verify() (err error) {
+	run := startTarget("github:verify")
+	defer func() { run.finish(err) }()
+
+	logInfo("Verifying GitHub authentication token...")
 
 	token, err := loadGhcrToken()
 	if err != nil {
@@ -36,13 +34,16 @@ func (Github) Verify() error {
 		return err
 	}
 
-	fmt.Println("GitHub token verification completed successfully.")
+	logInfo("GitHub token verification completed successfully.")
 	return nil
 }
 
 // Deps ensures that a valid GitHub PAT exists and is usable for GHCR operations.
-func (Github) Deps() error {
-	fmt.Println("Ensuring GitHub authentication dependencies...")
+func (Github) Deps() (err error) {
+	run := startTarget("github:deps")
+	defer func() { run.finish(err) }()
+
+	logInfo("Ensuring GitHub authentication dependencies...")
 
 	// Ensure Docker credentials exist before checking GitHub.
 	if err := (Docker{}).Deps(); err != nil {
@@ -51,12 +52,16 @@ func (Github) Deps() error {
 
 	// Verify token validity.
 	if err := (Github{}).Verify(); err != nil {
-		fmt.Println("GitHub token invalid or missing. Attempting reconfiguration...")
+		logWarn("GitHub token invalid or missing. Attempting reconfiguration...")
 		if err := (Docker{}).VerifyAuth(); err != nil {
 			if err := ensureDockerAuth(); err != nil {
 				return fmt.Errorf("failed to reconfigure GHCR credentials: %w", err)
 			}
 		}
+		if dryRun() {
+			// The remaining checks need the credentials that were only planned.
+			return nil
+		}
 		if err := (Github{}).Verify(); err != nil {
 			return fmt.Errorf("GitHub token verification failed after reconfiguration: %w", err)
 		}
@@ -72,7 +77,7 @@ func (Github) Deps() error {
 	}
 
 	if err := (Github{}).Whoami(); err == nil {
-		fmt.Println("GitHub authentication context verified successfully.")
+		logInfo("GitHub authentication context verified successfully.")
 	}
 
 	return nil
@@ -80,8 +85,11 @@ func (Github) Deps() error {
 
 // ValidateAll runs all GitHub checks (Verify, PAT scopes, Repo access, Whoami)
 // without reconfiguration or mutation.
-func (Github) ValidateAll() error {
-	fmt.Println("Running full GitHub validation suite...")
+func (Github) ValidateAll() (err error) {
+	run := startTarget("github:validateAll")
+	defer func() { run.finish(err) }()
+
+	logInfo("Running full GitHub validation suite...")
 
 	if err := (Github{}).Verify(); err != nil {
 		return err
@@ -96,23 +104,29 @@ func (Github) ValidateAll() error {
 		return err
 	}
 
-	fmt.Println("All GitHub checks completed successfully.")
+	logInfo("All GitHub checks completed successfully.")
 	return nil
 }
 
 // VerifyRepoAccess checks that the configured GitHub token can access the expected repository.
-func (Github) VerifyRepoAccess() error {
+func (Github) VerifyRepoAccess() (err error) {
+	run := startTarget("github:verifyRepoAccess")
+	defer func() { run.finish(err) }()
+
 	token, err := loadGhcrToken()
 	if err != nil {
 		return fmt.Errorf("failed to load GHCR token: %w", err)
 	}
 
-	req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/repos/%s", GithubRepo), nil)
+	cfg, err := projectConfig()
+	if err != nil {
+		return err
+	}
+	req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/repos/%s", cfg.GithubRepo), nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Accept", "application/vnd.github+json")
 
-	client := &http.Client{Timeout: GithubHTTPTimeout}
-	resp, err := client.Do(req)
+	resp, err := httpDo(httpClient("api"), req)
 	if err != nil {
 		if strings.Contains(err.Error(), "no such host") {
 			return fmt.Errorf("GitHub API unreachable — are you offline?")
@@ -122,7 +136,7 @@ func (Github) VerifyRepoAccess() error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusOK {
-		fmt.Println("GitHub repository access verified.")
+		logInfo("GitHub repository access verified.")
 		return nil
 	}
 
@@ -135,7 +149,10 @@ func (Github) VerifyRepoAccess() error {
 
 // EnsurePATScopes validates that the current token has the required GHCR scopes:
 // read:packages, write:packages, and optionally delete:packages.
-func (Github) EnsurePATScopes() error {
+func (Github) EnsurePATScopes() (err error) {
+	run := startTarget("github:ensurePATScopes")
+	defer func() { run.finish(err) }()
+
 	token, err := loadGhcrToken()
 	if err != nil {
 		return fmt.Errorf("failed to load GHCR token: %w", err)
@@ -144,11 +161,10 @@ func (Github) EnsurePATScopes() error {
 	req, _ := http.NewRequest("GET", "https://api.github.com/user", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	client := &http.Client{Timeout: GithubHTTPTimeout}
-	resp, err := client.Do(req)
+	resp, err := httpDo(httpClient("api"), req)
 	if err != nil {
 		if strings.Contains(err.Error(), "no such host") {
-			fmt.Println("Skipping scope check (offline environment detected).")
+			logWarn("Skipping scope check (offline environment detected).")
 			return nil
 		}
 		return fmt.Errorf("failed to query GitHub API for token scopes: %w", err)
@@ -157,7 +173,7 @@ func (Github) EnsurePATScopes() error {
 
 	scopes := resp.Header.Get("X-OAuth-Scopes")
 	if scopes == "" {
-		fmt.Println("Warning: GitHub did not return any scope metadata. This may indicate an older or classic token.")
+		logWarn("GitHub did not return any scope metadata. This may indicate an older or classic token.")
 		return nil
 	}
 
@@ -185,12 +201,15 @@ func (Github) EnsurePATScopes() error {
 		return fmt.Errorf("GitHub token missing required or implied scopes: %s", strings.Join(missing, ", "))
 	}
 
-	fmt.Println("GitHub token scopes are sufficient for GHCR operations.")
+	logInfo("GitHub token scopes are sufficient for GHCR operations.")
 	return nil
 }
 
 // Whoami prints information about the GitHub user associated with the current token.
-func (Github) Whoami() error {
+func (Github) Whoami() (err error) {
+	run := startTarget("github:whoami")
+	defer func() { run.finish(err) }()
+
 	token, err := loadGhcrToken()
 	if err != nil {
 		return fmt.Errorf("failed to load GHCR token: %w", err)
@@ -199,11 +218,10 @@ func (Github) Whoami() error {
 	req, _ := http.NewRequest("GET", "https://api.github.com/user", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 
-	client := &http.Client{Timeout: GithubHTTPTimeout}
-	resp, err := client.Do(req)
+	resp, err := httpDo(httpClient("api"), req)
 	if err != nil {
 		if strings.Contains(err.Error(), "no such host") {
-			fmt.Println("Skipping user lookup (offline environment detected).")
+			logWarn("Skipping user lookup (offline environment detected).")
 			return nil
 		}
 		return fmt.Errorf("failed to query GitHub API: %w", err)
@@ -218,7 +236,7 @@ func (Github) Whoami() error {
 		return fmt.Errorf("failed to parse user information: %w", err)
 	}
 
-	fmt.Printf("Authenticated as GitHub user: %s (%s)\n", user.Login, user.Name)
+	logInfo("Authenticated as GitHub user: %s (%s)", user.Login, user.Name)
 	return nil
 }
 
@@ -279,11 +297,10 @@ func verifyGhcrToken(token string) error {
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Accept", "application/vnd.github+json")
 
-	client := &http.Client{Timeout: GithubHTTPTimeout}
-	resp, err := client.Do(req)
+	resp, err := httpDo(httpClient("api"), req)
 	if err != nil {
 		if strings.Contains(err.Error(), "no such host") {
-			fmt.Println("Skipping GitHub token verification (offline environment detected).")
+			logWarn("Skipping GitHub token verification (offline environment detected).")
 			return nil
 		}
 		return fmt.Errorf("failed to query GitHub API: %w", err)
@@ -299,7 +316,7 @@ func verifyGhcrToken(token string) error {
 
 	expiryHeader := resp.Header.Get("GitHub-Authentication-Token-Expiration")
 	if expiryHeader == "" {
-		fmt.Println("No expiration metadata found. Token may be classic or non-expiring.")
+		logWarn("No expiration metadata found. Token may be classic or non-expiring.")
 		return nil
 	}
 
@@ -321,16 +338,16 @@ func verifyGhcrToken(token string) error {
 	}
 
 	daysLeft := int(time.Until(expiry).Hours() / 24)
-	fmt.Printf("GitHub PAT expiration date: %s (%d days remaining)\n", expiry.Format(time.RFC1123), daysLeft)
+	logInfo("GitHub PAT expiration date: %s (%d days remaining)", expiry.Format(time.RFC1123), daysLeft)
 
 	const warnThreshold = 30
 	switch {
 	case daysLeft <= 0:
 		return fmt.Errorf("GitHub PAT has expired on %s — generate a new token immediately", expiry.Format("2006-01-02"))
 	case daysLeft <= warnThreshold:
-		fmt.Printf("Warning: GitHub PAT will expire in %d days. Consider renewing soon.\n", daysLeft)
+		logWarn("GitHub PAT will expire in %d days. Consider renewing soon.", daysLeft)
 	default:
-		fmt.Println("GitHub PAT is valid and not near expiration.")
+		logInfo("GitHub PAT is valid and not near expiration.")
 	}
 
 	return nil
@@ -4,12 +4,10 @@ package main
 
 import (
 	"fmt"
-	"os/exec"
 	"runtime"
 	"strings"
 
 	"github.com/magefile/mage/mg"
-	"github.com/magefile/mage/sh"
 )
 
 // TargetGoVersion defines the pinned Go version used for reproducible builds.
@@ -19,43 +17,52 @@ const TargetGoVersion = "1.25.3"
 type Go mg.Namespace
 
 // Verify checks that the Go toolchain is installed and matches the target version.
-func (Go) Verify() error {
-	fmt.Println("Verifying Go installation...")
+func (Go) Verify() (err error) {
+	run := startTarget("go:verify")
+	defer func() { run.finish(err) }()
+
+	logInfo("Verifying Go installation...")
 	if err := verifyGoVersion(); err != nil {
 		return err
 	}
-	fmt.Println("Go toolchain is correctly installed and verified.")
+	logInfo("Go toolchain is correctly installed and verified.")
 	return nil
 }
 
 // Deps ensures that the correct Go version is installed, installing it if necessary.
-func (Go) Deps() error {
-	fmt.Println("Ensuring Go dependencies...")
+func (Go) Deps() (err error) {
+	run := startTarget("go:deps")
+	defer func() { run.finish(err) }()
+
+	logInfo("Ensuring Go dependencies...")
 
 	if err := (Go{}).Verify(); err == nil {
-		fmt.Println("Go is already installed and up to date.")
+		logInfo("Go is already installed and up to date.")
 		return nil
 	}
 
-	fmt.Printf("Installing Go %s...\n", TargetGoVersion)
+	logInfo("Installing Go %s...", TargetGoVersion)
 	if err := installGoVersion(TargetGoVersion); err != nil {
 		return fmt.Errorf("failed to install Go %s: %w", TargetGoVersion, err)
 	}
 
-	fmt.Println("Re-verifying Go installation...")
+	if dryRun() {
+		return nil
+	}
+	logInfo("Re-verifying Go installation...")
 	if err := (Go{}).Verify(); err != nil {
 		return fmt.Errorf("Go installation did not verify successfully: %w", err)
 	}
 
-	fmt.Println("Go successfully installed and verified.")
+	logInfo("Go successfully installed and verified.")
 	return nil
 }
 
 // verifyGoVersion checks that the installed Go version matches the target version.
 func verifyGoVersion() error {
-	fmt.Printf("Target Go version: %s\n", TargetGoVersion)
+	logInfo("Target Go version: %s", TargetGoVersion)
 
-	out, err := exec.Command("go", "version").Output()
+	out, err := cmdOutput("go", "version")
 	if err != nil {
 		return fmt.Errorf("go binary not found in PATH")
 	}
@@ -79,26 +86,26 @@ func verifyGoVersion() error {
 // and warns if the pinned version is behind. If the system is offline or the
 // version check cannot be completed, it prints a notice and continues silently.
 func checkGoVersionLatest() {
-	out, err := exec.Command("curl", "-s", "https://go.dev/VERSION?m=text").Output()
+	out, err := cmdOutput("curl", "-s", "https://go.dev/VERSION?m=text")
 	if err != nil {
-		fmt.Println("Skipping Go version update check (network unavailable or offline).")
+		logWarn("Skipping Go version update check (network unavailable or offline).")
 		return
 	}
 
 	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
 	if len(lines) == 0 {
-		fmt.Println("Unable to parse Go version information from remote source.")
+		logWarn("Unable to parse Go version information from remote source.")
 		return
 	}
 
 	latest := strings.TrimPrefix(strings.TrimSpace(lines[0]), "go")
 	if latest == "" {
-		fmt.Println("Unable to parse Go version information from remote source.")
+		logWarn("Unable to parse Go version information from remote source.")
 		return
 	}
 
 	if latest != TargetGoVersion {
-		fmt.Printf("Note: a newer Go version is available (%s). You are pinned to %s.\n", latest, TargetGoVersion)
+		logInfo("Note: a newer Go version is available (%s). You are pinned to %s.", latest, TargetGoVersion)
 	}
 }
 
@@ -129,37 +136,41 @@ func installGoVersion(version string) error {
 	url := fmt.Sprintf("https://go.dev/dl/go%s.%s-%s.tar.gz", version, goOS, goArch)
 	tmpFile := fmt.Sprintf("/tmp/go%s.%s-%s.tar.gz", version, goOS, goArch)
 
-	fmt.Printf("Downloading Go %s for %s/%s...\n", version, goOS, goArch)
-	if err := sh.RunV("curl", "-L", "-o", tmpFile, url); err != nil {
+	logInfo("Downloading Go %s for %s/%s...", version, goOS, goArch)
+	if dryRun() {
+		plan(planRun, "download %s and install it to /usr/local/go (sudo), replacing the current Go", url)
+		return nil
+	}
+	if err := runCmd("curl", "-L", "-o", tmpFile, url); err != nil {
 		return fmt.Errorf("failed to download Go: %w", err)
 	}
 
 	// Attempt checksum verification if available
 	checksumURL := url + ".sha256"
 	checksumFile := tmpFile + ".sha256"
-	if err := sh.RunV("curl", "-s", "-L", "-o", checksumFile, checksumURL); err == nil {
-		fmt.Println("Verifying checksum...")
-		if err := sh.RunV("sha256sum", "-c", checksumFile); err != nil {
+	if err := runCmd("curl", "-s", "-L", "-o", checksumFile, checksumURL); err == nil {
+		logInfo("Verifying checksum...")
+		if err := runCmd("sha256sum", "-c", checksumFile); err != nil {
 			return fmt.Errorf("checksum verification failed: %w", err)
 		}
 	}
 
-	fmt.Println("Extracting Go to /usr/local/go (requires sudo)...")
-	if err := sh.RunV("sudo", "rm", "-rf", "/usr/local/go"); err != nil {
+	logInfo("Extracting Go to /usr/local/go (requires sudo)...")
+	if err := runCmd("sudo", "rm", "-rf", "/usr/local/go"); err != nil {
 		return err
 	}
-	if err := sh.RunV("sudo", "tar", "-C", "/usr/local", "-xzf", tmpFile); err != nil {
+	if err := runCmd("sudo", "tar", "-C", "/usr/local", "-xzf", tmpFile); err != nil {
 		return err
 	}
 
-	fmt.Println("Verifying installation...")
-	if err := sh.RunV("/usr/local/go/bin/go", "version"); err != nil {
+	logInfo("Verifying installation...")
+	if err := runCmd("/usr/local/go/bin/go", "version"); err != nil {
 		return err
 	}
 
 	// Check PATH visibility
-	if _, err := exec.LookPath("go"); err != nil {
-		fmt.Println("Note: /usr/local/go/bin may not be in your PATH. You may need to update your shell configuration.")
+	if _, err := runner.LookPath("go"); err != nil {
+		logWarn("/usr/local/go/bin may not be in your PATH. You may need to update your shell configuration.")
 	}
 
 	return nil
@@ -0,0 +1,103 @@
+//go:build mage
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestProject runs the test from a temporary copy of the repository's
+// Dockerfile template and baseline, with the plan state reset.
+func newTestProject(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, f := range []string{hardenedDockerfile, baselineFile} {
+		data, err := os.ReadFile(filepath.Join("..", f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, f), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+
+	planMu.Lock()
+	planActions, plannedFiles = nil, make(map[string]bool)
+	planMu.Unlock()
+	return dir
+}
+
+// captureStdout returns what fn printed to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	defer func() { os.Stdout = orig }()
+	fn()
+	w.Close()
+	os.Stdout = orig
+	return <-done
+}
+
+func TestHardenedAllDryRun(t *testing.T) {
+	newTestProject(t)
+	t.Setenv("DRY_RUN", "1")
+	fake := &FakeRunner{}
+	defer useRunner(fake)()
+
+	var err error
+	out := captureStdout(t, func() { err = (Hardened{}).All() })
+	if err != nil {
+		t.Fatalf("Hardened:All: %v\n%s", err, out)
+	}
+
+	if n := strings.Count(out, "would write: "+outputDockerfile); n != 1 {
+		t.Errorf("%s planned %d times, want 1:\n%s", outputDockerfile, n, out)
+	}
+	if n := strings.Count(out, "📝 Plan ("); n != 1 {
+		t.Errorf("plan printed %d times, want 1:\n%s", n, out)
+	}
+	if _, err := os.Stat(outputDockerfile); err == nil {
+		t.Errorf("dry run wrote %s", outputDockerfile)
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("dry run ran commands: %v", calls)
+	}
+}
+
+func TestBuildDockerfileRepinsStaleFile(t *testing.T) {
+	newTestProject(t)
+	meta, err := loadBaseline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := strings.Replace(meta.ManifestList, meta.ManifestList[len(meta.ManifestList)-4:], "0000", 1)
+	if err := os.WriteFile(outputDockerfile, []byte("ARG BASE_IMAGE_DIGEST="+stale+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := buildDockerfile(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pinnedDockerfileDigest(path); got != meta.ManifestList {
+		t.Errorf("pinned digest = %s, want %s", got, meta.ManifestList)
+	}
+}
@@ -0,0 +1,239 @@
+//go:build mage
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// baselineHistoryFile is an append-only JSON Lines log of every baseline ever synced.
+const baselineHistoryFile = buildDataDir + "/baseline-history.jsonl"
+
+// BaselineHistoryEntry records one upstream tag/manifest list pair as it was synced.
+type BaselineHistoryEntry struct {
+	Repository   string            `json:"repository"`
+	Tag          string            `json:"tag"`
+	ManifestList string            `json:"manifest_list"`
+	Digests      map[string]string `json:"digests"`
+	UpdatedAt    time.Time         `json:"updated_at"`  // baseline timestamp at sync time
+	RecordedAt   time.Time         `json:"recorded_at"` // when the entry was appended
+}
+
+// metadata converts a history entry back into baseline form.
+func (e BaselineHistoryEntry) metadata() MultiArchMetadata {
+	digests := make(map[string]string, len(e.Digests))
+	for k, v := range e.Digests {
+		digests[k] = v
+	}
+	return MultiArchMetadata{
+		Repository:   e.Repository,
+		Tag:          e.Tag,
+		ManifestList: e.ManifestList,
+		Digests:      digests,
+		UpdatedAt:    e.UpdatedAt,
+	}
+}
+
+// loadBaselineHistory reads all history entries in the order they were recorded.
+// A missing history file yields an empty history.
+func loadBaselineHistory() ([]BaselineHistoryEntry, error) {
+	file, err := os.Open(baselineHistoryFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read baseline history: %v", err)
+	}
+	defer file.Close()
+
+	var entries []BaselineHistoryEntry
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var e BaselineHistoryEntry
+		if err := json.Unmarshal([]byte(text), &e); err != nil {
+			return nil, fmt.Errorf("failed to parse %s line %d: %v", baselineHistoryFile, line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read baseline history: %v", err)
+	}
+	return entries, nil
+}
+
+// recordBaselineHistory appends meta to the history unless the same tag and
+// manifest list digest is already recorded. Existing lines are never rewritten.
+func recordBaselineHistory(meta MultiArchMetadata) error {
+	entries, err := loadBaselineHistory()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Tag == meta.Tag && e.ManifestList == meta.ManifestList {
+			return nil
+		}
+	}
+
+	entry := BaselineHistoryEntry{
+		Repository:   meta.Repository,
+		Tag:          meta.Tag,
+		ManifestList: meta.ManifestList,
+		Digests:      meta.Digests,
+		UpdatedAt:    meta.UpdatedAt,
+		RecordedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode baseline history entry: %v", err)
+	}
+
+	if dryRun() {
+		plan(planWrite, "%s (append %s %s)", baselineHistoryFile, meta.Tag, meta.ManifestList)
+		return nil
+	}
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(baselineHistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open baseline history: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append baseline history: %v", err)
+	}
+	logInfo("Recorded %s (%s) in baseline history.", meta.Tag, meta.ManifestList)
+	return nil
+}
+
+// findHistoryEntry returns the most recently recorded entry matching selector,
+// which may be a tag ("2.0.69"), a manifest list digest ("sha256:..."), or
+// both ("2.0.69@sha256:...") when upstream re-pushed a tag.
+func findHistoryEntry(entries []BaselineHistoryEntry, selector string) (*BaselineHistoryEntry, error) {
+	selector = strings.TrimSpace(selector)
+	if selector == "" {
+		return nil, fmt.Errorf("no tag or digest provided")
+	}
+
+	tag, digest := selector, ""
+	if strings.HasPrefix(selector, "sha256:") {
+		tag, digest = "", selector
+	} else if i := strings.Index(selector, "@"); i >= 0 {
+		tag, digest = selector[:i], selector[i+1:]
+	}
+
+	var matches []BaselineHistoryEntry
+	for _, e := range entries {
+		if tag != "" && e.Tag != tag {
+			continue
+		}
+		if digest != "" && e.ManifestList != digest {
+			continue
+		}
+		matches = append(matches, e)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no baseline history entry matches %q", selector)
+	}
+	if len(matches) > 1 && digest == "" {
+		logWarn("Tag %s was synced with %d different manifest lists; using the most recent. Use %s@<digest> to pick another.",
+			tag, len(matches), tag)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].RecordedAt.Before(matches[j].RecordedAt) })
+	latest := matches[len(matches)-1]
+	return &latest, nil
+}
+
+// History lists every baseline recorded in builddata, oldest first,
+// marking the entry that baseline.yaml currently points at.
+func (SrcDigest) History() (err error) {
+	run := startTarget("srcDigest:history")
+	defer func() { run.finish(err) }()
+
+	entries, err := loadBaselineHistory()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		logInfo("No baseline history recorded yet. Run mage srcdigest:sync to create one.")
+		return nil
+	}
+
+	var current MultiArchMetadata
+	if meta, err := loadBaseline(); err == nil {
+		current = *meta
+	}
+
+	logInfo("Baseline history (%s):", baselineHistoryFile)
+	fmt.Printf("  %-1s %-10s %-20s %-73s %s\n", "", "TAG", "RECORDED", "MANIFEST LIST", "ARCHES")
+	for _, e := range entries {
+		marker := ""
+		if e.Tag == current.Tag && e.ManifestList == current.ManifestList {
+			marker = "*"
+		}
+		arches := make([]string, 0, len(e.Digests))
+		for arch := range e.Digests {
+			arches = append(arches, arch)
+		}
+		sort.Strings(arches)
+		fmt.Printf("  %-1s %-10s %-20s %-73s %s\n",
+			marker, e.Tag, e.RecordedAt.Format(time.RFC3339), e.ManifestList, strings.Join(arches, ","))
+	}
+	return nil
+}
+
+// Rollback restores baseline.yaml from the history entry for the given tag
+// (or tag@digest / manifest list digest) so a previous image can be rebuilt exactly.
+func (SrcDigest) Rollback(tag string) (err error) {
+	run := startTarget("srcDigest:rollback")
+	defer func() { run.finish(err) }()
+
+	entries, err := loadBaselineHistory()
+	if err != nil {
+		return err
+	}
+
+	// Make sure the baseline being replaced is not lost if it predates history.
+	if current, err := loadBaseline(); err == nil {
+		if err := recordBaselineHistory(*current); err != nil {
+			return err
+		}
+		entries, err = loadBaselineHistory()
+		if err != nil {
+			return err
+		}
+	}
+
+	entry, err := findHistoryEntry(entries, tag)
+	if err != nil {
+		return err
+	}
+
+	meta := entry.metadata()
+	meta.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	if err := saveBaseline(meta); err != nil {
+		return err
+	}
+
+	logInfo("Baseline rolled back to Factorio %s (manifest list %s, recorded %s).",
+		meta.Tag, meta.ManifestList, entry.RecordedAt.Format(time.RFC3339))
+	for _, arch := range sortedKeys(meta.Digests) {
+		logInfo("  %s: %s", arch, meta.Digests[arch])
+	}
+	return nil
+}
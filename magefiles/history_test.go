@@ -0,0 +1,169 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Manifest lists of two later syncs of 2.0.72; upstream re-pushed the tag.
+const (
+	firstPush  = "sha256:7272727272727272727272727272727272727272727272727272727272727272"
+	secondPush = "sha256:7373737373737373737373737373737373737373737373737373737373737373"
+)
+
+// historyEntry is a synced baseline of tag with manifest list list, recorded on day.
+func historyEntry(tag, list string, day int) BaselineHistoryEntry {
+	at := time.Date(2025, 10, day, 12, 0, 0, 0, time.UTC)
+	return BaselineHistoryEntry{
+		Repository:   "factoriotools/factorio",
+		Tag:          tag,
+		ManifestList: list,
+		Digests:      map[string]string{"amd64": testAmd64Digest, "arm64": testArm64Digest},
+		UpdatedAt:    at,
+		RecordedAt:   at,
+	}
+}
+
+// writeHistory replaces the baseline history with entries.
+func writeHistory(t *testing.T, entries ...BaselineHistoryEntry) {
+	t.Helper()
+	var lines []string
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	if err := os.WriteFile(baselineHistoryFile, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindHistoryEntry(t *testing.T) {
+	entries := []BaselineHistoryEntry{
+		historyEntry("2.0.69", testListDigest, 26),
+		historyEntry("2.0.72", firstPush, 27),
+		historyEntry("2.0.72", secondPush, 28),
+	}
+	tests := []struct {
+		selector string
+		wantList string
+		wantErr  string
+	}{
+		{"2.0.69", testListDigest, ""},
+		{"2.0.72", secondPush, ""}, // most recent sync of a re-pushed tag
+		{"2.0.72@" + firstPush, firstPush, ""},
+		{firstPush, firstPush, ""},
+		{" 2.0.69 ", testListDigest, ""},
+		{"2.0.70", "", `no baseline history entry matches "2.0.70"`},
+		{"2.0.69@" + firstPush, "", "no baseline history entry matches"},
+		{"sha256:0000", "", "no baseline history entry matches"},
+		{"", "", "no tag or digest provided"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			var entry *BaselineHistoryEntry
+			var err error
+			captureStdout(t, func() { entry, err = findHistoryEntry(entries, tt.selector) })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("findHistoryEntry error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.ManifestList != tt.wantList {
+				t.Errorf("found %s %s, want %s", entry.Tag, entry.ManifestList, tt.wantList)
+			}
+		})
+	}
+}
+
+func TestLoadBaselineHistoryMalformedLine(t *testing.T) {
+	newTestProject(t)
+	good, err := json.Marshal(historyEntry("2.0.69", testListDigest, 26))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := string(good) + "\n\n{\"tag\": \"2.0.72\", \"manifest_list\":\n"
+	if err := os.WriteFile(baselineHistoryFile, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadBaselineHistory(); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("loadBaselineHistory error = %v, want one naming line 3", err)
+	}
+}
+
+func TestSrcDigestRollback(t *testing.T) {
+	newTestProject(t)
+	// baseline.yaml holds 2.0.69, which the history does not know yet.
+	writeHistory(t, historyEntry("2.0.72", firstPush, 27), historyEntry("2.0.72", secondPush, 28))
+	before, err := os.ReadFile(baselineHistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	captureStdout(t, func() { err = (SrcDigest{}).Rollback("2.0.72@" + firstPush) })
+	if err != nil {
+		t.Fatalf("SrcDigest:Rollback: %v", err)
+	}
+	meta, err := loadBaseline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Tag != "2.0.72" || meta.ManifestList != firstPush || meta.Digests["amd64"] != testAmd64Digest {
+		t.Errorf("baseline after rollback = %s %s %v, want 2.0.72 %s", meta.Tag, meta.ManifestList, meta.Digests, firstPush)
+	}
+
+	// The replaced baseline was appended; earlier lines are untouched.
+	after, err := os.ReadFile(baselineHistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(after), string(before)) {
+		t.Fatalf("history was rewritten:\n%s", after)
+	}
+	entries, err := loadBaselineHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].Tag != "2.0.69" || entries[2].ManifestList != testListDigest {
+		t.Errorf("history = %+v, want the replaced 2.0.69 baseline appended", entries)
+	}
+
+	// Rolling forward again finds every entry and appends nothing new.
+	captureStdout(t, func() { err = (SrcDigest{}).Rollback("2.0.69") })
+	if err != nil {
+		t.Fatalf("SrcDigest:Rollback: %v", err)
+	}
+	if again, _ := os.ReadFile(baselineHistoryFile); string(again) != string(after) {
+		t.Errorf("rolling back to a recorded baseline changed the history:\n%s", again)
+	}
+	if meta, err := loadBaseline(); err != nil || meta.ManifestList != testListDigest {
+		t.Errorf("baseline = %+v (%v), want 2.0.69 %s", meta, err, testListDigest)
+	}
+}
+
+func TestSrcDigestRollbackUnknownTag(t *testing.T) {
+	newTestProject(t)
+	writeHistory(t, historyEntry("2.0.69", testListDigest, 26))
+	before, err := os.ReadFile(baselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureStdout(t, func() { err = (SrcDigest{}).Rollback("1.1.110") })
+	if err == nil || !strings.Contains(err.Error(), "no baseline history entry matches") {
+		t.Fatalf("SrcDigest:Rollback error = %v, want an unknown entry", err)
+	}
+	if after, _ := os.ReadFile(baselineFile); string(after) != string(before) {
+		t.Error("failed rollback rewrote the baseline")
+	}
+}
@@ -0,0 +1,456 @@
+//go:build mage
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Layer media types the image reader can decompress.
+const (
+	mediaTypeOCILayerGzip    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeOCILayerTar     = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// defaultImagePlatform is used to pick a manifest from a multi-arch index.
+const defaultImagePlatform = "linux/amd64"
+
+// ociDescriptor references a blob or manifest by digest.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest is a single-platform OCI image manifest.
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// imageConfig is the runtime configuration section of an image config blob.
+type imageConfig struct {
+	User         string              `json:"User"`
+	Env          []string            `json:"Env"`
+	Entrypoint   []string            `json:"Entrypoint"`
+	Cmd          []string            `json:"Cmd"`
+	WorkingDir   string              `json:"WorkingDir"`
+	Labels       map[string]string   `json:"Labels"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	Volumes      map[string]struct{} `json:"Volumes"`
+	Healthcheck  *struct {
+		Test []string `json:"Test"`
+	} `json:"Healthcheck"`
+}
+
+// ociImageConfig is the image config blob referenced by a manifest.
+type ociImageConfig struct {
+	Architecture string      `json:"architecture"`
+	OS           string      `json:"os"`
+	Config       imageConfig `json:"config"`
+	RootFS       struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []struct {
+		CreatedBy  string `json:"created_by"`
+		EmptyLayer bool   `json:"empty_layer"`
+	} `json:"history"`
+}
+
+// imageLayer is one filesystem layer of an image; open returns its uncompressed tar stream.
+type imageLayer struct {
+	DiffID string
+	open   func() (io.ReadCloser, error)
+}
+
+// imageArtifact is an image opened for inspection, either from the local
+// docker daemon (via docker save) or from a registry.
+type imageArtifact struct {
+	Ref      string
+	Source   string // "docker" or "registry"
+	Digest   string // manifest digest (registry) or image ID (docker)
+	Platform string
+	Config   ociImageConfig
+	Layers   []imageLayer
+	cleanup  func()
+}
+
+// Close removes any temporary files backing the artifact.
+func (a *imageArtifact) Close() {
+	if a.cleanup != nil {
+		a.cleanup()
+	}
+}
+
+// openImage opens image from the local docker daemon if it exists there,
+// otherwise from its registry. platform selects from a multi-arch index
+// ("" means PLATFORM from the environment, or linux/amd64).
+func openImage(image, platform string) (*imageArtifact, error) {
+	if platform == "" {
+		platform = os.Getenv("PLATFORM")
+	}
+	if platform == "" {
+		platform = defaultImagePlatform
+	}
+	if err := runQuiet("docker", "image", "inspect", image); err == nil {
+		return openLocalImage(image)
+	}
+	return openRegistryImage(image, platform)
+}
+
+// openLocalImage exports image with docker save into a temporary directory.
+func openLocalImage(image string) (*imageArtifact, error) {
+	dir, err := os.MkdirTemp("", "factorio-image-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	art := &imageArtifact{Ref: image, Source: "docker", cleanup: func() { os.RemoveAll(dir) }}
+
+	// Stream docker save straight into the extractor through a pipe.
+	stdout, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := runCommand(Cmd{Name: "docker", Args: []string{"save", image}, Stdout: pw, Stderr: os.Stderr})
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	extractErr := extractTar(stdout, dir)
+	_, _ = io.Copy(io.Discard, stdout)
+	if err := <-done; err != nil {
+		art.Close()
+		return nil, fmt.Errorf("docker save %s failed: %v", image, err)
+	}
+	if extractErr != nil {
+		art.Close()
+		return nil, extractErr
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		art.Close()
+		return nil, fmt.Errorf("docker save archive has no manifest.json: %v", err)
+	}
+	var manifests []struct {
+		Config string   `json:"Config"`
+		Layers []string `json:"Layers"`
+	}
+	if err := json.Unmarshal(data, &manifests); err != nil || len(manifests) == 0 {
+		art.Close()
+		return nil, fmt.Errorf("failed to parse docker save manifest.json: %v", err)
+	}
+	m := manifests[0]
+
+	configData, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(m.Config)))
+	if err != nil {
+		art.Close()
+		return nil, fmt.Errorf("cannot read image config: %v", err)
+	}
+	if err := json.Unmarshal(configData, &art.Config); err != nil {
+		art.Close()
+		return nil, fmt.Errorf("failed to parse image config: %v", err)
+	}
+	art.Digest = digestOf(configData)
+	art.Platform = art.Config.OS + "/" + art.Config.Architecture
+
+	if len(m.Layers) != len(art.Config.RootFS.DiffIDs) {
+		art.Close()
+		return nil, fmt.Errorf("image has %d layers but %d diff IDs", len(m.Layers), len(art.Config.RootFS.DiffIDs))
+	}
+	for i, layerPath := range m.Layers {
+		path := filepath.Join(dir, filepath.FromSlash(layerPath))
+		art.Layers = append(art.Layers, imageLayer{
+			DiffID: art.Config.RootFS.DiffIDs[i],
+			open: func() (io.ReadCloser, error) {
+				f, err := os.Open(path)
+				if err != nil {
+					return nil, err
+				}
+				return maybeGunzip(f)
+			},
+		})
+	}
+	return art, nil
+}
+
+// extractTar writes the regular files of a tar stream below dir, rejecting
+// entries that would escape it. Symlinks must point inside dir, and nothing
+// is ever written through one, so a link entry cannot redirect later files.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read image archive: %v", err)
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if name == "." || strings.HasPrefix(name, "..") || filepath.IsAbs(name) {
+			continue
+		}
+		target := filepath.Join(dir, name)
+		if hdr.Typeflag == tar.TypeDir || hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeSymlink {
+			if err := checkNoSymlinks(dir, name); err != nil {
+				return fmt.Errorf("refusing to extract %s: %v", hdr.Name, err)
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0o644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %v", hdr.Name, err)
+			}
+		case tar.TypeSymlink:
+			// Older docker save archives link duplicate layers to a shared layer.tar.
+			link := filepath.FromSlash(hdr.Linkname)
+			if filepath.IsAbs(link) {
+				return fmt.Errorf("refusing to extract %s: absolute symlink target %s", hdr.Name, hdr.Linkname)
+			}
+			resolved := filepath.Join(filepath.Dir(target), link)
+			if rel, err := filepath.Rel(dir, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("refusing to extract %s: symlink target %s leaves the archive", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkNoSymlinks fails if name, or any directory on the way to it below
+// dir, is an existing symlink.
+func checkNoSymlinks(dir, name string) error {
+	path := dir
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", path)
+		}
+	}
+	return nil
+}
+
+// maybeGunzip returns rc decompressed if it starts with the gzip magic bytes.
+func maybeGunzip(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("failed to decompress layer: %v", err)
+		}
+		return readCloser{Reader: gz, close: rc.Close}, nil
+	}
+	return readCloser{Reader: br, close: rc.Close}, nil
+}
+
+// readCloser pairs a reader with the Close of the stream underneath it.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error { return r.close() }
+
+// openRegistryImage reads the manifest and config of image from its registry;
+// layers are streamed on demand.
+func openRegistryImage(image, platform string) (*imageArtifact, error) {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		return nil, err
+	}
+	client := newAuthenticatedRegistryClient(ref)
+
+	m, err := client.GetManifest(ref.Repository, ref.Reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest for %s: %v", image, err)
+	}
+	if isIndexMediaType(m.MediaType) {
+		var idx ImageIndex
+		if err := json.Unmarshal(m.Body, &idx); err != nil {
+			return nil, fmt.Errorf("failed to parse image index: %v", err)
+		}
+		child := ""
+		for _, entry := range idx.Manifests {
+			if !entry.IsAttestation() && entry.PlatformString() == platform {
+				child = entry.Digest
+				break
+			}
+		}
+		if child == "" {
+			return nil, fmt.Errorf("%s has no manifest for platform %s", image, platform)
+		}
+		if m, err = client.GetManifest(ref.Repository, child); err != nil {
+			return nil, fmt.Errorf("failed to fetch %s manifest for %s: %v", platform, image, err)
+		}
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(m.Body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse image manifest: %v", err)
+	}
+	configData, err := client.GetBlob(ref.Repository, manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image config: %v", err)
+	}
+
+	art := &imageArtifact{Ref: image, Source: "registry", Digest: m.Digest}
+	if err := json.Unmarshal(configData, &art.Config); err != nil {
+		return nil, fmt.Errorf("failed to parse image config: %v", err)
+	}
+	art.Platform = art.Config.OS + "/" + art.Config.Architecture
+
+	if len(manifest.Layers) != len(art.Config.RootFS.DiffIDs) {
+		return nil, fmt.Errorf("image has %d layers but %d diff IDs", len(manifest.Layers), len(art.Config.RootFS.DiffIDs))
+	}
+	for i, desc := range manifest.Layers {
+		desc := desc
+		switch desc.MediaType {
+		case mediaTypeOCILayerGzip, mediaTypeDockerLayerGzip, mediaTypeOCILayerTar:
+		default:
+			return nil, fmt.Errorf("unsupported layer media type %q", desc.MediaType)
+		}
+		art.Layers = append(art.Layers, imageLayer{
+			DiffID: art.Config.RootFS.DiffIDs[i],
+			open: func() (io.ReadCloser, error) {
+				rc, err := client.OpenBlob(ref.Repository, desc.Digest)
+				if err != nil {
+					return nil, err
+				}
+				return maybeGunzip(rc)
+			},
+		})
+	}
+	return art, nil
+}
+
+// walkLayer calls fn for every entry of a layer's tar stream. Entry names are
+// normalized to absolute paths ("/factorio/config").
+func walkLayer(layer imageLayer, fn func(name string, hdr *tar.Header) error) error {
+	rc, err := layer.open()
+	if err != nil {
+		return fmt.Errorf("failed to open layer %s: %v", layer.DiffID, err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read layer %s: %v", layer.DiffID, err)
+		}
+		if err := fn(path.Clean("/"+hdr.Name), hdr); err != nil {
+			return err
+		}
+	}
+	// Drain so registry streams reach EOF and verify their digest.
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
+
+// baseImageDiffIDs returns the layer diff IDs of the upstream base image for
+// platform, from the local docker daemon when available, else the registry.
+func baseImageDiffIDs(baseRef, platform string) ([]string, error) {
+	out, err := cmdOutput("docker", "image", "inspect", "--format", "{{json .RootFS.Layers}}", baseRef)
+	if err == nil {
+		var layers []string
+		if json.Unmarshal(out, &layers) == nil && len(layers) > 0 {
+			return layers, nil
+		}
+	}
+	art, err := openRegistryImage(baseRef, platform)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve base image layers for %s: %v", baseRef, err)
+	}
+	return art.Config.RootFS.DiffIDs, nil
+}
+
+// hardeningLayers returns the layers art adds on top of the upstream base
+// image and the number of base layers. The base is identified by the
+// org.opencontainers.image.base.digest label, or the baseline digest for the platform.
+func hardeningLayers(art *imageArtifact) ([]imageLayer, int, error) {
+	diffIDs := make([]string, len(art.Layers))
+	for i, l := range art.Layers {
+		diffIDs[i] = l.DiffID
+	}
+	n, err := baseLayerCount(art.Ref, art.Config, art.Platform, diffIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	return art.Layers[n:], n, nil
+}
+
+// baseLayerCount returns how many of diffIDs, the layers of image ref with
+// config cfg, come from the upstream base image.
+func baseLayerCount(ref string, cfg ociImageConfig, platform string, diffIDs []string) (int, error) {
+	baseDigest := cfg.Config.Labels[labelBaseDigest]
+	if !digestPattern.MatchString(baseDigest) {
+		meta, err := loadBaseline()
+		if err != nil {
+			return 0, fmt.Errorf("image has no usable %s label and no baseline: %v", labelBaseDigest, err)
+		}
+		baseDigest = meta.Digests[cfg.Architecture]
+	}
+
+	project, err := projectConfig()
+	if err != nil {
+		return 0, err
+	}
+	baseRef := fmt.Sprintf("%s@%s", project.UpstreamImage, baseDigest)
+	baseIDs, err := baseImageDiffIDs(baseRef, platform)
+	if err != nil {
+		return 0, err
+	}
+	if len(baseIDs) > len(diffIDs) {
+		return 0, fmt.Errorf("image has fewer layers than its base %s", baseRef)
+	}
+	for i, id := range baseIDs {
+		if diffIDs[i] != id {
+			return 0, fmt.Errorf("layer %d of %s does not match base image %s", i, ref, baseRef)
+		}
+	}
+	return len(baseIDs), nil
+}
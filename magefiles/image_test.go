@@ -0,0 +1,126 @@
+//go:build mage
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is one header of a hand-built archive; Body is used for regular files.
+type tarEntry struct {
+	Name, Link, Body string
+	Type             byte
+}
+
+func buildTar(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.Name, Linkname: e.Link, Typeflag: e.Type, Mode: 0o644, Size: int64(len(e.Body))}
+		if e.Type != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.Body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTarSharedLayerLink(t *testing.T) {
+	dir := t.TempDir()
+	archive := buildTar(t,
+		tarEntry{Name: "base/layer.tar", Body: "layer", Type: tar.TypeReg},
+		tarEntry{Name: "dup/layer.tar", Link: "../base/layer.tar", Type: tar.TypeSymlink},
+	)
+	if err := extractTar(archive, dir); err != nil {
+		t.Fatalf("extractTar: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "dup", "layer.tar"))
+	if err != nil || string(data) != "layer" {
+		t.Errorf("dup/layer.tar = %q (%v), want the shared layer", data, err)
+	}
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		wantErr string
+	}{
+		{"absolute link then write through it", []tarEntry{
+			{Name: "link", Link: "OUTSIDE", Type: tar.TypeSymlink},
+			{Name: "link/x", Body: "pwned", Type: tar.TypeReg},
+		}, "absolute symlink target"},
+		{"relative link out of dir", []tarEntry{
+			{Name: "a/link", Link: "../../OUTSIDE", Type: tar.TypeSymlink},
+			{Name: "a/link/x", Body: "pwned", Type: tar.TypeReg},
+		}, "leaves the archive"},
+		{"write through an inner link", []tarEntry{
+			{Name: "sub/", Type: tar.TypeDir},
+			{Name: "link", Link: "sub", Type: tar.TypeSymlink},
+			{Name: "link/x", Body: "redirected", Type: tar.TypeReg},
+		}, "is a symlink"},
+		{"overwrite a link", []tarEntry{
+			{Name: "file", Body: "inside", Type: tar.TypeReg},
+			{Name: "link", Link: "file", Type: tar.TypeSymlink},
+			{Name: "link", Body: "redirected", Type: tar.TypeReg},
+		}, "is a symlink"},
+		{"directory through a link", []tarEntry{
+			{Name: "sub/", Type: tar.TypeDir},
+			{Name: "link", Link: "sub", Type: tar.TypeSymlink},
+			{Name: "link/nested/", Type: tar.TypeDir},
+		}, "is a symlink"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			outside := filepath.Join(root, "outside")
+			if err := os.Mkdir(outside, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			dir := filepath.Join(root, "extract")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for i := range tt.entries {
+				switch tt.entries[i].Link {
+				case "OUTSIDE":
+					tt.entries[i].Link = outside
+				case "../../OUTSIDE":
+					tt.entries[i].Link = "../../outside"
+				}
+			}
+
+			err := extractTar(buildTar(t, tt.entries...), dir)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("extractTar error = %v, want %q", err, tt.wantErr)
+			}
+			if left, _ := os.ReadDir(outside); len(left) != 0 {
+				t.Errorf("extraction wrote outside its directory: %v", left)
+			}
+			for _, path := range []string{filepath.Join(dir, "sub", "x"), filepath.Join(dir, "sub", "nested")} {
+				if _, err := os.Stat(path); err == nil {
+					t.Errorf("extraction wrote %s through a symlink", path)
+				}
+			}
+			if data, err := os.ReadFile(filepath.Join(dir, "file")); err == nil && string(data) != "inside" {
+				t.Errorf("extraction overwrote file through a symlink: %q", data)
+			}
+		})
+	}
+}
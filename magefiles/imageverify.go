@@ -0,0 +1,284 @@
+//go:build mage
+
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Hardening contract enforced by Build:Verify.
+const (
+	labelBaseDigest    = "org.opencontainers.image.base.digest"
+	expectedEntrypoint = "/usr/local/bin/hardened-entrypoint.sh"
+	writableRoot       = "/factorio" // the only tree allowed to be world-writable
+)
+
+// requiredLabels must be present and non-empty on every hardened image.
+var requiredLabels = []string{
+	"org.opencontainers.image.title",
+	"org.opencontainers.image.description",
+	labelBaseDigest,
+}
+
+// VerifyCheck is the outcome of one hardening contract check.
+type VerifyCheck struct {
+	Name   string `json:"Name"`
+	Passed bool   `json:"Passed"`
+	Detail string `json:"Detail,omitempty"`
+}
+
+// ImageVerifyReport is the machine-readable result of Build:Verify.
+type ImageVerifyReport struct {
+	Image      string        `json:"Image"`
+	Source     string        `json:"Source"` // docker or registry
+	Digest     string        `json:"Digest"`
+	Platform   string        `json:"Platform"`
+	BaseLayers int           `json:"BaseLayers"`
+	Layers     int           `json:"Layers"`
+	Passed     bool          `json:"Passed"`
+	Checks     []VerifyCheck `json:"Checks"`
+	VerifiedAt time.Time     `json:"VerifiedAt"`
+}
+
+// Verify checks the hardening contract of a built image by reading its config
+// and layers directly: non-root user, required OCI labels with a real base
+// digest, the hardened ENTRYPOINT, a HEALTHCHECK, and no setuid binaries or
+// world-writable paths outside /factorio in the layers added on top of upstream.
+// image may be an image reference, or "test"/"prod" for the last build of that env.
+func (Build) Verify(image string) (err error) {
+	run := startTarget("build:verify")
+	defer func() { run.finish(err) }()
+
+	image, reportDir, err := resolveBuildImage(image)
+	if err != nil {
+		return err
+	}
+	reportPath := filepath.Join(reportDir, "verify.json")
+
+	logInfo("🔎 Verifying hardening contract of %s...", image)
+	report, err := verifyImage(image)
+	if err != nil {
+		return err
+	}
+
+	printVerifyChecks(report.Checks)
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode verify report: %v", err)
+	}
+	if err := writeFile(reportPath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write verify report: %v", err)
+	}
+	logInfo("🧾 Verify report written → %s", reportPath)
+
+	if !report.Passed {
+		return fmt.Errorf("image %s does not meet the hardening contract", image)
+	}
+	logInfo("✅ Hardening contract satisfied.")
+	return nil
+}
+
+// resolveBuildImage maps "test" or "prod" to the image of that environment's
+// last build record, and returns the directory reports for it belong in.
+// Any other argument is taken as an image reference reported under builddata/.
+func resolveBuildImage(arg string) (string, string, error) {
+	if arg != envTest && arg != envProd {
+		return arg, buildDataDir, nil
+	}
+	rec, err := readBuildRecord(arg)
+	if err != nil {
+		return "", "", err
+	}
+	image, err := recordImage(rec)
+	if err != nil {
+		return "", "", err
+	}
+	return image, filepath.Join(buildDataDir, arg), nil
+}
+
+// recordImage returns the image a build record describes. Prod builds are
+// referenced by pushed digest so a moved tag cannot be checked instead.
+func recordImage(rec *BuildRecord) (string, error) {
+	if rec.Env != envProd || rec.Digest == "" {
+		return rec.Tag, nil
+	}
+	ref, err := parseImageRef(rec.Tag)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(rec.Tag, ":"+ref.Reference) + "@" + rec.Digest, nil
+}
+
+// verifyImage opens image and runs every contract check against it.
+func verifyImage(image string) (*ImageVerifyReport, error) {
+	art, err := openImage(image, "")
+	if err != nil {
+		return nil, err
+	}
+	defer art.Close()
+
+	cfg := art.Config.Config
+	report := &ImageVerifyReport{
+		Image:      image,
+		Source:     art.Source,
+		Digest:     art.Digest,
+		Platform:   art.Platform,
+		Layers:     len(art.Layers),
+		VerifiedAt: time.Now().UTC(),
+	}
+	report.Checks = append(report.Checks,
+		checkConfigUser(cfg),
+		checkRequiredLabels(cfg),
+		checkEntrypoint(cfg),
+		checkHealthcheck(cfg),
+	)
+
+	layers, baseCount, err := hardeningLayers(art)
+	if err != nil {
+		report.Checks = append(report.Checks,
+			VerifyCheck{Name: "no setuid binaries", Detail: err.Error()},
+			VerifyCheck{Name: "no world-writable paths outside " + writableRoot, Detail: err.Error()},
+		)
+	} else {
+		report.BaseLayers = baseCount
+		setuid, writable, err := scanHardeningLayers(layers)
+		if err != nil {
+			return nil, err
+		}
+		report.Checks = append(report.Checks,
+			pathListCheck("no setuid binaries", setuid),
+			pathListCheck("no world-writable paths outside "+writableRoot, writable),
+		)
+	}
+
+	report.Passed = true
+	for _, c := range report.Checks {
+		if !c.Passed {
+			report.Passed = false
+		}
+	}
+	return report, nil
+}
+
+func checkConfigUser(cfg imageConfig) VerifyCheck {
+	c := VerifyCheck{Name: "non-root user", Detail: fmt.Sprintf("User=%q", cfg.User)}
+	name := strings.SplitN(cfg.User, ":", 2)[0]
+	c.Passed = name != "" && name != "root" && name != "0"
+	return c
+}
+
+func checkRequiredLabels(cfg imageConfig) VerifyCheck {
+	c := VerifyCheck{Name: "required OCI labels"}
+	var problems []string
+	for _, label := range requiredLabels {
+		if strings.TrimSpace(cfg.Labels[label]) == "" {
+			problems = append(problems, label+" missing")
+		}
+	}
+	if v := cfg.Labels[labelBaseDigest]; v != "" && !digestPattern.MatchString(v) {
+		problems = append(problems, fmt.Sprintf("%s is %q, not an expanded sha256 digest", labelBaseDigest, v))
+	}
+	c.Passed = len(problems) == 0
+	if c.Passed {
+		c.Detail = "base " + cfg.Labels[labelBaseDigest]
+	} else {
+		c.Detail = strings.Join(problems, "; ")
+	}
+	return c
+}
+
+func checkEntrypoint(cfg imageConfig) VerifyCheck {
+	c := VerifyCheck{Name: "entrypoint", Detail: fmt.Sprintf("%q", cfg.Entrypoint)}
+	c.Passed = len(cfg.Entrypoint) == 1 && cfg.Entrypoint[0] == expectedEntrypoint
+	if !c.Passed {
+		c.Detail += fmt.Sprintf(", expected [%q]", expectedEntrypoint)
+	}
+	return c
+}
+
+func checkHealthcheck(cfg imageConfig) VerifyCheck {
+	c := VerifyCheck{Name: "healthcheck"}
+	if hc := cfg.Healthcheck; hc != nil && len(hc.Test) > 0 && hc.Test[0] != "NONE" {
+		c.Passed = true
+		c.Detail = strings.Join(hc.Test, " ")
+	} else {
+		c.Detail = "no HEALTHCHECK defined"
+	}
+	return c
+}
+
+// hardeningIssues applies the setuid and world-writable rules shared by
+// Build:Verify and Build:Audit to one filesystem entry. Symlinks never count;
+// world-writable paths are allowed under /factorio and as sticky directories
+// such as /tmp.
+func hardeningIssues(name string, typ byte, mode int64) (setuid, worldWritable bool) {
+	if typ == tar.TypeSymlink {
+		return false, false
+	}
+	inFactorio := name == writableRoot || strings.HasPrefix(name, writableRoot+"/")
+	sticky := typ == tar.TypeDir && mode&01000 != 0
+	return mode&04000 != 0, mode&0o002 != 0 && !inFactorio && !sticky
+}
+
+// scanHardeningLayers lists the paths in the given layers that break the
+// hardeningIssues rules. Whiteouts are ignored.
+func scanHardeningLayers(layers []imageLayer) (setuid, writable []string, err error) {
+	for _, layer := range layers {
+		err := walkLayer(layer, func(name string, hdr *tar.Header) error {
+			if strings.HasPrefix(filepath.Base(name), ".wh.") {
+				return nil
+			}
+			isSetuid, isWritable := hardeningIssues(name, hdr.Typeflag, hdr.Mode)
+			if isSetuid {
+				setuid = append(setuid, name)
+			}
+			if isWritable {
+				writable = append(writable, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return setuid, writable, nil
+}
+
+// pathListCheck passes when paths is empty and otherwise lists the offenders.
+func pathListCheck(name string, paths []string) VerifyCheck {
+	c := VerifyCheck{Name: name, Passed: len(paths) == 0}
+	if c.Passed {
+		c.Detail = "none in hardening layers"
+		return c
+	}
+	const maxListed = 10
+	listed := paths
+	if len(listed) > maxListed {
+		listed = listed[:maxListed]
+	}
+	c.Detail = strings.Join(listed, ", ")
+	if len(paths) > maxListed {
+		c.Detail += fmt.Sprintf(" (+%d more)", len(paths)-maxListed)
+	}
+	return c
+}
+
+// printVerifyChecks prints the pass/fail table.
+func printVerifyChecks(checks []VerifyCheck) {
+	fmt.Printf("  %-6s %-42s %s\n", "RESULT", "CHECK", "DETAIL")
+	for _, c := range checks {
+		result := "PASS"
+		if !c.Passed {
+			result = "FAIL"
+		}
+		fmt.Printf("  %-6s %-42s %s\n", result, c.Name, c.Detail)
+	}
+}
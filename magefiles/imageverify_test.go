@@ -0,0 +1,101 @@
+//go:build mage
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+)
+
+type testEntry struct {
+	name string
+	typ  byte
+	mode int64
+}
+
+// tarLayer returns an in-memory layer holding entries.
+func tarLayer(t *testing.T, entries []testEntry) imageLayer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: e.mode}
+		if e.typ == tar.TypeSymlink {
+			hdr.Linkname = "/bin/true"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	return imageLayer{DiffID: "sha256:test", open: func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}}
+}
+
+var hardeningEntries = []testEntry{
+	{"usr/bin/su", tar.TypeReg, 04755},
+	{"usr/bin/ls", tar.TypeReg, 0o755},
+	{"tmp", tar.TypeDir, 01777},
+	{"data", tar.TypeDir, 0o777},
+	{"etc/motd", tar.TypeReg, 0o666},
+	{"factorio/saves", tar.TypeDir, 0o777},
+	{"etc/alternatives/x", tar.TypeSymlink, 0o777},
+	{"opt/.wh.old", tar.TypeReg, 0o777},
+}
+
+func TestScanHardeningLayers(t *testing.T) {
+	setuid, writable, err := scanHardeningLayers([]imageLayer{tarLayer(t, hardeningEntries)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/usr/bin/su"}; !slices.Equal(setuid, want) {
+		t.Errorf("setuid = %v, want %v", setuid, want)
+	}
+	if want := []string{"/data", "/etc/motd"}; !slices.Equal(writable, want) {
+		t.Errorf("writable = %v, want %v", writable, want)
+	}
+}
+
+// The audit must block exactly the paths Build:Verify fails on when they come
+// from our layers, and only report them when they come from upstream.
+func TestAuditAgreesWithVerify(t *testing.T) {
+	setuid, writable, err := scanHardeningLayers([]imageLayer{tarLayer(t, hardeningEntries)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyFails := append(append([]string{}, setuid...), writable...)
+	slices.Sort(verifyFails)
+
+	for _, tt := range []struct {
+		layer int
+		want  []string
+	}{
+		{layer: 1, want: verifyFails}, // hardening layer
+		{layer: 0, want: nil},         // upstream layer
+	} {
+		fs := make(map[string]auditEntry)
+		for _, e := range hardeningEntries {
+			if e.name == "opt/.wh.old" {
+				continue // whiteouts never reach the merged filesystem
+			}
+			fs["/"+e.name] = auditEntry{Mode: e.mode, UID: 845, Type: e.typ, Layer: tt.layer}
+		}
+		var blocking []string
+		for _, f := range auditFindings(fs, 1) {
+			if f.Blocking {
+				blocking = append(blocking, f.Path)
+			}
+		}
+		slices.Sort(blocking)
+		if !slices.Equal(blocking, tt.want) {
+			t.Errorf("layer %d: audit blocks %v, verify fails on %v", tt.layer, blocking, tt.want)
+		}
+	}
+}
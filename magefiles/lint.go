@@ -4,60 +4,76 @@ package main
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
-	"runtime"
 	"strings"
 
 	"github.com/magefile/mage/mg"
-	"github.com/magefile/mage/sh"
 )
 
+// lintConfig is the golangci-lint configuration used by Lint.Run.
+const lintConfig = ".golangci.yml"
+
 // Lint namespace handles golangci-lint installation and linting checks.
 type Lint mg.Namespace
 
-// Verify checks that golangci-lint is installed and compatible with the current Go version.
-func (Lint) Verify() error {
-	fmt.Println("Verifying golangci-lint installation...")
+// Verify checks that the pinned golangci-lint is installed and compatible with the target Go version.
+func (Lint) Verify() (err error) {
+	run := startTarget("lint:verify")
+	defer func() { run.finish(err) }()
+
+	logInfo("Verifying golangci-lint installation...")
 	if err := verifyLinter(); err != nil {
 		return err
 	}
-	fmt.Println("golangci-lint is correctly installed and compatible.")
+	logInfo("golangci-lint is correctly installed and compatible.")
 	return nil
 }
 
-// Deps ensures that golangci-lint is installed and built with the current Go version.
-func (Lint) Deps() error {
-	fmt.Println("Ensuring golangci-lint dependencies...")
+// Deps ensures that the golangci-lint release pinned in tools.lock.yaml is
+// installed in .tools/bin.
+func (Lint) Deps() (err error) {
+	run := startTarget("lint:deps")
+	defer func() { run.finish(err) }()
+
+	logInfo("Ensuring golangci-lint dependencies...")
 
 	if err := (Lint{}).Verify(); err == nil {
 		return nil
 	}
 
-	fmt.Println("Installing or rebuilding golangci-lint...")
-	if err := installLinter(); err != nil {
+	logInfo("Installing pinned golangci-lint...")
+	if err := installPinnedTool("golangci-lint"); err != nil {
 		return fmt.Errorf("failed to install golangci-lint: %w", err)
 	}
 
-	fmt.Println("Re-verifying golangci-lint installation...")
+	if dryRun() {
+		return nil
+	}
+	logInfo("Re-verifying golangci-lint installation...")
 	if err := (Lint{}).Verify(); err != nil {
 		return fmt.Errorf("golangci-lint installation did not verify successfully: %w", err)
 	}
 
-	fmt.Println("golangci-lint successfully installed and verified.")
+	logInfo("golangci-lint successfully installed and verified.")
 	return nil
 }
 
-// Run executes golangci-lint using the project configuration.
-func (Lint) Run() error {
-	fmt.Println("Running golangci-lint checks...")
+// Run executes golangci-lint with the checked-in .golangci.yml, which also
+// lints the mage-tagged magefiles.
+func (Lint) Run() (err error) {
+	run := startTarget("lint:run")
+	defer func() { run.finish(err) }()
+
+	logInfo("Running golangci-lint checks...")
 
-	cmd := exec.Command("golangci-lint", "run")
-	out, err := cmd.CombinedOutput()
+	bin, err := toolBinary("golangci-lint")
+	if err != nil {
+		return err
+	}
+	out, err := cmdCombinedOutput(bin, "run", "--config", lintConfig, "./...")
 	output := string(out)
 
 	if strings.Contains(output, "no go files to analyze") {
-		fmt.Println("No Go packages found — skipping lint.")
+		logInfo("No Go packages found — skipping lint.")
 		return nil
 	}
 
@@ -66,60 +82,65 @@ func (Lint) Run() error {
 		return fmt.Errorf("linting failed: %w", err)
 	}
 
-	fmt.Println("No lint issues found.")
+	logInfo("No lint issues found.")
 	return nil
 }
 
-// verifyLinter checks whether golangci-lint is installed and compatible with the current Go version.
+// verifyLinter checks that golangci-lint is the version pinned in
+// tools.lock.yaml and was built with a Go release at least as new as
+// TargetGoVersion, compared by major and minor version.
 func verifyLinter() error {
-	currentGo := strings.TrimPrefix(runtime.Version(), "go")
-	path, err := exec.LookPath("golangci-lint")
+	lock, err := loadToolsLock()
 	if err != nil {
-		return fmt.Errorf("golangci-lint not found in PATH")
+		return err
+	}
+	t, ok := lock.Tools["golangci-lint"]
+	if !ok {
+		return fmt.Errorf("golangci-lint is not in %s", toolsLockPath())
+	}
+	want, ok := parseSemver(t.Version)
+	if !ok {
+		return fmt.Errorf("invalid golangci-lint version %q in %s", t.Version, toolsLockPath())
 	}
 
-	out, err := exec.Command(path, "version").CombinedOutput()
+	bin, err := toolBinary("golangci-lint")
 	if err != nil {
-		return fmt.Errorf("failed to execute golangci-lint: %w", err)
+		return err
 	}
-
-	outStr := string(out)
-	if !strings.Contains(outStr, "built with go1.") {
-		fmt.Println("golangci-lint found, but build version information is unavailable — rebuilding recommended.")
-		return fmt.Errorf("unable to determine golangci-lint build version")
+	out, err := cmdCombinedOutput(bin, "version")
+	if err != nil {
+		return fmt.Errorf("failed to execute golangci-lint: %w", err)
 	}
 
-	fields := strings.Fields(outStr)
-	for _, f := range fields {
+	// e.g. "golangci-lint has version 2.4.0 built with go1.25.0 from 43d0339 on 2025-08-13T23:36:29Z"
+	fields := strings.Fields(string(out))
+	var tool, buildGo string
+	for i, f := range fields {
+		if f == "version" && i > 0 && fields[i-1] == "has" && i+1 < len(fields) {
+			tool = fields[i+1]
+		}
 		if strings.HasPrefix(f, "go1.") {
-			buildGo := strings.TrimPrefix(f, "go")
-			if buildGo < currentGo {
-				return fmt.Errorf("golangci-lint built with Go %s < current %s", buildGo, currentGo)
-			}
-			return nil
+			buildGo = f
 		}
 	}
 
-	return fmt.Errorf("unable to determine golangci-lint build version")
-}
-
-// installLinter installs or rebuilds golangci-lint to match the current Go version.
-func installLinter() error {
-	fmt.Println("Installing golangci-lint using current Go toolchain...")
-	if err := sh.RunV("go", "install", "github.com/golangci/golangci-lint/cmd/golangci-lint@latest"); err != nil {
-		return fmt.Errorf("failed to install golangci-lint: %w", err)
+	got, ok := parseSemver(tool)
+	if !ok {
+		return fmt.Errorf("unable to determine golangci-lint version from %q", strings.TrimSpace(string(out)))
+	}
+	if got.compare(want) != 0 {
+		return fmt.Errorf("golangci-lint version mismatch: found %s at %s, expected %s", got, bin, want)
 	}
 
-	addGoBinToPath()
-	return nil
-}
-
-// addGoBinToPath ensures that the Go bin directory is included in PATH for the current session.
-func addGoBinToPath() {
-	goBin := fmt.Sprintf("%s/go/bin", os.Getenv("HOME"))
-	path := os.Getenv("PATH")
-	if !strings.Contains(path, goBin) {
-		os.Setenv("PATH", fmt.Sprintf("%s:%s", goBin, path))
-		fmt.Println("Added Go bin directory to PATH for this session.")
+	built, ok := parseSemver(buildGo)
+	if !ok {
+		return fmt.Errorf("unable to determine golangci-lint build version")
+	}
+	target, _ := parseSemver(TargetGoVersion)
+	// Patch releases do not change the language, so only major.minor matter.
+	if (semver{Major: built.Major, Minor: built.Minor}).compare(semver{Major: target.Major, Minor: target.Minor}) < 0 {
+		return fmt.Errorf("golangci-lint %s built with Go %s cannot analyze Go %s code; bump it in %s",
+			got, built, TargetGoVersion, toolsLockPath())
 	}
+	return nil
 }
@@ -0,0 +1,238 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels, lowest first. MAGE_LOG_LEVEL picks the minimum printed (default info).
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// Span statuses reported in step events.
+const (
+	statusStart  = "start"
+	statusOK     = "ok"
+	statusFailed = "failed"
+)
+
+// LogEvent is one line of the MAGE_LOG_FORMAT=json event stream.
+type LogEvent struct {
+	Time       time.Time `json:"time"`
+	Level      string    `json:"level"`
+	Event      string    `json:"event"` // "target", "step" or "log"
+	Target     string    `json:"target,omitempty"`
+	Step       string    `json:"step,omitempty"` // nested steps are joined with " / "
+	Status     string    `json:"status,omitempty"`
+	DurationMS *int64    `json:"duration_ms,omitempty"` // set on ok and failed events
+	Error      string    `json:"error,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+var (
+	logMu sync.Mutex
+	// eventOut receives JSON events. In JSON mode it keeps the real stdout and
+	// everything else printed by targets and child processes goes to stderr,
+	// so stdout is a clean one-event-per-line stream.
+	eventOut io.Writer = os.Stdout
+	// spanStack holds the open spans, innermost last.
+	spanStack []*span
+)
+
+func init() {
+	if jsonLogs() {
+		eventOut = os.Stdout
+		os.Stdout = os.Stderr
+	}
+}
+
+// jsonLogs reports whether MAGE_LOG_FORMAT=json is set.
+func jsonLogs() bool {
+	return strings.EqualFold(os.Getenv("MAGE_LOG_FORMAT"), "json")
+}
+
+// minLogLevel returns the level set by MAGE_LOG_LEVEL.
+func minLogLevel() int {
+	want := strings.ToLower(os.Getenv("MAGE_LOG_LEVEL"))
+	for i, name := range levelNames {
+		if name == want {
+			return i
+		}
+	}
+	return levelInfo
+}
+
+// emit writes ev to the event stream in JSON mode, or prints text to the console.
+func emit(level int, ev LogEvent, text string) {
+	if level < minLogLevel() {
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	if !jsonLogs() {
+		if text != "" {
+			fmt.Println(text)
+		}
+		return
+	}
+	ev.Time = time.Now().UTC()
+	ev.Level = levelNames[level]
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(eventOut, string(data))
+}
+
+// logf logs a message at level, attributed to the innermost open span.
+func logf(level int, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	ev := LogEvent{Event: "log", Message: msg}
+	if len(spanStack) > 0 {
+		s := spanStack[len(spanStack)-1]
+		if s.child != nil {
+			s = s.child
+		}
+		ev.Target, ev.Step = s.target, s.path()
+	}
+	prefix := map[int]string{levelDebug: "🐞 ", levelWarn: "⚠️  ", levelError: "❌ "}[level]
+	emit(level, ev, prefix+msg)
+}
+
+func logDebug(format string, args ...any) { logf(levelDebug, format, args...) }
+func logInfo(format string, args ...any)  { logf(levelInfo, format, args...) }
+func logWarn(format string, args ...any)  { logf(levelWarn, format, args...) }
+func logError(format string, args ...any) { logf(levelError, format, args...) }
+
+// currentStepLabel names the innermost open step, e.g. "build:prod › push",
+// or returns "" outside any target span.
+func currentStepLabel() string {
+	if len(spanStack) == 0 {
+		return ""
+	}
+	s := spanStack[len(spanStack)-1]
+	if s.child != nil {
+		s = s.child
+	}
+	return s.label()
+}
+
+// span times a target or one of its steps.
+type span struct {
+	target string
+	name   string // empty for the target itself
+	parent *span
+	start  time.Time
+	child  *span // open step, if any
+}
+
+// path returns the step names from the target down to s.
+func (s *span) path() string {
+	var names []string
+	for p := s; p != nil; p = p.parent {
+		if p.name != "" {
+			names = append([]string{p.name}, names...)
+		}
+	}
+	return strings.Join(names, " / ")
+}
+
+// startTarget opens a span for a mage target. A target run from inside
+// another one, such as Build:Test from Hardened:All, becomes a sub-step of
+// the caller's current step.
+func startTarget(target string) *span {
+	s := &span{target: target, start: time.Now()}
+	if n := len(spanStack); n > 0 {
+		parent := spanStack[n-1]
+		if parent.child != nil {
+			parent = parent.child
+		}
+		s.parent, s.target, s.name = parent, parent.target, target
+	}
+	spanStack = append(spanStack, s)
+	s.emitStart()
+	return s
+}
+
+// step ends the span's current step, if any, as successful and starts the next.
+func (s *span) step(name string) {
+	if s.child != nil {
+		s.child.end(nil)
+	}
+	s.child = &span{target: s.target, name: name, parent: s, start: time.Now()}
+	s.child.emitStart()
+}
+
+// finish ends the open step with err and then the span itself, printing the
+// dry-run plan first when s is the outermost target. It returns err so targets
+// can `defer func() { run.finish(err) }()` with a named result.
+func (s *span) finish(err error) error {
+	if s.child != nil {
+		s.child.end(err)
+	}
+	if len(spanStack) == 1 && spanStack[0] == s {
+		printPlan()
+	}
+	s.end(err)
+	for i := len(spanStack) - 1; i >= 0; i-- {
+		if spanStack[i] == s {
+			spanStack = spanStack[:i]
+			break
+		}
+	}
+	return err
+}
+
+// emitStart reports a started span. The console shows it only at debug level.
+func (s *span) emitStart() {
+	ev := LogEvent{Event: s.kind(), Target: s.target, Step: s.path(), Status: statusStart}
+	text := ""
+	if minLogLevel() == levelDebug {
+		text = fmt.Sprintf("▶️  %s", s.label())
+	}
+	emit(levelInfo, ev, text)
+}
+
+// end reports the span's outcome and duration.
+func (s *span) end(err error) {
+	d := time.Since(s.start)
+	ms := d.Milliseconds()
+	ev := LogEvent{Event: s.kind(), Target: s.target, Step: s.path(), Status: statusOK, DurationMS: &ms}
+	level, text := levelInfo, fmt.Sprintf("⏱️  %s: ok in %s", s.label(), d.Round(time.Millisecond))
+	if err != nil {
+		ev.Status, ev.Error = statusFailed, err.Error()
+		level, text = levelError, fmt.Sprintf("⏱️  %s: failed after %s", s.label(), d.Round(time.Millisecond))
+	}
+	emit(level, ev, text)
+	if s.parent != nil && s.parent.child == s {
+		s.parent.child = nil
+	}
+}
+
+func (s *span) kind() string {
+	if s.name == "" {
+		return "target"
+	}
+	return "step"
+}
+
+// label names the span for console output, e.g. "build:prod › push".
+func (s *span) label() string {
+	if p := s.path(); p != "" {
+		return s.target + " › " + strings.ReplaceAll(p, " / ", " › ")
+	}
+	return s.target
+}
@@ -0,0 +1,56 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONLogsEventStream(t *testing.T) {
+	newTestProject(t)
+	writeTrivyReportFile(t, "old.json", localTestTag, laterID)
+	writeTrivyReportFile(t, "new.json", localTestTag, testImageID)
+	t.Setenv("MAGE_LOG_FORMAT", "json")
+	var events bytes.Buffer
+	orig := eventOut
+	eventOut = &events
+	defer func() { eventOut = orig }()
+
+	var err error
+	out := captureStdout(t, func() { err = (Trivy{}).Diff("old.json", "new.json") })
+	if err != nil {
+		t.Fatalf("Trivy:Diff: %v", err)
+	}
+
+	var got []LogEvent
+	for _, line := range strings.Split(strings.TrimSpace(events.String()), "\n") {
+		var ev LogEvent
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("event stream line %q is not an event: %v", line, err)
+		}
+		got = append(got, ev)
+	}
+	if first := got[0]; first.Event != "target" || first.Target != "trivy:diff" || first.Status != statusStart {
+		t.Errorf("first event = %+v, want trivy:diff start", first)
+	}
+	if last := got[len(got)-1]; last.Event != "target" || last.Status != statusOK || last.DurationMS == nil {
+		t.Errorf("last event = %+v, want trivy:diff ok with a duration", last)
+	}
+	logged := false
+	for _, ev := range got {
+		if ev.Event == "log" && ev.Target == "trivy:diff" && strings.HasPrefix(ev.Message, "🧾 Vulnerability diff written") {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Errorf("diff step output is not in the event stream:\n%s", events.String())
+	}
+	if strings.Contains(out, "Vulnerability diff written") {
+		t.Errorf("step output also printed as text:\n%s", out)
+	}
+}
@@ -0,0 +1,131 @@
+//go:build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Kinds of planned actions.
+const (
+	planRun    = "run"    // external command that changes the host
+	planWrite  = "write"  // file created or overwritten
+	planRemove = "remove" // file, package or container removed
+	planBuild  = "build"  // image built
+	planPush   = "push"   // image pushed to a registry
+	planTag    = "tag"    // tag moved to a new digest
+	planSign   = "sign"   // signature created
+	planCheck  = "check"  // check that needs the outcome of an earlier planned step
+)
+
+// PlanAction is one step a mutating target would take.
+type PlanAction struct {
+	Kind   string
+	Detail string
+}
+
+var (
+	planMu      sync.Mutex
+	planActions []PlanAction
+	// plannedFiles holds the paths writeFile skipped, so later steps can
+	// treat them as present instead of planning them again.
+	plannedFiles = make(map[string]bool)
+)
+
+// dryRun reports whether plan mode is on. With DRY_RUN set, mutating steps
+// are recorded and printed instead of executed, and read-only checks still run.
+func dryRun() bool {
+	return os.Getenv("DRY_RUN") != ""
+}
+
+// plan records a step that plan mode skips and prints it with its position.
+func plan(kind, format string, args ...any) {
+	planMu.Lock()
+	defer planMu.Unlock()
+	a := PlanAction{Kind: kind, Detail: fmt.Sprintf(format, args...)}
+	planActions = append(planActions, a)
+	logInfo("📝 [dry-run] %d. would %s: %s", len(planActions), a.Kind, a.Detail)
+}
+
+// printPlan prints every step recorded so far, in order. The outermost
+// target's span calls it as it finishes; it does nothing outside plan mode or
+// from a target nested in another one, so the plan is printed once.
+func printPlan() {
+	if !dryRun() || len(spanStack) > 1 {
+		return
+	}
+	planMu.Lock()
+	defer planMu.Unlock()
+	if len(planActions) == 0 {
+		logInfo("📝 Plan: nothing to change.")
+		return
+	}
+	logInfo("📝 Plan (%d steps, nothing was changed):", len(planActions))
+	for i, a := range planActions {
+		fmt.Printf("  %2d. %-6s %s\n", i+1, a.Kind, a.Detail)
+	}
+}
+
+// runMutating runs a command that changes the host, or records it in plan mode.
+func runMutating(kind, name string, args ...string) error {
+	if dryRun() {
+		plan(kind, "%s", strings.TrimSpace(name+" "+strings.Join(args, " ")))
+		return nil
+	}
+	return runCmd(name, args...)
+}
+
+// writeFile writes data to path, or records the write in plan mode.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if dryRun() {
+		plan(planWrite, "%s (%d bytes)", path, len(data))
+		planMu.Lock()
+		plannedFiles[path] = true
+		planMu.Unlock()
+		return nil
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+// makeDirs creates dirs and their parents. In plan mode it creates nothing;
+// the writes into them are recorded instead.
+func makeDirs(dirs ...string) error {
+	if dryRun() {
+		return nil
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// scratchFile returns where a tool should write the file meant for path and a
+// cleanup func. In plan mode the write is recorded and a temporary path is
+// returned instead, so read-only checks that need the file still run.
+func scratchFile(path string) (string, func(), error) {
+	if !dryRun() {
+		if err := makeDirs(filepath.Dir(path)); err != nil {
+			return "", nil, err
+		}
+		return path, func() {}, nil
+	}
+	plan(planWrite, "%s", path)
+	dir, err := os.MkdirTemp("", "mage-plan-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create scratch directory: %v", err)
+	}
+	return filepath.Join(dir, filepath.Base(path)), func() { os.RemoveAll(dir) }, nil
+}
+
+// plannedFile reports whether writeFile recorded path in plan mode.
+func plannedFile(path string) bool {
+	planMu.Lock()
+	defer planMu.Unlock()
+	return plannedFiles[path]
+}
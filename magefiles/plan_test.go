@@ -0,0 +1,124 @@
+//go:build mage
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// snapshotTree returns the contents of every file under the working directory.
+func snapshotTree(t *testing.T) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		files[path] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestDryRunWritesNothing(t *testing.T) {
+	newScanProject(t)
+	rec := &BuildRecord{Env: envTest, Tag: localTestTag, Arch: "amd64", Digest: testImageID}
+	if err := writeBuildRecord(rec); err != nil {
+		t.Fatal(err)
+	}
+	// SBOMs of an earlier build, which a real run would rotate to previous/.
+	writeTrivyReportFile(t, "old.json", localTestTag, laterID)
+	writeTrivyReportFile(t, "new.json", localTestTag, testImageID)
+	if err := os.MkdirAll(sbomDir(envTest), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sbomDir(envTest), "source.json"), []byte(`{"Digest":"`+laterID+`"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshotTree(t)
+
+	t.Setenv("DRY_RUN", "1")
+	fake := newScanRunner(scanResponses(localTestTag, filepath.Join(buildDataDir, envTest))...)
+	defer useRunner(fake)()
+
+	var err error
+	out := captureStdout(t, func() {
+		steps := []func() error{
+			func() error { return (Build{}).Sbom(envTest) },
+			func() error { rec.SBOM = "sha256:planned"; return writeBuildRecord(rec) },
+			func() error { return (Trivy{}).Diff("old.json", "new.json") },
+			func() error { _, err := checkPolicy(localTestTag); return err },
+		}
+		for _, step := range steps {
+			if err = step(); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("dry run: %v\n%s", err, out)
+	}
+
+	after := snapshotTree(t)
+	for path, data := range after {
+		if prev, ok := before[path]; !ok {
+			t.Errorf("dry run wrote %s", path)
+		} else if prev != data {
+			t.Errorf("dry run changed %s", path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			t.Errorf("dry run removed %s", path)
+		}
+	}
+
+	for _, want := range []string{
+		"would write: " + buildRecordPath(envTest),
+		"would write: " + filepath.Join(sbomDir(envTest), "source.json"),
+		"would remove: " + filepath.Join(sbomDir(envTest), "previous"),
+		"would write: " + filepath.Join(vulnDiffDir, "old.json..new.json.md"),
+		"would write: " + podSpecPath(),
+		"would write: " + policyReportPath(),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plan lacks %q:\n%s", want, out)
+		}
+	}
+	// Kyverno still evaluates the rendered Pod; only the SBOM scans are skipped.
+	if !fake.Ran(fakeKyverno + " apply policies/kyverno --resource ") {
+		t.Errorf("dry run skipped the Kyverno check; ran %v", fake.Calls())
+	}
+	for _, call := range fake.Calls() {
+		if strings.HasPrefix(call, fakeTrivy+" image") {
+			t.Errorf("dry run ran %s", call)
+		}
+	}
+}
+
+func TestDryRunPrintsPlanWhenTargetFinishes(t *testing.T) {
+	newTestProject(t)
+	writeTrivyReportFile(t, "old.json", localTestTag, laterID)
+	writeTrivyReportFile(t, "new.json", localTestTag, testImageID)
+	t.Setenv("DRY_RUN", "1")
+
+	var err error
+	out := captureStdout(t, func() { err = (Trivy{}).Diff("old.json", "new.json") })
+	if err != nil {
+		t.Fatalf("Trivy:Diff: %v\n%s", err, out)
+	}
+	if n := strings.Count(out, "📝 Plan (1 steps, nothing was changed):"); n != 1 {
+		t.Fatalf("printed the plan %d times, want once:\n%s", n, out)
+	}
+	if !strings.Contains(out, "1. write  "+filepath.Join(vulnDiffDir, "old.json..new.json.md")) {
+		t.Errorf("plan lacks the report write:\n%s", out)
+	}
+}
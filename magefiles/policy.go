@@ -0,0 +1,350 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/mg"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy namespace evaluates the hardened image against the bundled Kyverno
+// Pod Security Standards (restricted) policies, entirely offline.
+type Policy mg.Namespace
+
+// errKyvernoNotFound selects the built-in checker when the kyverno CLI is absent.
+var errKyvernoNotFound = errors.New("kyverno CLI not found in .tools/bin or PATH")
+
+const (
+	kyvernoPolicyDir = "policies/kyverno"
+	policyOutputDir  = buildDataDir + "/policy"
+	// factorioUID is the uid/gid of the factorio user in the upstream image.
+	factorioUID = 845
+)
+
+// Kubernetes Pod subset rendered for policy evaluation. Security fields are
+// pointers so the built-in checker can tell "unset" from "false".
+type (
+	podSpecDoc struct {
+		APIVersion string      `yaml:"apiVersion"`
+		Kind       string      `yaml:"kind"`
+		Metadata   podMetadata `yaml:"metadata"`
+		Spec       podSpec     `yaml:"spec"`
+	}
+	podMetadata struct {
+		Name      string            `yaml:"name"`
+		Namespace string            `yaml:"namespace"`
+		Labels    map[string]string `yaml:"labels,omitempty"`
+	}
+	podSpec struct {
+		HostNetwork     bool               `yaml:"hostNetwork,omitempty"`
+		HostPID         bool               `yaml:"hostPID,omitempty"`
+		HostIPC         bool               `yaml:"hostIPC,omitempty"`
+		SecurityContext podSecurityContext `yaml:"securityContext"`
+		InitContainers  []podContainer     `yaml:"initContainers,omitempty"`
+		Containers      []podContainer     `yaml:"containers"`
+		Volumes         []podVolume        `yaml:"volumes,omitempty"`
+	}
+	podSecurityContext struct {
+		RunAsNonRoot   *bool           `yaml:"runAsNonRoot,omitempty"`
+		RunAsUser      *int64          `yaml:"runAsUser,omitempty"`
+		RunAsGroup     *int64          `yaml:"runAsGroup,omitempty"`
+		FSGroup        *int64          `yaml:"fsGroup,omitempty"`
+		SeccompProfile *seccompProfile `yaml:"seccompProfile,omitempty"`
+	}
+	seccompProfile struct {
+		Type string `yaml:"type"`
+	}
+	podContainer struct {
+		Name            string                   `yaml:"name"`
+		Image           string                   `yaml:"image"`
+		Ports           []containerPort          `yaml:"ports,omitempty"`
+		SecurityContext containerSecurityContext `yaml:"securityContext"`
+		VolumeMounts    []volumeMount            `yaml:"volumeMounts,omitempty"`
+	}
+	containerPort struct {
+		Name          string `yaml:"name"`
+		ContainerPort int    `yaml:"containerPort"`
+		HostPort      int    `yaml:"hostPort,omitempty"`
+		Protocol      string `yaml:"protocol"`
+	}
+	containerSecurityContext struct {
+		AllowPrivilegeEscalation *bool           `yaml:"allowPrivilegeEscalation,omitempty"`
+		Privileged               *bool           `yaml:"privileged,omitempty"`
+		ReadOnlyRootFilesystem   *bool           `yaml:"readOnlyRootFilesystem,omitempty"`
+		RunAsNonRoot             *bool           `yaml:"runAsNonRoot,omitempty"`
+		RunAsUser                *int64          `yaml:"runAsUser,omitempty"`
+		Capabilities             *capabilities   `yaml:"capabilities,omitempty"`
+		SeccompProfile           *seccompProfile `yaml:"seccompProfile,omitempty"`
+	}
+	capabilities struct {
+		Drop []string `yaml:"drop,omitempty"`
+		Add  []string `yaml:"add,omitempty"`
+	}
+	volumeMount struct {
+		Name      string `yaml:"name"`
+		MountPath string `yaml:"mountPath"`
+	}
+	podVolume struct {
+		Name                  string         `yaml:"name"`
+		EmptyDir              *struct{}      `yaml:"emptyDir,omitempty"`
+		PersistentVolumeClaim *pvcVolumeRef  `yaml:"persistentVolumeClaim,omitempty"`
+		Other                 map[string]any `yaml:",inline"` // any other volume source, e.g. hostPath
+	}
+	pvcVolumeRef struct {
+		ClaimName string `yaml:"claimName"`
+	}
+)
+
+// PolicyResult is the outcome of one policy rule for the rendered Pod.
+type PolicyResult struct {
+	Policy  string `json:"Policy" yaml:"policy"`
+	Rule    string `json:"Rule" yaml:"rule"`
+	Result  string `json:"Result" yaml:"result"` // pass, fail, warn, error or skip
+	Message string `json:"Message,omitempty" yaml:"message"`
+}
+
+// PolicyReport is the per-rule report written to builddata/policy/report.json.
+type PolicyReport struct {
+	Image     string         `json:"Image"`
+	Pod       string         `json:"Pod"`
+	Policies  string         `json:"Policies"`
+	Engine    string         `json:"Engine"`
+	Passed    bool           `json:"Passed"`
+	Results   []PolicyResult `json:"Results"`
+	CheckedAt time.Time      `json:"CheckedAt"`
+}
+
+// renderPodSpec returns the Pod manifest the image is expected to run under in
+// a restricted namespace: non-root, read-only root filesystem, no privilege
+// escalation, ALL capabilities dropped and the RuntimeDefault seccomp profile.
+func renderPodSpec(image string) podSpecDoc {
+	runtimeDefault := &seccompProfile{Type: "RuntimeDefault"}
+	uid := int64(factorioUID)
+	yes, no := true, false
+	return podSpecDoc{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Metadata: podMetadata{
+			Name:      "factorio-hardened",
+			Namespace: "factorio",
+			Labels:    map[string]string{"app.kubernetes.io/name": "factorio-hardened"},
+		},
+		Spec: podSpec{
+			SecurityContext: podSecurityContext{
+				RunAsNonRoot:   &yes,
+				RunAsUser:      &uid,
+				RunAsGroup:     &uid,
+				FSGroup:        &uid,
+				SeccompProfile: runtimeDefault,
+			},
+			Containers: []podContainer{{
+				Name:  "factorio",
+				Image: image,
+				Ports: []containerPort{{Name: "game", ContainerPort: 34197, Protocol: "UDP"}},
+				SecurityContext: containerSecurityContext{
+					AllowPrivilegeEscalation: &no,
+					Privileged:               &no,
+					ReadOnlyRootFilesystem:   &yes,
+					RunAsNonRoot:             &yes,
+					Capabilities:             &capabilities{Drop: []string{"ALL"}},
+					SeccompProfile:           runtimeDefault,
+				},
+				VolumeMounts: []volumeMount{
+					{Name: "factorio-data", MountPath: "/factorio"},
+					{Name: "tmp", MountPath: "/tmp"},
+				},
+			}},
+			Volumes: []podVolume{
+				{Name: "factorio-data", PersistentVolumeClaim: &pvcVolumeRef{ClaimName: "factorio-data"}},
+				{Name: "tmp", EmptyDir: &struct{}{}},
+			},
+		},
+	}
+}
+
+// podSpecPath returns where the rendered Pod spec is written.
+func podSpecPath() string {
+	return filepath.Join(policyOutputDir, "pod.yaml")
+}
+
+// writePodSpec renders the Pod for image to builddata/policy/pod.yaml and
+// returns the path to evaluate, which is a temporary copy in plan mode.
+func writePodSpec(image string) (string, func(), error) {
+	var buf bytes.Buffer
+	buf.WriteString("# Rendered by mage policy:check; evaluated offline against " + kyvernoPolicyDir + ".\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(renderPodSpec(image)); err != nil {
+		return "", nil, fmt.Errorf("failed to encode Pod spec: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", nil, fmt.Errorf("failed to encode Pod spec: %v", err)
+	}
+
+	path, cleanup, err := scratchFile(podSpecPath())
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write Pod spec: %v", err)
+	}
+	return path, cleanup, nil
+}
+
+// runKyvernoApply evaluates podPath against the bundled policies with
+// `kyverno apply` and returns the per-rule results from its policy report.
+func runKyvernoApply(podPath string) ([]PolicyResult, error) {
+	bin, err := toolBinary("kyverno")
+	if err != nil {
+		return nil, errKyvernoNotFound
+	}
+
+	var stdout, stderr bytes.Buffer
+	runErr := runCommand(Cmd{
+		Name:   bin,
+		Args:   []string{"apply", kyvernoPolicyDir, "--resource", podPath, "--policy-report"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+
+	results, err := parseKyvernoReport(stdout.Bytes())
+	if err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("kyverno apply failed: %v\n%s%s", runErr, stdout.String(), stderr.String())
+		}
+		return nil, err
+	}
+	// kyverno exits non-zero on violations; the report already captures those.
+	return results, nil
+}
+
+// parseKyvernoReport extracts results from the (Cluster)PolicyReport document
+// that `kyverno apply --policy-report` prints after its summary banner.
+func parseKyvernoReport(output []byte) ([]PolicyResult, error) {
+	start := bytes.Index(output, []byte("apiVersion:"))
+	if start < 0 {
+		return nil, fmt.Errorf("kyverno output contains no policy report")
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(output[start:]))
+	for {
+		var doc struct {
+			Kind    string         `yaml:"kind"`
+			Results []PolicyResult `yaml:"results"`
+		}
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("kyverno output contains no policy report")
+			}
+			return nil, fmt.Errorf("failed to parse kyverno policy report: %v", err)
+		}
+		if strings.HasSuffix(doc.Kind, "PolicyReport") {
+			return doc.Results, nil
+		}
+	}
+}
+
+// checkPolicy renders the Pod spec for image, evaluates it against the bundled
+// policies, prints a per-rule table and writes builddata/policy/report.json.
+// Without the kyverno CLI the built-in PSS restricted checker is used instead.
+// It returns an error if any rule fails.
+func checkPolicy(image string) (*PolicyReport, error) {
+	podPath, cleanup, err := writePodSpec(image)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	engine, policies := "kyverno", kyvernoPolicyDir
+	results, err := runKyvernoApply(podPath)
+	if errors.Is(err, errKyvernoNotFound) {
+		logInfo("ℹ️  kyverno CLI not found; using the built-in Pod Security Standards (restricted) checker.")
+		engine, policies = "builtin-pss", "pss-restricted"
+		results, err = checkPSSRestricted(image, podPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%s evaluated no rules", engine)
+	}
+
+	report := &PolicyReport{
+		Image:     image,
+		Pod:       podSpecPath(),
+		Policies:  policies,
+		Engine:    engine,
+		Passed:    true,
+		Results:   results,
+		CheckedAt: time.Now().UTC(),
+	}
+	for _, r := range results {
+		if r.Result == "fail" || r.Result == "error" {
+			report.Passed = false
+		}
+	}
+
+	printPolicyResults(results)
+	if err := writePolicyReport(report); err != nil {
+		return nil, err
+	}
+	if !report.Passed {
+		return report, fmt.Errorf("image %s violates restricted policies (%s; see %s)", image, engine, policyReportPath())
+	}
+	return report, nil
+}
+
+// policyReportPath returns where the last policy report is written.
+func policyReportPath() string {
+	return filepath.Join(policyOutputDir, "report.json")
+}
+
+// writePolicyReport saves report as indented JSON.
+func writePolicyReport(report *PolicyReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode policy report: %v", err)
+	}
+	if err := writeFile(policyReportPath(), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write policy report: %v", err)
+	}
+	return nil
+}
+
+// printPolicyResults prints one line per evaluated rule.
+func printPolicyResults(results []PolicyResult) {
+	fmt.Printf("  %-6s %-32s %-32s %s\n", "RESULT", "POLICY", "RULE", "MESSAGE")
+	for _, r := range results {
+		msg := ""
+		if r.Result != "pass" {
+			msg = strings.Join(strings.Fields(r.Message), " ")
+		}
+		fmt.Printf("  %-6s %-32s %-32s %s\n", strings.ToUpper(r.Result), r.Policy, r.Rule, msg)
+	}
+}
+
+// Check renders a restricted Pod spec for image and evaluates it offline
+// against the bundled Kyverno policies, failing on any violation.
+func (Policy) Check(image string) (err error) {
+	run := startTarget("policy:check")
+	defer func() { run.finish(err) }()
+
+	logInfo("🔒 Evaluating %s against %s...", image, kyvernoPolicyDir)
+	report, err := checkPolicy(image)
+	if err != nil {
+		return err
+	}
+	logInfo("✅ All %d policy rules passed. Report → %s", len(report.Results), policyReportPath())
+	return nil
+}
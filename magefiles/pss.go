@@ -0,0 +1,326 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pssAllowedVolumes are the volume sources permitted by the restricted profile.
+var pssAllowedVolumes = map[string]bool{
+	"configMap":             true,
+	"csi":                   true,
+	"downwardAPI":           true,
+	"emptyDir":              true,
+	"ephemeral":             true,
+	"persistentVolumeClaim": true,
+	"projected":             true,
+	"secret":                true,
+}
+
+// inspectImageConfig reads the config of a local image via docker inspect.
+func inspectImageConfig(image string) (*imageConfig, error) {
+	out, err := cmdOutput("docker", "image", "inspect", "--format", "{{json .Config}}", image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image config for %s: %v", image, err)
+	}
+	var cfg imageConfig
+	if err := json.Unmarshal(out, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse image config for %s: %v", image, err)
+	}
+	return &cfg, nil
+}
+
+// loadPodSpec reads a rendered Pod manifest.
+func loadPodSpec(path string) (*podSpecDoc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Pod spec: %v", err)
+	}
+	var pod podSpecDoc
+	if err := yaml.Unmarshal(data, &pod); err != nil {
+		return nil, fmt.Errorf("failed to parse Pod spec %s: %v", path, err)
+	}
+	return &pod, nil
+}
+
+// checkPSSRestricted evaluates the Pod at podPath and the config of image
+// against the Pod Security Standards restricted profile, reporting results
+// under the same policy and rule names as the bundled Kyverno policies.
+func checkPSSRestricted(image, podPath string) ([]PolicyResult, error) {
+	pod, err := loadPodSpec(podPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := inspectImageConfig(image)
+	if err != nil {
+		return nil, err
+	}
+	return evaluatePSSRestricted(pod, cfg), nil
+}
+
+// pssCheck is one restricted-profile rule; it returns the violations found.
+type pssCheck struct {
+	policy string
+	rule   string
+	check  func(pod *podSpecDoc, cfg *imageConfig) []string
+}
+
+// pssChecks lists the rules in the order they are reported.
+var pssChecks = []pssCheck{
+	{"disallow-host-namespaces", "host-namespaces", checkHostNamespaces},
+	{"disallow-privileged-containers", "privileged-containers", checkPrivileged},
+	{"disallow-host-ports", "host-ports-none", checkHostPorts},
+	{"disallow-privilege-escalation", "privilege-escalation", checkPrivilegeEscalation},
+	{"require-run-as-nonroot", "run-as-non-root", checkRunAsNonRoot},
+	{"require-run-as-non-root-user", "run-as-non-root-user", checkRunAsNonRootUser},
+	{"restrict-seccomp-strict", "check-seccomp-strict", checkSeccomp},
+	{"disallow-capabilities-strict", "require-drop-all", checkDropAll},
+	{"disallow-capabilities-strict", "adding-capabilities-strict", checkAddedCapabilities},
+	{"disallow-capabilities-strict", "privileged-ports", checkPrivilegedPorts},
+	{"restrict-volume-types", "restricted-volumes", checkVolumeTypes},
+	{"restrict-volume-types", "image-volumes-mounted", checkImageVolumes},
+	{"require-ro-rootfs", "validate-readOnlyRootFilesystem", checkReadOnlyRootFS},
+}
+
+// evaluatePSSRestricted runs every restricted check against pod and cfg.
+func evaluatePSSRestricted(pod *podSpecDoc, cfg *imageConfig) []PolicyResult {
+	results := make([]PolicyResult, 0, len(pssChecks))
+	for _, c := range pssChecks {
+		r := PolicyResult{Policy: c.policy, Rule: c.rule, Result: "pass"}
+		if problems := c.check(pod, cfg); len(problems) > 0 {
+			r.Result = "fail"
+			r.Message = strings.Join(problems, "; ")
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// allContainers returns init and regular containers, which all must comply.
+func allContainers(pod *podSpecDoc) []podContainer {
+	return append(append([]podContainer{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+}
+
+func checkHostNamespaces(pod *podSpecDoc, _ *imageConfig) []string {
+	var problems []string
+	if pod.Spec.HostNetwork {
+		problems = append(problems, "spec.hostNetwork must be unset or false")
+	}
+	if pod.Spec.HostPID {
+		problems = append(problems, "spec.hostPID must be unset or false")
+	}
+	if pod.Spec.HostIPC {
+		problems = append(problems, "spec.hostIPC must be unset or false")
+	}
+	return problems
+}
+
+func checkPrivileged(pod *podSpecDoc, _ *imageConfig) []string {
+	var problems []string
+	for _, c := range allContainers(pod) {
+		if p := c.SecurityContext.Privileged; p != nil && *p {
+			problems = append(problems, fmt.Sprintf("container %s: privileged must be unset or false", c.Name))
+		}
+	}
+	return problems
+}
+
+func checkHostPorts(pod *podSpecDoc, _ *imageConfig) []string {
+	var problems []string
+	for _, c := range allContainers(pod) {
+		for _, p := range c.Ports {
+			if p.HostPort != 0 {
+				problems = append(problems, fmt.Sprintf("container %s: hostPort %d is not allowed", c.Name, p.HostPort))
+			}
+		}
+	}
+	return problems
+}
+
+func checkPrivilegeEscalation(pod *podSpecDoc, _ *imageConfig) []string {
+	var problems []string
+	for _, c := range allContainers(pod) {
+		if p := c.SecurityContext.AllowPrivilegeEscalation; p == nil || *p {
+			problems = append(problems, fmt.Sprintf("container %s: allowPrivilegeEscalation must be false", c.Name))
+		}
+	}
+	return problems
+}
+
+func checkRunAsNonRoot(pod *podSpecDoc, _ *imageConfig) []string {
+	podLevel := pod.Spec.SecurityContext.RunAsNonRoot
+	var problems []string
+	for _, c := range allContainers(pod) {
+		v := c.SecurityContext.RunAsNonRoot
+		if v == nil {
+			v = podLevel
+		}
+		if v == nil || !*v {
+			problems = append(problems, fmt.Sprintf("container %s: runAsNonRoot must be true (pod or container level)", c.Name))
+		}
+	}
+	return problems
+}
+
+// checkRunAsNonRootUser rejects uid 0 in the Pod and, where the Pod does not
+// pin a uid, an image USER that is root or not numeric (the kubelet cannot
+// verify runAsNonRoot for a named user).
+func checkRunAsNonRootUser(pod *podSpecDoc, cfg *imageConfig) []string {
+	var problems []string
+	podUID := pod.Spec.SecurityContext.RunAsUser
+	if podUID != nil && *podUID == 0 {
+		problems = append(problems, "spec.securityContext.runAsUser must not be 0")
+	}
+	for _, c := range allContainers(pod) {
+		uid := c.SecurityContext.RunAsUser
+		if uid != nil && *uid == 0 {
+			problems = append(problems, fmt.Sprintf("container %s: runAsUser must not be 0", c.Name))
+		}
+		if uid == nil {
+			uid = podUID
+		}
+		if uid != nil {
+			continue
+		}
+		user := strings.SplitN(cfg.User, ":", 2)[0]
+		switch n, err := strconv.Atoi(user); {
+		case user == "" || user == "root":
+			problems = append(problems, fmt.Sprintf("container %s: image runs as root and no runAsUser is set", c.Name))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("container %s: image USER %q is not numeric; set runAsUser", c.Name, cfg.User))
+		case n == 0:
+			problems = append(problems, fmt.Sprintf("container %s: image USER is uid 0", c.Name))
+		}
+	}
+	return problems
+}
+
+// seccompAllowed reports whether a seccomp profile type is permitted.
+func seccompAllowed(p *seccompProfile) bool {
+	return p != nil && (p.Type == "RuntimeDefault" || p.Type == "Localhost")
+}
+
+func checkSeccomp(pod *podSpecDoc, _ *imageConfig) []string {
+	podProfile := pod.Spec.SecurityContext.SeccompProfile
+	if podProfile != nil && !seccompAllowed(podProfile) {
+		return []string{fmt.Sprintf("spec.securityContext.seccompProfile.type %q must be RuntimeDefault or Localhost", podProfile.Type)}
+	}
+	var problems []string
+	for _, c := range allContainers(pod) {
+		p := c.SecurityContext.SeccompProfile
+		if p == nil {
+			p = podProfile
+		}
+		if !seccompAllowed(p) {
+			problems = append(problems, fmt.Sprintf("container %s: seccompProfile.type must be RuntimeDefault or Localhost", c.Name))
+		}
+	}
+	return problems
+}
+
+func checkDropAll(pod *podSpecDoc, _ *imageConfig) []string {
+	var problems []string
+	for _, c := range allContainers(pod) {
+		dropped := false
+		if caps := c.SecurityContext.Capabilities; caps != nil {
+			for _, d := range caps.Drop {
+				if strings.EqualFold(d, "ALL") {
+					dropped = true
+				}
+			}
+		}
+		if !dropped {
+			problems = append(problems, fmt.Sprintf("container %s: capabilities.drop must include ALL", c.Name))
+		}
+	}
+	return problems
+}
+
+func checkAddedCapabilities(pod *podSpecDoc, _ *imageConfig) []string {
+	var problems []string
+	for _, c := range allContainers(pod) {
+		if caps := c.SecurityContext.Capabilities; caps != nil {
+			for _, a := range caps.Add {
+				if !strings.EqualFold(a, "NET_BIND_SERVICE") {
+					problems = append(problems, fmt.Sprintf("container %s: capability %s may not be added", c.Name, a))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// checkPrivilegedPorts flags image ports below 1024, which cannot be bound
+// once ALL capabilities are dropped.
+func checkPrivilegedPorts(_ *podSpecDoc, cfg *imageConfig) []string {
+	var problems []string
+	for _, p := range sortedSet(cfg.ExposedPorts) {
+		n, err := strconv.Atoi(strings.SplitN(p, "/", 2)[0])
+		if err == nil && n > 0 && n < 1024 {
+			problems = append(problems, fmt.Sprintf("image exposes privileged port %s", p))
+		}
+	}
+	return problems
+}
+
+func checkVolumeTypes(pod *podSpecDoc, _ *imageConfig) []string {
+	var problems []string
+	for _, v := range pod.Spec.Volumes {
+		for source := range v.Other {
+			if !pssAllowedVolumes[source] {
+				problems = append(problems, fmt.Sprintf("volume %s: %s volumes are not allowed", v.Name, source))
+			}
+		}
+	}
+	return problems
+}
+
+// checkImageVolumes requires every image VOLUME to be backed by a Pod mount;
+// otherwise it lands on the read-only root filesystem.
+func checkImageVolumes(pod *podSpecDoc, cfg *imageConfig) []string {
+	var problems []string
+	for _, vol := range sortedSet(cfg.Volumes) {
+		mounted := false
+		for _, c := range pod.Spec.Containers {
+			for _, m := range c.VolumeMounts {
+				v, mp := path.Clean(vol), path.Clean(m.MountPath)
+				if v == mp || strings.HasPrefix(v, strings.TrimSuffix(mp, "/")+"/") {
+					mounted = true
+				}
+			}
+		}
+		if !mounted {
+			problems = append(problems, fmt.Sprintf("image VOLUME %s has no matching volumeMount", vol))
+		}
+	}
+	return problems
+}
+
+func checkReadOnlyRootFS(pod *podSpecDoc, _ *imageConfig) []string {
+	var problems []string
+	for _, c := range pod.Spec.Containers {
+		if ro := c.SecurityContext.ReadOnlyRootFilesystem; ro == nil || !*ro {
+			problems = append(problems, fmt.Sprintf("container %s: readOnlyRootFilesystem must be true", c.Name))
+		}
+	}
+	return problems
+}
+
+// sortedSet returns the keys of a JSON object set in ascending order.
+func sortedSet(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,83 @@
+//go:build mage
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvaluatePSSRestricted(t *testing.T) {
+	yes, no := true, false
+	root := int64(0)
+	container := func(pod *podSpecDoc) *containerSecurityContext { return &pod.Spec.Containers[0].SecurityContext }
+	tests := []struct {
+		name   string
+		modify func(pod *podSpecDoc, cfg *imageConfig)
+		rule   string // the only rule expected to fail; "" when all pass
+		msg    string
+	}{
+		{"rendered pod", func(*podSpecDoc, *imageConfig) {}, "", ""},
+		{"privileged container", func(pod *podSpecDoc, _ *imageConfig) { container(pod).Privileged = &yes },
+			"privileged-containers", "container factorio: privileged must be unset or false"},
+		{"runAsNonRoot false", func(pod *podSpecDoc, _ *imageConfig) {
+			pod.Spec.SecurityContext.RunAsNonRoot = nil
+			container(pod).RunAsNonRoot = &no
+		}, "run-as-non-root", "container factorio: runAsNonRoot must be true"},
+		{"runAsNonRoot unset", func(pod *podSpecDoc, _ *imageConfig) {
+			pod.Spec.SecurityContext.RunAsNonRoot = nil
+			container(pod).RunAsNonRoot = nil
+		}, "run-as-non-root", "container factorio: runAsNonRoot must be true"},
+		{"runAsUser 0", func(pod *podSpecDoc, _ *imageConfig) { container(pod).RunAsUser = &root },
+			"run-as-non-root-user", "container factorio: runAsUser must not be 0"},
+		{"root image without runAsUser", func(pod *podSpecDoc, cfg *imageConfig) {
+			pod.Spec.SecurityContext.RunAsUser = nil
+			cfg.User = "root"
+		}, "run-as-non-root-user", "image runs as root and no runAsUser is set"},
+		{"capabilities not dropped", func(pod *podSpecDoc, _ *imageConfig) { container(pod).Capabilities = nil },
+			"require-drop-all", "container factorio: capabilities.drop must include ALL"},
+		{"drop list without ALL", func(pod *podSpecDoc, _ *imageConfig) {
+			container(pod).Capabilities = &capabilities{Drop: []string{"NET_RAW"}}
+		}, "require-drop-all", "capabilities.drop must include ALL"},
+		{"capability added", func(pod *podSpecDoc, _ *imageConfig) {
+			container(pod).Capabilities = &capabilities{Drop: []string{"all"}, Add: []string{"SYS_ADMIN"}}
+		}, "adding-capabilities-strict", "capability SYS_ADMIN may not be added"},
+		{"allowPrivilegeEscalation true", func(pod *podSpecDoc, _ *imageConfig) { container(pod).AllowPrivilegeEscalation = &yes },
+			"privilege-escalation", "container factorio: allowPrivilegeEscalation must be false"},
+		{"allowPrivilegeEscalation unset", func(pod *podSpecDoc, _ *imageConfig) { container(pod).AllowPrivilegeEscalation = nil },
+			"privilege-escalation", "allowPrivilegeEscalation must be false"},
+		{"seccomp unconfined", func(pod *podSpecDoc, _ *imageConfig) {
+			container(pod).SeccompProfile = &seccompProfile{Type: "Unconfined"}
+		}, "check-seccomp-strict", "container factorio: seccompProfile.type must be RuntimeDefault or Localhost"},
+		{"seccomp unset", func(pod *podSpecDoc, _ *imageConfig) {
+			pod.Spec.SecurityContext.SeccompProfile = nil
+			container(pod).SeccompProfile = nil
+		}, "check-seccomp-strict", "seccompProfile.type must be RuntimeDefault or Localhost"},
+		{"pod seccomp unconfined", func(pod *podSpecDoc, _ *imageConfig) {
+			pod.Spec.SecurityContext.SeccompProfile = &seccompProfile{Type: "Unconfined"}
+		}, "check-seccomp-strict", `spec.securityContext.seccompProfile.type "Unconfined"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := renderPodSpec(localTestTag)
+			cfg := &imageConfig{User: "845:845", ExposedPorts: map[string]struct{}{"34197/udp": {}}, Volumes: map[string]struct{}{"/factorio": {}}}
+			tt.modify(&pod, cfg)
+
+			results := evaluatePSSRestricted(&pod, cfg)
+			if len(results) != len(pssChecks) {
+				t.Fatalf("got %d results, want one per check (%d)", len(results), len(pssChecks))
+			}
+			for _, r := range results {
+				if r.Rule != tt.rule {
+					if r.Result != "pass" {
+						t.Errorf("%s/%s = %s (%s), want pass", r.Policy, r.Rule, r.Result, r.Message)
+					}
+					continue
+				}
+				if r.Result != "fail" || !strings.Contains(r.Message, tt.msg) {
+					t.Errorf("%s/%s = %s (%s), want fail with %q", r.Policy, r.Rule, r.Result, r.Message, tt.msg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,752 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Manifest media types understood by the registry client.
+const (
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// errManifestNotFound is wrapped by manifest lookups that got HTTP 404.
+var errManifestNotFound = errors.New("not found (HTTP 404)")
+
+// manifestAccept lists every manifest type we are willing to receive, most preferred first.
+var manifestAccept = []string{
+	mediaTypeOCIIndex,
+	mediaTypeDockerManifestList,
+	mediaTypeOCIManifest,
+	mediaTypeDockerManifest,
+}
+
+// ImageRef is a parsed image reference split into registry host, repository and tag or digest.
+type ImageRef struct {
+	Registry   string
+	Repository string
+	Reference  string // tag or sha256 digest
+}
+
+// String returns the canonical name:tag or name@digest form of the reference.
+func (r ImageRef) String() string {
+	sep := ":"
+	if strings.HasPrefix(r.Reference, "sha256:") {
+		sep = "@"
+	}
+	return fmt.Sprintf("%s/%s%s%s", r.Registry, r.Repository, sep, r.Reference)
+}
+
+// parseImageRef splits an image reference such as "factoriotools/factorio:2.0.69"
+// into its registry, repository and reference. Docker Hub names are normalized
+// to registry-1.docker.io and single-segment names gain the "library/" prefix.
+func parseImageRef(image string) (ImageRef, error) {
+	image = strings.TrimSpace(image)
+	if image == "" {
+		return ImageRef{}, fmt.Errorf("empty image reference")
+	}
+
+	ref := ImageRef{Registry: "registry-1.docker.io", Reference: "latest"}
+
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Reference = name[i+1:]
+		name = name[:i]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Reference = name[i+1:]
+		name = name[:i]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry = parts[0]
+		name = parts[1]
+	}
+	if ref.Registry == "docker.io" || ref.Registry == "index.docker.io" {
+		ref.Registry = "registry-1.docker.io"
+	}
+	if ref.Registry == "registry-1.docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || ref.Reference == "" {
+		return ImageRef{}, fmt.Errorf("invalid image reference: %s", image)
+	}
+
+	ref.Repository = name
+	return ref, nil
+}
+
+// ImagePlatform identifies the platform a manifest in an index was built for.
+type ImagePlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// IndexManifest is a single entry of a Docker manifest list or OCI image index.
+type IndexManifest struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ImagePlatform    `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ImageIndex is a Docker manifest list or OCI image index.
+type ImageIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []IndexManifest `json:"manifests"`
+}
+
+// isIndexMediaType reports whether the media type describes a multi-platform index.
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == mediaTypeDockerManifestList || mediaType == mediaTypeOCIIndex
+}
+
+// ArchDigests returns the per-architecture manifest digests for linux platforms,
+// skipping attestation manifests that carry an "unknown" platform.
+func (idx ImageIndex) ArchDigests() map[string]string {
+	digests := make(map[string]string)
+	for _, m := range idx.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		if !strings.EqualFold(m.Platform.OS, "linux") {
+			continue
+		}
+		arch := strings.ToLower(strings.TrimSpace(m.Platform.Architecture))
+		if arch == "" || arch == "unknown" {
+			continue
+		}
+		if _, seen := digests[arch]; !seen {
+			digests[arch] = m.Digest
+		}
+	}
+	return digests
+}
+
+// IsAttestation reports whether the entry is a BuildKit attestation manifest
+// (SBOM/provenance) rather than a runnable platform image.
+func (m IndexManifest) IsAttestation() bool {
+	return m.Annotations["vnd.docker.reference.type"] == "attestation-manifest"
+}
+
+// PlatformString formats the entry's platform as os/arch[/variant].
+func (m IndexManifest) PlatformString() string {
+	if m.Platform == nil {
+		return ""
+	}
+	s := m.Platform.OS + "/" + m.Platform.Architecture
+	if m.Platform.Variant != "" {
+		s += "/" + m.Platform.Variant
+	}
+	return s
+}
+
+// registryClient is a minimal Docker Registry HTTP API v2 client. It supports
+// anonymous and basic-credential bearer token auth, manifest fetches by tag or
+// digest, and HEAD requests for digest resolution without downloading bodies.
+type registryClient struct {
+	baseURL  string
+	http     *http.Client
+	blobHTTP *http.Client // "transfer" timeout for streamed layer downloads
+	username string
+	password string
+
+	mu     sync.Mutex
+	tokens map[string]string // key = scope
+}
+
+// newRegistryClient returns a client for the given registry host. Hosts are
+// contacted over HTTPS, except loopback registries which are plain HTTP like
+// a local `registry:2` container or an httptest server.
+func newRegistryClient(registry string) *registryClient {
+	host := registry
+	if h, _, found := strings.Cut(registry, ":"); found {
+		host = h
+	}
+	if host == "localhost" || host == "127.0.0.1" {
+		return newRegistryClientForURL("http://" + registry)
+	}
+	return newRegistryClientForURL("https://" + registry)
+}
+
+// newRegistryClientForURL returns a client rooted at an explicit base URL,
+// e.g. an httptest server standing in for a real registry.
+func newRegistryClientForURL(baseURL string) *registryClient {
+	return &registryClient{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		http:     httpClient("api"),
+		blobHTTP: httpClient("transfer"),
+		tokens:   make(map[string]string),
+	}
+}
+
+// withCredentials sets basic credentials used when requesting bearer tokens.
+func (c *registryClient) withCredentials(username, password string) *registryClient {
+	c.username = username
+	c.password = password
+	return c
+}
+
+// manifestResult holds a fetched manifest along with its resolved digest.
+type manifestResult struct {
+	MediaType string
+	Digest    string
+	Body      []byte
+}
+
+// GetManifest fetches the manifest for repo at reference (tag or digest).
+func (c *registryClient) GetManifest(repo, reference string) (*manifestResult, error) {
+	resp, err := c.manifestRequest(http.MethodGet, repo, reference)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest body: %w", err)
+	}
+
+	computed := digestOf(body)
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = computed
+	} else if digest != computed {
+		return nil, fmt.Errorf("manifest digest mismatch for %s:%s (registry %s, computed %s)", repo, reference, digest, computed)
+	}
+	if strings.HasPrefix(reference, "sha256:") && reference != computed {
+		return nil, fmt.Errorf("manifest content does not match requested digest %s (computed %s)", reference, computed)
+	}
+
+	mediaType := resp.Header.Get("Content-Type")
+	var probe struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(body, &probe) == nil && probe.MediaType != "" {
+		mediaType = probe.MediaType
+	}
+
+	return &manifestResult{MediaType: mediaType, Digest: digest, Body: body}, nil
+}
+
+// HeadManifest resolves the digest of repo at reference without downloading the manifest.
+func (c *registryClient) HeadManifest(repo, reference string) (string, error) {
+	resp, err := c.manifestRequest(http.MethodHead, repo, reference)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest != "" {
+		return digest, nil
+	}
+
+	// Some registries omit the header on HEAD; fall back to a full fetch.
+	m, err := c.GetManifest(repo, reference)
+	if err != nil {
+		return "", err
+	}
+	return m.Digest, nil
+}
+
+// GetIndex fetches a manifest and parses it as a manifest list or OCI index.
+func (c *registryClient) GetIndex(repo, reference string) (*ImageIndex, string, error) {
+	m, err := c.GetManifest(repo, reference)
+	if err != nil {
+		return nil, "", err
+	}
+	if !isIndexMediaType(m.MediaType) {
+		return nil, "", fmt.Errorf("%s:%s is not a multi-arch index (media type %q)", repo, reference, m.MediaType)
+	}
+
+	var idx ImageIndex
+	if err := json.Unmarshal(m.Body, &idx); err != nil {
+		return nil, "", fmt.Errorf("failed to parse image index: %w", err)
+	}
+	return &idx, m.Digest, nil
+}
+
+// ListTags returns every tag of repo, following the registry's Link pagination.
+func (c *registryClient) ListTags(repo string) ([]string, error) {
+	scope := fmt.Sprintf("repository:%s:pull", repo)
+	endpoint := fmt.Sprintf("%s/v2/%s/tags/list?n=1000", c.baseURL, repo)
+
+	var tags []string
+	for endpoint != "" {
+		resp, err := c.do(http.MethodGet, endpoint, scope, nil, nil, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to list tags for %s (status: %s)", repo, resp.Status)
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tag list for %s: %w", repo, err)
+		}
+		tags = append(tags, page.Tags...)
+
+		endpoint, err = nextPageURL(endpoint, resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// nextPageURL resolves the rel="next" target of a Link header against the current URL.
+func nextPageURL(current, link string) (string, error) {
+	if link == "" {
+		return "", nil
+	}
+	start := strings.Index(link, "<")
+	end := strings.Index(link, ">")
+	if start < 0 || end < start || !strings.Contains(link[end:], `rel="next"`) {
+		return "", nil
+	}
+
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", fmt.Errorf("invalid registry URL %q: %w", current, err)
+	}
+	next, err := base.Parse(link[start+1 : end])
+	if err != nil {
+		return "", fmt.Errorf("invalid pagination link %q: %w", link, err)
+	}
+	return next.String(), nil
+}
+
+// digestOf returns the "sha256:<hex>" content digest of data.
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// GetBlob downloads a blob and verifies it matches digest.
+func (c *registryClient) GetBlob(repo, digest string) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s/v2/%s/blobs/%s", c.baseURL, repo, digest)
+	resp, err := c.do(http.MethodGet, endpoint, fmt.Sprintf("repository:%s:pull", repo), nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch blob %s (status: %s)", digest, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	if got := digestOf(data); got != digest {
+		return nil, fmt.Errorf("blob content does not match digest %s (computed %s)", digest, got)
+	}
+	return data, nil
+}
+
+// OpenBlob streams a blob; the digest is verified when the reader reaches EOF,
+// so callers must read to the end before trusting the content.
+func (c *registryClient) OpenBlob(repo, digest string) (io.ReadCloser, error) {
+	endpoint := fmt.Sprintf("%s/v2/%s/blobs/%s", c.baseURL, repo, digest)
+	resp, err := c.doWith(c.blobHTTP, http.MethodGet, endpoint, fmt.Sprintf("repository:%s:pull", repo), nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch blob %s (status: %s)", digest, resp.Status)
+	}
+	return &verifyingReader{r: resp.Body, h: sha256.New(), digest: digest}, nil
+}
+
+// verifyingReader hashes everything read and fails at EOF on a digest mismatch.
+type verifyingReader struct {
+	r      io.ReadCloser
+	h      hash.Hash
+	digest string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if got := "sha256:" + hex.EncodeToString(v.h.Sum(nil)); got != v.digest {
+			return n, fmt.Errorf("blob content does not match digest %s (computed %s)", v.digest, got)
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.r.Close()
+}
+
+// PushBlob uploads data to repo in a single monolithic upload unless the
+// registry already has it, and returns its digest.
+func (c *registryClient) PushBlob(repo string, data []byte) (string, error) {
+	digest := digestOf(data)
+	scope := fmt.Sprintf("repository:%s:pull,push", repo)
+
+	head, err := c.do(http.MethodHead, fmt.Sprintf("%s/v2/%s/blobs/%s", c.baseURL, repo, digest), scope, nil, nil, "")
+	if err != nil {
+		return "", err
+	}
+	head.Body.Close()
+	if head.StatusCode == http.StatusOK {
+		return digest, nil
+	}
+
+	start, err := c.do(http.MethodPost, fmt.Sprintf("%s/v2/%s/blobs/uploads/", c.baseURL, repo), scope, nil, nil, "")
+	if err != nil {
+		return "", err
+	}
+	start.Body.Close()
+	if start.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("registry refused blob upload to %s (status: %s)", repo, start.Status)
+	}
+
+	location, err := url.Parse(start.Header.Get("Location"))
+	if err != nil || start.Header.Get("Location") == "" {
+		return "", fmt.Errorf("registry returned no upload location for %s", repo)
+	}
+	base, err := url.Parse(c.baseURL + "/")
+	if err != nil {
+		return "", fmt.Errorf("invalid registry URL %q: %w", c.baseURL, err)
+	}
+	upload := base.ResolveReference(location)
+	q := upload.Query()
+	q.Set("digest", digest)
+	upload.RawQuery = q.Encode()
+
+	put, err := c.do(http.MethodPut, upload.String(), scope, nil, data, "application/octet-stream")
+	if err != nil {
+		return "", err
+	}
+	put.Body.Close()
+	if put.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("blob upload to %s failed (status: %s)", repo, put.Status)
+	}
+	return digest, nil
+}
+
+// PutManifest uploads a manifest under reference (tag or digest) and returns its digest.
+func (c *registryClient) PutManifest(repo, reference, mediaType string, body []byte) (string, error) {
+	endpoint := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, reference)
+	resp, err := c.do(http.MethodPut, endpoint, fmt.Sprintf("repository:%s:pull,push", repo), nil, body, mediaType)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to push manifest %s:%s (status: %s)", repo, reference, resp.Status)
+	}
+	return digestOf(body), nil
+}
+
+// manifestRequest issues a manifest request, transparently handling a bearer token challenge.
+func (c *registryClient) manifestRequest(method, repo, reference string) (*http.Response, error) {
+	endpoint := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repo, reference)
+	scope := fmt.Sprintf("repository:%s:pull", repo)
+
+	resp, err := c.do(method, endpoint, scope, manifestAccept, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("manifest %s:%s %w", repo, reference, errManifestNotFound)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		resp.Body.Close()
+		return nil, fmt.Errorf("registry denied access to %s:%s (status: %s)", repo, reference, resp.Status)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected registry response for %s:%s (status: %s)", repo, reference, resp.Status)
+	}
+}
+
+// do performs a request with the cached token for scope, retrying once after a 401 challenge.
+// body and contentType are optional and only used for uploads.
+func (c *registryClient) do(method, endpoint, scope string, accept []string, body []byte, contentType string) (*http.Response, error) {
+	return c.doWith(c.http, method, endpoint, scope, accept, body, contentType)
+}
+
+// doWith is do using a specific HTTP client.
+func (c *registryClient) doWith(client *http.Client, method, endpoint, scope string, accept []string, body []byte, contentType string) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, endpoint, reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create registry request: %w", err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		c.mu.Lock()
+		token := c.tokens[scope]
+		c.mu.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+
+		resp, err := httpDo(client, req)
+		if err != nil {
+			return nil, fmt.Errorf("registry request to %s failed: %w", endpoint, err)
+		}
+		return resp, nil
+	}
+
+	resp, err := send()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return nil, fmt.Errorf("registry requires unsupported authentication: %q", challenge)
+	}
+
+	token, err := c.fetchToken(challenge, scope)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tokens[scope] = token
+	c.mu.Unlock()
+
+	return send()
+}
+
+// fetchToken exchanges a bearer challenge for a registry token.
+func (c *registryClient) fetchToken(challenge, scope string) (string, error) {
+	params := parseAuthChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("bearer challenge missing realm: %q", challenge)
+	}
+
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid token realm %q: %w", realm, err)
+	}
+	q := u.Query()
+	if svc := params["service"]; svc != "" {
+		q.Set("service", svc)
+	}
+	if s := params["scope"]; s != "" {
+		q.Set("scope", s)
+	} else {
+		q.Set("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := httpDo(c.http, req)
+	if err != nil {
+		return "", fmt.Errorf("token request to %s failed: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint rejected request (status: %s)", resp.Status)
+	}
+
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tok.Token != "" {
+		return tok.Token, nil
+	}
+	if tok.AccessToken != "" {
+		return tok.AccessToken, nil
+	}
+	return "", fmt.Errorf("token endpoint returned no token")
+}
+
+// parseAuthChallenge parses the key="value" pairs of a WWW-Authenticate Bearer header.
+func parseAuthChallenge(header string) map[string]string {
+	params := make(map[string]string)
+	if i := strings.Index(header, " "); i >= 0 {
+		header = header[i+1:]
+	}
+
+	for header != "" {
+		header = strings.TrimLeft(header, " ,")
+		eq := strings.Index(header, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(header[:eq]))
+		header = header[eq+1:]
+
+		var value string
+		if strings.HasPrefix(header, `"`) {
+			end := strings.Index(header[1:], `"`)
+			if end < 0 {
+				value, header = header[1:], ""
+			} else {
+				value, header = header[1:end+1], header[end+2:]
+			}
+		} else {
+			end := strings.Index(header, ",")
+			if end < 0 {
+				value, header = header, ""
+			} else {
+				value, header = header[:end], header[end:]
+			}
+		}
+		params[key] = value
+	}
+	return params
+}
+
+// registryCredentials returns basic credentials for registry from GHCR_TOKEN
+// (for ghcr.io) or the "auths" section of ~/.docker/config.json. Empty strings
+// mean anonymous access.
+func registryCredentials(registry string) (string, string) {
+	if registry == "ghcr.io" {
+		if token := os.Getenv("GHCR_TOKEN"); token != "" {
+			user := os.Getenv("GHCR_USER")
+			if user == "" {
+				user = "token"
+			}
+			return user, token
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", ""
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".docker", "config.json"))
+	if err != nil {
+		return "", ""
+	}
+
+	var cfg struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", ""
+	}
+	entry, ok := cfg.Auths[registry]
+	if !ok || entry.Auth == "" {
+		return "", ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return "", ""
+	}
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", ""
+	}
+	return user, pass
+}
+
+// newAuthenticatedRegistryClient returns a client for ref's registry that
+// presents locally configured credentials when requesting tokens.
+func newAuthenticatedRegistryClient(ref ImageRef) *registryClient {
+	client := newRegistryClient(ref.Registry)
+	if user, pass := registryCredentials(ref.Registry); user != "" {
+		client.withCredentials(user, pass)
+	}
+	return client
+}
+
+// fetchUpstreamIndex resolves an image reference to its manifest list digest and
+// per-architecture digests straight from the registry, without a Docker daemon.
+func fetchUpstreamIndex(image string) (string, map[string]string, error) {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		return "", nil, err
+	}
+
+	client := newRegistryClient(ref.Registry)
+	idx, digest, err := client.GetIndex(ref.Repository, ref.Reference)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch manifest list for %s: %w", image, err)
+	}
+	return digest, idx.ArchDigests(), nil
+}
+
+// listUpstreamTags returns all tags published for the repository of image.
+func listUpstreamTags(image string) ([]string, error) {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := newRegistryClient(ref.Registry).ListTags(ref.Repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags for %s: %w", image, err)
+	}
+	return tags, nil
+}
+
+// headUpstreamDigest resolves the digest that image currently points to.
+func headUpstreamDigest(image string) (string, error) {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		return "", err
+	}
+	return newRegistryClient(ref.Registry).HeadManifest(ref.Repository, ref.Reference)
+}
@@ -0,0 +1,328 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegistry is an in-memory Registry HTTP API v2 server. With token set,
+// every /v2/ request needs "Bearer <token>" and gets a challenge pointing at
+// /token otherwise.
+type fakeRegistry struct {
+	t     *testing.T
+	srv   *httptest.Server
+	token string
+
+	mu         sync.Mutex
+	manifests  map[string]fakeManifest // "repo@ref" by tag and by digest
+	blobs      map[string][]byte       // by digest
+	tokenCalls int
+	requests   []string // "METHOD path"
+	badDigest  bool     // send a wrong Docker-Content-Digest header
+}
+
+type fakeManifest struct {
+	mediaType string
+	body      []byte
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	t.Helper()
+	r := &fakeRegistry{t: t, manifests: map[string]fakeManifest{}, blobs: map[string][]byte{}}
+	r.srv = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+// host returns the registry's host:port, usable as an image reference prefix.
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.srv.URL, "http://")
+}
+
+// client returns a registry client for the server.
+func (r *fakeRegistry) client() *registryClient {
+	return newRegistryClientForURL(r.srv.URL)
+}
+
+// putManifest stores body under tag (if set) and its digest, returning the digest.
+func (r *fakeRegistry) putManifest(repo, tag, mediaType string, body []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := digestOf(body)
+	m := fakeManifest{mediaType: mediaType, body: body}
+	r.manifests[repo+"@"+digest] = m
+	if tag != "" {
+		r.manifests[repo+"@"+tag] = m
+	}
+	return digest
+}
+
+// putJSONManifest marshals v and stores it like putManifest.
+func (r *fakeRegistry) putJSONManifest(repo, tag, mediaType string, v any) string {
+	r.t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return r.putManifest(repo, tag, mediaType, body)
+}
+
+func (r *fakeRegistry) manifest(repo, ref string) (fakeManifest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.manifests[repo+"@"+ref]
+	return m, ok
+}
+
+func (r *fakeRegistry) sawRequest(prefix string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, req := range r.requests {
+		if strings.HasPrefix(req, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	r.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		r.mu.Lock()
+		r.tokenCalls++
+		r.mu.Unlock()
+		fmt.Fprintf(w, `{"token":%q}`, r.token)
+		return
+	}
+	if r.token != "" && req.Header.Get("Authorization") != "Bearer "+r.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, r.srv.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/manifests/"):
+		repo, ref, _ := strings.Cut(path, "/manifests/")
+		r.serveManifest(w, req, repo, ref)
+	case strings.HasSuffix(path, "/blobs/uploads/"):
+		w.Header().Set("Location", "/v2/"+strings.TrimSuffix(path, "blobs/uploads/")+"blobs/uploads/1")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(path, "/blobs/uploads/"):
+		data, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.blobs[req.URL.Query().Get("digest")] = data
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		_, digest, _ := strings.Cut(path, "/blobs/")
+		r.mu.Lock()
+		data, ok := r.blobs[digest]
+		r.mu.Unlock()
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		if req.Method != http.MethodHead {
+			w.Write(data)
+		}
+	case strings.HasSuffix(path, "/tags/list"):
+		repo := strings.TrimSuffix(path, "/tags/list")
+		var tags []string
+		r.mu.Lock()
+		for key := range r.manifests {
+			if name, ref, _ := strings.Cut(key, "@"); name == repo && !strings.HasPrefix(ref, "sha256:") {
+				tags = append(tags, ref)
+			}
+		}
+		r.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"name": repo, "tags": tags})
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	if req.Method == http.MethodPut {
+		body, _ := io.ReadAll(req.Body)
+		tag := ref
+		if strings.HasPrefix(ref, "sha256:") {
+			tag = ""
+		}
+		r.putManifest(repo, tag, req.Header.Get("Content-Type"), body)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	m, ok := r.manifest(repo, ref)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	digest := digestOf(m.body)
+	if r.badDigest {
+		digest = digestOf([]byte("something else"))
+	}
+	w.Header().Set("Content-Type", m.mediaType)
+	w.Header().Set("Docker-Content-Digest", digest)
+	if req.Method != http.MethodHead {
+		w.Write(m.body)
+	}
+}
+
+// testIndex returns a two-platform OCI index plus one attestation entry.
+func testIndex() ImageIndex {
+	return ImageIndex{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests: []IndexManifest{
+			{MediaType: mediaTypeOCIManifest, Digest: "sha256:" + strings.Repeat("a", 64), Platform: &ImagePlatform{OS: "linux", Architecture: "amd64"}},
+			{MediaType: mediaTypeOCIManifest, Digest: "sha256:" + strings.Repeat("b", 64), Platform: &ImagePlatform{OS: "linux", Architecture: "arm64"}},
+			{MediaType: mediaTypeOCIManifest, Digest: "sha256:" + strings.Repeat("c", 64), Platform: &ImagePlatform{OS: "unknown", Architecture: "unknown"},
+				Annotations: map[string]string{"vnd.docker.reference.type": "attestation-manifest"}},
+		},
+	}
+}
+
+func TestRegistryBearerTokenRetry(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.token = "s3cret"
+	want := reg.putJSONManifest("team/app", "1.0", mediaTypeOCIIndex, testIndex())
+
+	c := reg.client()
+	for i := 0; i < 2; i++ {
+		got, err := c.HeadManifest("team/app", "1.0")
+		if err != nil {
+			t.Fatalf("HeadManifest #%d: %v", i+1, err)
+		}
+		if got != want {
+			t.Errorf("HeadManifest #%d = %s, want %s", i+1, got, want)
+		}
+	}
+	if reg.tokenCalls != 1 {
+		t.Errorf("token endpoint called %d times, want 1 (token cached per scope)", reg.tokenCalls)
+	}
+}
+
+func TestRegistryTokenRejected(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.token = "s3cret"
+	reg.putJSONManifest("team/app", "1.0", mediaTypeOCIIndex, testIndex())
+
+	c := reg.client()
+	c.tokens["repository:team/app:pull"] = "stale"
+	// A stale token is replaced after the challenge.
+	if _, err := c.HeadManifest("team/app", "1.0"); err != nil {
+		t.Fatalf("HeadManifest with stale token: %v", err)
+	}
+	if c.tokens["repository:team/app:pull"] != "s3cret" {
+		t.Errorf("token not refreshed: %q", c.tokens["repository:team/app:pull"])
+	}
+}
+
+func TestRegistryGetIndex(t *testing.T) {
+	for _, mediaType := range []string{mediaTypeOCIIndex, mediaTypeDockerManifestList} {
+		t.Run(mediaType, func(t *testing.T) {
+			reg := newFakeRegistry(t)
+			idx := testIndex()
+			idx.MediaType = mediaType
+			want := reg.putJSONManifest("team/app", "1.0", mediaType, idx)
+
+			got, digest, err := reg.client().GetIndex("team/app", "1.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if digest != want {
+				t.Errorf("digest = %s, want %s", digest, want)
+			}
+			arch := got.ArchDigests()
+			if len(arch) != 2 || arch["amd64"] != idx.Manifests[0].Digest || arch["arm64"] != idx.Manifests[1].Digest {
+				t.Errorf("ArchDigests = %v", arch)
+			}
+			if !got.Manifests[2].IsAttestation() {
+				t.Error("attestation entry not recognised")
+			}
+		})
+	}
+}
+
+func TestRegistrySingleManifest(t *testing.T) {
+	reg := newFakeRegistry(t)
+	body := []byte(`{"schemaVersion":2,"mediaType":"` + mediaTypeDockerManifest + `","layers":[]}`)
+	want := reg.putManifest("team/app", "1.0", mediaTypeDockerManifest, body)
+	c := reg.client()
+
+	m, err := c.GetManifest("team/app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.MediaType != mediaTypeDockerManifest || m.Digest != want {
+		t.Errorf("GetManifest = %s %s, want %s %s", m.MediaType, m.Digest, mediaTypeDockerManifest, want)
+	}
+	if _, _, err := c.GetIndex("team/app", "1.0"); err == nil || !strings.Contains(err.Error(), "not a multi-arch index") {
+		t.Errorf("GetIndex on a single manifest: err = %v", err)
+	}
+
+	// HEAD by digest resolves to the same digest.
+	got, err := c.HeadManifest("team/app", want)
+	if err != nil || got != want {
+		t.Errorf("HeadManifest by digest = %s, %v; want %s", got, err, want)
+	}
+	if !reg.sawRequest("HEAD /v2/team/app/manifests/" + want) {
+		t.Error("HeadManifest did not issue a HEAD request")
+	}
+}
+
+func TestRegistryDigestMismatch(t *testing.T) {
+	reg := newFakeRegistry(t)
+	digest := reg.putJSONManifest("team/app", "1.0", mediaTypeOCIIndex, testIndex())
+	c := reg.client()
+
+	reg.badDigest = true
+	if _, err := c.GetManifest("team/app", "1.0"); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Errorf("wrong Docker-Content-Digest: err = %v", err)
+	}
+	reg.badDigest = false
+
+	// Content served under a digest it does not hash to.
+	other := digestOf([]byte("other"))
+	m, _ := reg.manifest("team/app", digest)
+	reg.mu.Lock()
+	reg.manifests["team/app@"+other] = m
+	reg.mu.Unlock()
+	if _, err := c.GetManifest("team/app", other); err == nil {
+		t.Error("GetManifest accepted content that does not match the requested digest")
+	}
+
+	if _, err := c.GetManifest("team/app", "missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing tag: err = %v", err)
+	}
+}
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ImageRef
+	}{
+		{"factoriotools/factorio:2.0.72", ImageRef{"registry-1.docker.io", "factoriotools/factorio", "2.0.72"}},
+		{"alpine", ImageRef{"registry-1.docker.io", "library/alpine", "latest"}},
+		{"ghcr.io/owner/app@sha256:abc", ImageRef{"ghcr.io", "owner/app", "sha256:abc"}},
+		{"localhost:5000/app:dev", ImageRef{"localhost:5000", "app", "dev"}},
+	}
+	for _, tt := range tests {
+		got, err := parseImageRef(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseImageRef(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Cmd is an external command run through a Runner.
+type Cmd struct {
+	Name   string
+	Args   []string
+	Env    []string  // appended to the current environment
+	Stdin  io.Reader // nil means no input
+	Stdout io.Writer // nil discards
+	Stderr io.Writer // nil discards
+}
+
+// String returns the command line, e.g. "docker buildx inspect".
+func (c Cmd) String() string {
+	return strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+}
+
+// short returns the command name and its first two arguments, for error messages.
+func (c Cmd) short() string {
+	return strings.Join(strings.Fields(c.String())[:min(3, 1+len(c.Args))], " ")
+}
+
+// Runner executes external commands. Every target runs commands through the
+// package-level runner so that tests can stand in a fake for the host.
+type Runner interface {
+	// Run executes c and waits for it, stopping it when ctx is done; a
+	// non-zero exit is an *ExitError.
+	Run(ctx context.Context, c Cmd) error
+	// LookPath resolves an executable like exec.LookPath.
+	LookPath(file string) (string, error)
+}
+
+// ExitError reports a command that ran and exited non-zero.
+type ExitError struct {
+	Cmd  string
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// runner is the Runner used by all targets.
+var runner Runner = execRunner{}
+
+// execRunner runs commands on the host with os/exec.
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, c Cmd) error {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = commandWaitDelay
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = c.Stdin, c.Stdout, c.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Cmd: c.String(), Code: exitErr.ExitCode()}
+	}
+	return err
+}
+
+func (execRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+// runCmd runs a command and prints its stdout/stderr inline.
+func runCmd(name string, args ...string) error {
+	return runCommand(Cmd{Name: name, Args: args, Stdout: os.Stdout, Stderr: os.Stderr})
+}
+
+// runQuiet runs a command, discarding its output.
+func runQuiet(name string, args ...string) error {
+	return runCommand(Cmd{Name: name, Args: args})
+}
+
+// cmdOutput runs a command and returns its stdout.
+func cmdOutput(name string, args ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+	err := runCommand(Cmd{Name: name, Args: args, Stdout: &stdout})
+	return stdout.Bytes(), err
+}
+
+// cmdCombinedOutput runs a command and returns its stdout and stderr interleaved.
+func cmdCombinedOutput(name string, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	err := runCommand(Cmd{Name: name, Args: args, Stdout: &out, Stderr: &out})
+	return out.Bytes(), err
+}
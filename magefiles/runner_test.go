@@ -0,0 +1,143 @@
+//go:build mage
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// useRunner replaces the package runner, returning a func that restores the previous one.
+func useRunner(r Runner) (restore func()) {
+	prev := runner
+	runner = r
+	return func() { runner = prev }
+}
+
+// FakeRunner is a Runner that replays canned results and records every call,
+// so targets can be exercised without Docker, git or network access.
+type FakeRunner struct {
+	// Responses are matched in order against each command line by prefix.
+	Responses []FakeResponse
+	// Paths maps executable names to LookPath results; anything else is not found.
+	Paths map[string]string
+
+	mu    sync.Mutex
+	calls []Cmd
+}
+
+// FakeResponse is the canned result for commands starting with Match.
+type FakeResponse struct {
+	Match    string // command line prefix, e.g. "docker buildx inspect"
+	Stdout   string
+	Stderr   string
+	ExitCode int   // non-zero returns an *ExitError
+	Err      error // returned instead of running, e.g. exec.ErrNotFound
+	Once     bool  // consumed after the first match, to script a sequence
+	// Delay holds the response back, like a slow command; it ends early
+	// with the context's error when the context is done first.
+	Delay time.Duration
+	// Files are written, relative to the working directory, before the
+	// response returns, like the report a command was told to --output.
+	Files map[string]string
+}
+
+// Run records c and writes the first matching response to its streams.
+// Unmatched commands fail, so tests notice commands they did not expect.
+func (f *FakeRunner) Run(ctx context.Context, c Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, c)
+	line := c.String()
+	var resp *FakeResponse
+	for i := range f.Responses {
+		if strings.HasPrefix(line, f.Responses[i].Match) {
+			r := f.Responses[i]
+			resp = &r
+			if r.Once {
+				f.Responses = append(f.Responses[:i:i], f.Responses[i+1:]...)
+			}
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if resp == nil {
+		return fmt.Errorf("fake runner: unexpected command %q", line)
+	}
+	if resp.Delay > 0 {
+		t := time.NewTimer(resp.Delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if resp.Err != nil {
+		return resp.Err
+	}
+	for path, content := range resp.Files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	if c.Stdout != nil {
+		if _, err := io.WriteString(c.Stdout, resp.Stdout); err != nil {
+			return err
+		}
+	}
+	if c.Stderr != nil {
+		if _, err := io.WriteString(c.Stderr, resp.Stderr); err != nil {
+			return err
+		}
+	}
+	if resp.ExitCode != 0 {
+		return &ExitError{Cmd: line, Code: resp.ExitCode}
+	}
+	return nil
+}
+
+// LookPath resolves file from Paths.
+func (f *FakeRunner) LookPath(file string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Cmd{Name: "lookpath", Args: []string{file}})
+	if p, ok := f.Paths[file]; ok {
+		return p, nil
+	}
+	return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+}
+
+// Calls returns the command lines run so far, in order.
+func (f *FakeRunner) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines := make([]string, len(f.calls))
+	for i, c := range f.calls {
+		lines[i] = c.String()
+	}
+	return lines
+}
+
+// Ran reports whether any recorded command line starts with prefix.
+func (f *FakeRunner) Ran(prefix string) bool {
+	for _, line := range f.Calls() {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
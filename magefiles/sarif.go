@@ -0,0 +1,414 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SARIF 2.1.0 output of Trivy scans, for GitHub code scanning uploads.
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	trivyInfoURI = "https://github.com/aquasecurity/trivy"
+)
+
+// sarifSecuritySeverity maps Trivy severities to the numeric scores GitHub
+// uses to bucket alerts (critical > 9.0, high ≥ 7.0, medium ≥ 4.0, low > 0).
+var sarifSecuritySeverity = map[string]string{
+	"CRITICAL": "9.5",
+	"HIGH":     "8.0",
+	"MEDIUM":   "5.5",
+	"LOW":      "2.0",
+	"UNKNOWN":  "0.0",
+}
+
+// sarifLevels maps Trivy severities to SARIF result levels.
+var sarifLevels = map[string]string{
+	"CRITICAL": "error",
+	"HIGH":     "error",
+	"MEDIUM":   "warning",
+	"LOW":      "note",
+	"UNKNOWN":  "note",
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool         `json:"tool"`
+	Results    []sarifResult     `json:"results"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	ShortDescription     sarifText `json:"shortDescription"`
+	FullDescription      sarifText `json:"fullDescription"`
+	HelpURI              string    `json:"helpUri,omitempty"`
+	Help                 sarifText `json:"help"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+	Properties struct {
+		Tags             []string `json:"tags"`
+		Precision        string   `json:"precision"`
+		SecuritySeverity string   `json:"security-severity"`
+	} `json:"properties"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	RuleIndex  int               `json:"ruleIndex"`
+	Level      string            `json:"level"`
+	Message    sarifText         `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI       string `json:"uri"`
+			URIBaseID string `json:"uriBaseId"`
+		} `json:"artifactLocation"`
+		Region struct {
+			StartLine int `json:"startLine"`
+		} `json:"region"`
+	} `json:"physicalLocation"`
+	Message sarifText `json:"message"`
+}
+
+// sarifLocations maps image layers to the Dockerfile lines that produced them.
+type sarifLocations struct {
+	Dockerfile string         // repository-relative path results point at
+	BaseLine   int            // FROM line of the upstream base image
+	Layers     map[string]int // diff ID of a hardening layer → line of its instruction; nil if unmapped
+}
+
+// trivyToSARIF converts a Trivy JSON report to a SARIF 2.1.0 log. Findings in
+// layers listed in loc.Layers point at the Dockerfile instruction that created
+// the layer; all others point at the FROM line of the upstream base image.
+func trivyToSARIF(report *TrivyReport, loc sarifLocations) *sarifLog {
+	digest := reportImageDigest(report)
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{Name: "Trivy", InformationURI: trivyInfoURI, Rules: []sarifRule{}}},
+		Properties: map[string]string{
+			"image":       report.ArtifactName,
+			"imageDigest": digest,
+		},
+		Results: []sarifResult{},
+	}
+
+	ruleIndex := make(map[string]int)
+	for _, r := range report.Results {
+		for _, v := range r.Vulnerabilities {
+			f := newVulnFinding(r, v)
+			idx, ok := ruleIndex[f.ID]
+			if !ok {
+				idx = len(run.Tool.Driver.Rules)
+				ruleIndex[f.ID] = idx
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRuleFor(f, v, r.Class))
+			}
+
+			line, origin := loc.Layers[v.Layer.DiffID], "hardening"
+			switch {
+			case loc.Layers == nil:
+				line, origin = loc.BaseLine, "unmapped"
+			case line == 0:
+				line, origin = loc.BaseLine, "upstream"
+			}
+			if line < 1 {
+				line = 1
+			}
+
+			var l sarifLocation
+			l.PhysicalLocation.ArtifactLocation.URI = loc.Dockerfile
+			l.PhysicalLocation.ArtifactLocation.URIBaseID = "%SRCROOT%"
+			l.PhysicalLocation.Region.StartLine = line
+			l.Message.Text = fmt.Sprintf("%s@%s: %s", report.ArtifactName, digest, f.Target)
+
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    f.ID,
+				RuleIndex: idx,
+				Level:     sarifLevels[f.Severity],
+				Message: sarifText{Text: fmt.Sprintf(
+					"Package: %s\nInstalled Version: %s\nVulnerability: %s\nSeverity: %s\nFixed Version: %s\nLink: %s",
+					f.Package, f.Installed, f.ID, f.Severity, f.Fixed, v.PrimaryURL)},
+				Locations: []sarifLocation{l},
+				Properties: map[string]string{
+					"imageDigest":      digest,
+					"layerDiffID":      v.Layer.DiffID,
+					"layerOrigin":      origin,
+					"package":          f.Package,
+					"installedVersion": f.Installed,
+					"fixedVersion":     f.Fixed,
+					"target":           f.Target,
+				},
+			})
+		}
+	}
+	return &sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}}
+}
+
+// sarifRuleFor describes vulnerability f, found in a Trivy result of class, as a SARIF rule.
+func sarifRuleFor(f VulnFinding, v TrivyVulnerability, class string) sarifRule {
+	title := f.Title
+	if title == "" {
+		title = f.ID
+	}
+	desc := v.Description
+	if desc == "" {
+		desc = title
+	}
+	rule := sarifRule{
+		ID:               f.ID,
+		Name:             "OsPackageVulnerability",
+		ShortDescription: sarifText{Text: title},
+		FullDescription:  sarifText{Text: desc},
+		HelpURI:          v.PrimaryURL,
+		Help:             sarifText{Text: fmt.Sprintf("Vulnerability %s\nSeverity: %s\nPackage: %s\nFixed Version: %s\nLink: %s", f.ID, f.Severity, f.Package, f.Fixed, v.PrimaryURL)},
+	}
+	if class == "lang-pkgs" {
+		rule.Name = "LanguageSpecificPackageVulnerability"
+	}
+	rule.DefaultConfiguration.Level = sarifLevels[f.Severity]
+	rule.Properties.Tags = []string{"vulnerability", "security", f.Severity}
+	rule.Properties.Precision = "very-high"
+	rule.Properties.SecuritySeverity = sarifSecuritySeverity[f.Severity]
+	return rule
+}
+
+// reportImageDigest returns the repo digest of the scanned image, or its image ID.
+func reportImageDigest(report *TrivyReport) string {
+	for _, d := range report.Metadata.RepoDigests {
+		if i := strings.LastIndex(d, "@"); i >= 0 {
+			return d[i+1:]
+		}
+	}
+	return report.Metadata.ImageID
+}
+
+// scanDockerfile is the Dockerfile that scans of an image given by reference
+// map findings onto: the pinned docker/output.Dockerfile the builds use, or the
+// template until Hardened:Prepare has written it.
+func scanDockerfile() string {
+	if _, err := os.Stat(outputDockerfile); err == nil {
+		return outputDockerfile
+	}
+	return hardenedDockerfile
+}
+
+// dockerInstruction is one instruction of a Dockerfile with continuations joined.
+type dockerInstruction struct {
+	Line    int // 1-based line the instruction starts on
+	Keyword string
+	Args    string
+}
+
+// dockerStage is a FROM instruction and the instructions that follow it.
+type dockerStage struct {
+	Name  string // AS name, if any
+	Base  string // image or stage the stage is built from
+	Line  int
+	Steps []dockerInstruction
+}
+
+// parseDockerfile splits a Dockerfile into build stages. Comments, parser
+// directives and blank lines are skipped, including inside continuations.
+func parseDockerfile(content string) []dockerStage {
+	var instrs []dockerInstruction
+	open := false
+	for i, raw := range strings.Split(content, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !open {
+			kw := strings.Fields(line)[0]
+			instrs = append(instrs, dockerInstruction{Line: i + 1, Keyword: strings.ToUpper(kw)})
+			line = strings.TrimSpace(line[len(kw):])
+		}
+		cur := &instrs[len(instrs)-1]
+		open = strings.HasSuffix(line, "\\")
+		cur.Args = strings.TrimSpace(cur.Args + " " + strings.TrimSuffix(line, "\\"))
+	}
+
+	var stages []dockerStage
+	for _, in := range instrs {
+		if in.Keyword != "FROM" {
+			if len(stages) > 0 {
+				stages[len(stages)-1].Steps = append(stages[len(stages)-1].Steps, in)
+			}
+			continue
+		}
+		var fields []string
+		for _, f := range strings.Fields(in.Args) {
+			if !strings.HasPrefix(f, "--") {
+				fields = append(fields, f)
+			}
+		}
+		st := dockerStage{Line: in.Line}
+		if len(fields) > 0 {
+			st.Base = fields[0]
+		}
+		if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+			st.Name = fields[2]
+		}
+		stages = append(stages, st)
+	}
+	return stages
+}
+
+// finalStageChain returns the stages the final image is built from, outermost
+// (the one FROM an external image) first.
+func finalStageChain(stages []dockerStage) []dockerStage {
+	if len(stages) == 0 {
+		return nil
+	}
+	i := len(stages) - 1
+	chain := []dockerStage{stages[i]}
+	for {
+		parent := -1
+		for j := i - 1; j >= 0; j-- {
+			if stages[j].Name != "" && strings.EqualFold(stages[j].Name, stages[i].Base) {
+				parent = j
+				break
+			}
+		}
+		if parent < 0 {
+			return chain
+		}
+		chain = append([]dockerStage{stages[parent]}, chain...)
+		i = parent
+	}
+}
+
+// chainSteps returns the instructions of chain in build order.
+func chainSteps(chain []dockerStage) []dockerInstruction {
+	var steps []dockerInstruction
+	for _, st := range chain {
+		steps = append(steps, st.Steps...)
+	}
+	return steps
+}
+
+// historyKeyword returns the Dockerfile instruction an image history entry
+// was created by, for both BuildKit ("RUN /bin/sh -c ...", "COPY ... # buildkit")
+// and the classic builder ("/bin/sh -c #(nop) COPY ...", "/bin/sh -c ..." for RUN).
+func historyKeyword(createdBy string) string {
+	const shell = "/bin/sh -c "
+	if strings.HasPrefix(createdBy, shell) {
+		rest := strings.TrimPrefix(createdBy, shell)
+		if !strings.HasPrefix(rest, "#(nop)") {
+			return "RUN"
+		}
+		createdBy = strings.TrimSpace(strings.TrimPrefix(rest, "#(nop)"))
+	}
+	if fields := strings.Fields(createdBy); len(fields) > 0 {
+		return strings.ToUpper(fields[0])
+	}
+	return ""
+}
+
+// matchLayerSteps pairs each layer, described by the created_by of its history
+// entry, with the next Dockerfile instruction of the same kind and returns the
+// instruction lines.
+func matchLayerSteps(createdBy []string, steps []dockerInstruction) ([]int, error) {
+	lines := make([]int, 0, len(createdBy))
+	next := 0
+	for i, cb := range createdBy {
+		kw := historyKeyword(cb)
+		for next < len(steps) && steps[next].Keyword != kw {
+			next++
+		}
+		if next == len(steps) {
+			return nil, fmt.Errorf("layer %d (%s) has no matching instruction", i, kw)
+		}
+		lines = append(lines, steps[next].Line)
+		next++
+	}
+	return lines, nil
+}
+
+// sarifLocationsFor maps the hardening layers of the image in report to the
+// instructions of dockerfile that created them, using the image history.
+// When that fails, findings fall back to the base FROM line.
+func sarifLocationsFor(report *TrivyReport, dockerfile string) sarifLocations {
+	loc := sarifLocations{Dockerfile: dockerfile, BaseLine: 1}
+	content, err := os.ReadFile(dockerfile)
+	if err != nil {
+		logWarn("Cannot read %s for SARIF locations: %v", dockerfile, err)
+		return loc
+	}
+	chain := finalStageChain(parseDockerfile(string(content)))
+	if len(chain) == 0 {
+		return loc
+	}
+	loc.BaseLine = chain[0].Line
+
+	cfg := report.Metadata.ImageConfig
+	diffIDs := report.Metadata.DiffIDs
+	var layerHistory []string
+	for _, h := range cfg.History {
+		if !h.EmptyLayer {
+			layerHistory = append(layerHistory, h.CreatedBy)
+		}
+	}
+	if len(layerHistory) != len(diffIDs) {
+		logWarn("SARIF findings not mapped to Dockerfile lines: image history lists %d layers, rootfs %d",
+			len(layerHistory), len(diffIDs))
+		return loc
+	}
+	baseCount, err := baseLayerCount(report.ArtifactName, cfg, cfg.OS+"/"+cfg.Architecture, diffIDs)
+	if err != nil {
+		logWarn("SARIF findings not mapped to Dockerfile lines: %v", err)
+		return loc
+	}
+	lines, err := matchLayerSteps(layerHistory[baseCount:], chainSteps(chain))
+	if err != nil {
+		logWarn("SARIF findings not mapped to %s: %v", dockerfile, err)
+		return loc
+	}
+	loc.Layers = make(map[string]int, len(lines))
+	for i, id := range diffIDs[baseCount:] {
+		loc.Layers[id] = lines[i]
+	}
+	return loc
+}
+
+// writeSARIF converts report to SARIF, mapping findings onto dockerfile, the
+// Dockerfile the scanned image was built from, and writes it to path.
+func writeSARIF(report *TrivyReport, dockerfile, path string) error {
+	log := trivyToSARIF(report, sarifLocationsFor(report, dockerfile))
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode SARIF: %v", err)
+	}
+	if err := writeFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write SARIF: %v", err)
+	}
+	logInfo("🧾 SARIF report written → %s", path)
+	return nil
+}
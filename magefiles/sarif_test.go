@@ -0,0 +1,199 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// trivySARIFFixture is a Trivy JSON report of an image built from
+// docker/Dockerfile: one upstream layer, then the COPY, RUN, COPY and RUN
+// layers of the hardened stage. Findings sit in the base layer, a hardening
+// RUN layer and the last RUN layer; CVE-2024-0001 hits two packages.
+const trivySARIFFixture = `{
+  "SchemaVersion": 2,
+  "ArtifactName": "factorio-hardened:dev",
+  "ArtifactType": "container_image",
+  "Metadata": {
+    "ImageID": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+    "DiffIDs": ["sha256:base", "sha256:copy-config", "sha256:run-dirs", "sha256:copy-entrypoint", "sha256:run-chmod"],
+    "RepoDigests": ["ghcr.io/henryhall897/factorio-hardened@sha256:3333333333333333333333333333333333333333333333333333333333333333"],
+    "ImageConfig": {
+      "architecture": "amd64",
+      "os": "linux",
+      "config": {"Labels": {"org.opencontainers.image.base.digest": "sha256:bee8e1751f9cf59d673cb9eb751d55403407f90aa3701125b267ec778b85677a"}},
+      "history": [
+        {"created_by": "/bin/sh -c #(nop) ADD file:0123 in / "},
+        {"created_by": "USER root", "empty_layer": true},
+        {"created_by": "COPY /defaults/config /factorio/config/ # buildkit"},
+        {"created_by": "RUN /bin/sh -c set -eux; mkdir -p /factorio # buildkit"},
+        {"created_by": "COPY scripts/hardened-entrypoint.sh /usr/local/bin/hardened-entrypoint.sh # buildkit"},
+        {"created_by": "RUN /bin/sh -c chmod +x /usr/local/bin/hardened-entrypoint.sh # buildkit"},
+        {"created_by": "USER factorio:factorio", "empty_layer": true}
+      ]
+    }
+  },
+  "Results": [
+    {
+      "Target": "factorio-hardened:dev (debian 12.7)",
+      "Class": "os-pkgs",
+      "Type": "debian",
+      "Vulnerabilities": [
+        {"VulnerabilityID": "CVE-2024-0001", "PkgName": "libc6", "InstalledVersion": "2.36-9", "FixedVersion": "2.36-10", "Severity": "CRITICAL", "Title": "glibc overflow", "PrimaryURL": "https://avd.aquasec.com/nvd/cve-2024-0001", "Layer": {"DiffID": "sha256:base"}},
+        {"VulnerabilityID": "CVE-2024-0001", "PkgName": "libc-bin", "InstalledVersion": "2.36-9", "FixedVersion": "2.36-10", "Severity": "CRITICAL", "Title": "glibc overflow", "Layer": {"DiffID": "sha256:base"}},
+        {"VulnerabilityID": "CVE-2024-0002", "PkgName": "coreutils", "InstalledVersion": "9.1-1", "Severity": "medium", "Layer": {"DiffID": "sha256:run-dirs"}},
+        {"VulnerabilityID": "CVE-2024-0003", "PkgName": "bash", "InstalledVersion": "5.2-2", "Severity": "WHATEVER", "Layer": {"DiffID": "sha256:run-chmod"}}
+      ]
+    },
+    {
+      "Target": "usr/local/bin/tool",
+      "Class": "lang-pkgs",
+      "Type": "gobinary",
+      "Vulnerabilities": [
+        {"VulnerabilityID": "GHSA-xxxx-yyyy-zzzz", "PkgName": "golang.org/x/net", "InstalledVersion": "v0.1.0", "FixedVersion": "0.17.0", "Severity": "LOW", "Layer": {"DiffID": "sha256:copy-entrypoint"}}
+      ]
+    }
+  ]
+}`
+
+// convertSARIFFixture runs trivySARIFFixture through sarifLocationsFor and
+// trivyToSARIF against dockerfile, with docker reporting the single base layer.
+func convertSARIFFixture(t *testing.T, dockerfile string) sarifRun {
+	t.Helper()
+	var report TrivyReport
+	if err := json.Unmarshal([]byte(trivySARIFFixture), &report); err != nil {
+		t.Fatal(err)
+	}
+	fake := &FakeRunner{Responses: []FakeResponse{
+		{Match: "docker image inspect", Stdout: `["sha256:base"]`},
+	}}
+	defer useRunner(fake)()
+
+	log := trivyToSARIF(&report, sarifLocationsFor(&report, dockerfile))
+	if log.Version != "2.1.0" || log.Schema != sarifSchema || len(log.Runs) != 1 {
+		t.Fatalf("SARIF log header = %s %s with %d runs", log.Version, log.Schema, len(log.Runs))
+	}
+	return log.Runs[0]
+}
+
+func TestTrivyToSARIF(t *testing.T) {
+	newTestProject(t)
+	run := convertSARIFFixture(t, hardenedDockerfile)
+
+	tests := []struct {
+		rule, pkg string
+		level     string
+		ruleName  string
+		severity  string // security-severity of the rule
+		line      int    // docker/Dockerfile line the result points at
+		origin    string
+	}{
+		{"CVE-2024-0001", "libc6", "error", "OsPackageVulnerability", "9.5", 5, "upstream"},
+		{"CVE-2024-0001", "libc-bin", "error", "OsPackageVulnerability", "9.5", 5, "upstream"},
+		{"CVE-2024-0002", "coreutils", "warning", "OsPackageVulnerability", "5.5", 25, "hardening"},
+		{"CVE-2024-0003", "bash", "note", "OsPackageVulnerability", "0.0", 32, "hardening"},
+		{"GHSA-xxxx-yyyy-zzzz", "golang.org/x/net", "note", "LanguageSpecificPackageVulnerability", "2.0", 31, "hardening"},
+	}
+	if len(run.Results) != len(tests) {
+		t.Fatalf("got %d results, want %d", len(run.Results), len(tests))
+	}
+	if len(run.Tool.Driver.Rules) != 4 {
+		t.Errorf("got %d rules, want 4 (one per vulnerability ID)", len(run.Tool.Driver.Rules))
+	}
+	for i, tt := range tests {
+		t.Run(tt.rule+"/"+tt.pkg, func(t *testing.T) {
+			res := run.Results[i]
+			if res.RuleID != tt.rule || res.Properties["package"] != tt.pkg {
+				t.Fatalf("result %d is %s in %s", i, res.RuleID, res.Properties["package"])
+			}
+			if res.Level != tt.level {
+				t.Errorf("level = %s, want %s", res.Level, tt.level)
+			}
+			rule := run.Tool.Driver.Rules[res.RuleIndex]
+			if rule.ID != tt.rule || rule.Name != tt.ruleName {
+				t.Errorf("rule %d = %s %s, want %s %s", res.RuleIndex, rule.ID, rule.Name, tt.rule, tt.ruleName)
+			}
+			if rule.DefaultConfiguration.Level != tt.level || rule.Properties.SecuritySeverity != tt.severity {
+				t.Errorf("rule level %s security-severity %s, want %s %s",
+					rule.DefaultConfiguration.Level, rule.Properties.SecuritySeverity, tt.level, tt.severity)
+			}
+			if len(res.Locations) != 1 {
+				t.Fatalf("got %d locations, want 1", len(res.Locations))
+			}
+			pl := res.Locations[0].PhysicalLocation
+			if pl.ArtifactLocation.URI != hardenedDockerfile || pl.ArtifactLocation.URIBaseID != "%SRCROOT%" {
+				t.Errorf("location %s (%s), want %s", pl.ArtifactLocation.URI, pl.ArtifactLocation.URIBaseID, hardenedDockerfile)
+			}
+			if pl.Region.StartLine != tt.line {
+				t.Errorf("line = %d, want %d", pl.Region.StartLine, tt.line)
+			}
+			if res.Properties["layerOrigin"] != tt.origin {
+				t.Errorf("layerOrigin = %s, want %s", res.Properties["layerOrigin"], tt.origin)
+			}
+		})
+	}
+}
+
+func TestTrivyToSARIFUnmappedLayers(t *testing.T) {
+	newTestProject(t)
+	// A Dockerfile with fewer layer instructions than the image cannot be
+	// matched, so every finding points at its FROM line.
+	if err := os.WriteFile(outputDockerfile, []byte("# pinned\nFROM factorio@sha256:abc\nRUN true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var run sarifRun
+	captureStdout(t, func() { run = convertSARIFFixture(t, outputDockerfile) })
+	for _, res := range run.Results {
+		pl := res.Locations[0].PhysicalLocation
+		if pl.ArtifactLocation.URI != outputDockerfile || pl.Region.StartLine != 2 || res.Properties["layerOrigin"] != "unmapped" {
+			t.Errorf("%s in %s: %s:%d (%s), want %s:2 (unmapped)", res.RuleID, res.Properties["package"],
+				pl.ArtifactLocation.URI, pl.Region.StartLine, res.Properties["layerOrigin"], outputDockerfile)
+		}
+	}
+}
+
+func TestWriteSARIFUsesScannedDockerfile(t *testing.T) {
+	newTestProject(t)
+	// The pinned Dockerfile carries two extra header lines, so its
+	// instructions sit two lines below the template's.
+	tmpl, err := os.ReadFile(hardenedDockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outputDockerfile, append([]byte("# Generated by Hardened:Prepare\n\n"), tmpl...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var report TrivyReport
+	if err := json.Unmarshal([]byte(trivySARIFFixture), &report); err != nil {
+		t.Fatal(err)
+	}
+	fake := &FakeRunner{Responses: []FakeResponse{{Match: "docker image inspect", Stdout: `["sha256:base"]`}}}
+	defer useRunner(fake)()
+
+	captureStdout(t, func() { err = writeSARIF(&report, scanDockerfile(), "trivy.sarif") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("trivy.sarif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range log.Runs[0].Results {
+		if res.RuleID != "CVE-2024-0002" {
+			continue
+		}
+		pl := res.Locations[0].PhysicalLocation
+		if pl.ArtifactLocation.URI != outputDockerfile || pl.Region.StartLine != 27 {
+			t.Errorf("CVE-2024-0002 at %s:%d, want %s:27", pl.ArtifactLocation.URI, pl.Region.StartLine, outputDockerfile)
+		}
+		return
+	}
+	t.Fatalf("CVE-2024-0002 missing from %s", strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,458 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SBOM formats generated for every platform image, keyed by file suffix.
+var sbomFormats = []struct {
+	suffix    string
+	format    string
+	mediaType string
+}{
+	{"spdx.json", "spdx-json", "text/spdx+json"},
+	{"cdx.json", "cyclonedx", "application/vnd.cyclonedx+json"},
+}
+
+// Annotations on each attached SBOM layer.
+const (
+	sbomTitleAnno    = "org.opencontainers.image.title"
+	sbomPlatformAnno = "dev.factorio-hardened.platform"
+)
+
+// sbomSource identifies the build an SBOM directory was generated from.
+type sbomSource struct {
+	Tag         string            `json:"Tag"`
+	Digest      string            `json:"Digest"`
+	Images      map[string]string `json:"Images"` // key = arch, value = scanned image reference
+	GeneratedAt time.Time         `json:"GeneratedAt"`
+}
+
+// sbomDir returns builddata/<env>/sbom.
+func sbomDir(env string) string {
+	return filepath.Join(buildDataDir, env, "sbom")
+}
+
+// platformImages returns the image reference to scan for each architecture of a build.
+// Prod builds are addressed by per-platform digest; test builds by their local tag.
+func platformImages(rec *BuildRecord) (map[string]string, error) {
+	images := make(map[string]string)
+	if len(rec.ArchDigests) > 0 {
+		ref, err := parseImageRef(rec.Tag)
+		if err != nil {
+			return nil, err
+		}
+		repo := strings.TrimSuffix(rec.Tag, ":"+ref.Reference)
+		for arch, digest := range rec.ArchDigests {
+			images[arch] = fmt.Sprintf("%s@%s", repo, digest)
+		}
+		return images, nil
+	}
+
+	if rec.Tag == "" || rec.Arch == "" || rec.Arch == "multi-arch" {
+		return nil, fmt.Errorf("build record has no per-platform digests to scan")
+	}
+	images[rec.Arch] = rec.Tag
+	return images, nil
+}
+
+// sbomTag returns the cosign tag convention for SBOMs of a digest: sha256-<hex>.sbom.
+func sbomTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sbom"
+}
+
+// Sbom generates SPDX and CycloneDX SBOMs for each platform image of the last
+// build in env ("test" or "prod") and diffs their package sets against the SBOMs
+// of the previous build, writing the results under builddata/<env>/sbom/.
+// Prod SBOMs are also attached to the pushed image index as <repo>:sha256-<hex>.sbom.
+func (Build) Sbom(env string) (err error) {
+	run := startTarget("build:sbom")
+	defer func() { run.finish(err) }()
+
+	if env != envTest && env != envProd {
+		return fmt.Errorf("unknown build environment %q (expected %s or %s)", env, envTest, envProd)
+	}
+	if err := verifyTrivy(); err != nil {
+		return err
+	}
+
+	rec, err := readBuildRecord(env)
+	if err != nil {
+		return err
+	}
+	if env == envTest {
+		return generateSboms(env, rec)
+	}
+	digest, err := publishSboms(rec)
+	if err != nil {
+		return err
+	}
+	rec.SBOM = digest
+	return writeBuildRecord(rec)
+}
+
+// publishSboms generates the SBOMs of a pushed prod build and attaches them to
+// its image index, returning the SBOM manifest digest.
+func publishSboms(rec *BuildRecord) (string, error) {
+	if err := generateSboms(envProd, rec); err != nil {
+		return "", err
+	}
+	images, err := platformImages(rec)
+	if err != nil {
+		return "", err
+	}
+	if dryRun() {
+		plan(planPush, "SBOMs of %s → %s", rec.Tag, sbomTag(rec.Digest))
+		return "", nil
+	}
+	digest, err := attachSbom(rec.Tag, rec.Digest, sbomDir(envProd), sortedKeys(images))
+	if err != nil {
+		return "", err
+	}
+	logInfo("📎 SBOMs attached to %s@%s → %s (%s)", rec.Tag, rec.Digest, sbomTag(rec.Digest), digest)
+	return digest, nil
+}
+
+// generateSboms writes the SBOMs of every platform image of rec to
+// builddata/<env>/sbom and diffs them against the previous build.
+func generateSboms(env string, rec *BuildRecord) error {
+	images, err := platformImages(rec)
+	if err != nil {
+		return err
+	}
+
+	dir := sbomDir(env)
+	prevDir := filepath.Join(dir, "previous")
+	if err := rotateSboms(dir, prevDir, rec.Digest); err != nil {
+		return err
+	}
+	arches := sortedKeys(images)
+	if dryRun() {
+		for _, arch := range arches {
+			for _, f := range sbomFormats {
+				plan(planWrite, "%s SBOM of %s → %s", f.format, images[arch], filepath.Join(dir, fmt.Sprintf("%s.%s", arch, f.suffix)))
+			}
+		}
+		plan(planWrite, "%s", filepath.Join(dir, "source.json"))
+		plan(planWrite, "%s (if a previous SBOM set was kept)", filepath.Join(dir, "diff.md"))
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create SBOM directory: %v", err)
+	}
+
+	for _, arch := range arches {
+		image := images[arch]
+		for _, f := range sbomFormats {
+			out := filepath.Join(dir, fmt.Sprintf("%s.%s", arch, f.suffix))
+			logInfo("📋 Generating %s SBOM for %s → %s", f.format, image, out)
+			args := []string{"image", "--quiet", "--format", f.format, "--output", out}
+			if env == envProd {
+				args = append(args, "--platform", "linux/"+arch)
+			}
+			if err := runTrivy(append(args, image)...); err != nil {
+				return fmt.Errorf("SBOM generation failed for %s (%s): %v", image, f.format, err)
+			}
+		}
+	}
+
+	source := sbomSource{Tag: rec.Tag, Digest: rec.Digest, Images: images, GeneratedAt: time.Now().UTC()}
+	data, err := json.MarshalIndent(source, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode SBOM source: %v", err)
+	}
+	if err := writeFile(filepath.Join(dir, "source.json"), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write SBOM source: %v", err)
+	}
+
+	// Diff against the previous build, if one was kept.
+	var report strings.Builder
+	fmt.Fprintf(&report, "# SBOM package diff for %s\n\n", rec.Tag)
+	compared := false
+	for _, arch := range arches {
+		cur := filepath.Join(dir, arch+".cdx.json")
+		prev := filepath.Join(prevDir, arch+".cdx.json")
+		if _, err := os.Stat(prev); err != nil {
+			continue
+		}
+		diff, err := diffSbomPackages(prev, cur)
+		if err != nil {
+			return err
+		}
+		compared = true
+		diff.writeMarkdown(&report, arch)
+		diff.print(arch)
+	}
+
+	if !compared {
+		logInfo("ℹ️  No previous build SBOM found; skipping package diff.")
+		return nil
+	}
+	diffPath := filepath.Join(dir, "diff.md")
+	if err := writeFile(diffPath, []byte(report.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write SBOM diff: %v", err)
+	}
+	logInfo("🧾 SBOM diff written → %s", diffPath)
+	return nil
+}
+
+// attachSbom uploads the SBOMs for arches from dir and pushes a manifest
+// listing them to the repository of tag under sbomTag(digest), as sign.go does
+// for signatures. It returns the SBOM manifest digest.
+func attachSbom(tag, digest, dir string, arches []string) (string, error) {
+	ref, err := parseImageRef(tag)
+	if err != nil {
+		return "", err
+	}
+	client := newAuthenticatedRegistryClient(ref)
+
+	var layers []ociDescriptor
+	for _, arch := range arches {
+		for _, f := range sbomFormats {
+			name := fmt.Sprintf("%s.%s", arch, f.suffix)
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				return "", fmt.Errorf("cannot read SBOM: %v", err)
+			}
+			blob, err := client.PushBlob(ref.Repository, data)
+			if err != nil {
+				return "", fmt.Errorf("failed to upload SBOM %s: %w", name, err)
+			}
+			layers = append(layers, ociDescriptor{
+				MediaType:   f.mediaType,
+				Size:        int64(len(data)),
+				Digest:      blob,
+				Annotations: map[string]string{sbomTitleAnno: name, sbomPlatformAnno: "linux/" + arch},
+			})
+		}
+	}
+	if len(layers) == 0 {
+		return "", fmt.Errorf("no SBOMs to attach for %s", tag)
+	}
+
+	diffIDs := make([]string, 0, len(layers))
+	for _, l := range layers {
+		diffIDs = append(diffIDs, l.Digest)
+	}
+	config, err := json.Marshal(map[string]any{
+		"architecture": "",
+		"os":           "",
+		"config":       map[string]any{},
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": diffIDs},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode SBOM config: %w", err)
+	}
+	configDigest, err := client.PushBlob(ref.Repository, config)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload SBOM config: %w", err)
+	}
+
+	manifest, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        ociDescriptor{MediaType: mediaTypeOCIConfig, Size: int64(len(config)), Digest: configDigest},
+		Layers:        layers,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode SBOM manifest: %w", err)
+	}
+	pushed, err := client.PutManifest(ref.Repository, sbomTag(digest), mediaTypeOCIManifest, manifest)
+	if err != nil {
+		return "", err
+	}
+
+	// Read the attachment back so a registry that silently dropped it fails the build.
+	got, err := client.HeadManifest(ref.Repository, sbomTag(digest))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve attached SBOMs: %w", err)
+	}
+	if got != pushed {
+		return "", fmt.Errorf("%s resolves to %s, expected %s", sbomTag(digest), got, pushed)
+	}
+	return pushed, nil
+}
+
+// rotateSboms moves the SBOMs in dir to prevDir when they belong to a different
+// build than digest, so the next diff compares against the previous build.
+func rotateSboms(dir, prevDir, digest string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "source.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read SBOM source: %v", err)
+	}
+
+	var source sbomSource
+	if err := json.Unmarshal(data, &source); err != nil {
+		return fmt.Errorf("failed to parse SBOM source: %v", err)
+	}
+	if source.Digest == digest {
+		return nil // regenerating for the same build; keep the existing previous set
+	}
+	if dryRun() {
+		plan(planRemove, "%s", prevDir)
+		plan(planWrite, "%s (SBOMs of %s moved from %s)", prevDir, source.Digest, dir)
+		return nil
+	}
+
+	if err := os.RemoveAll(prevDir); err != nil {
+		return fmt.Errorf("failed to clear previous SBOMs: %v", err)
+	}
+	if err := os.MkdirAll(prevDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create previous SBOM directory: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("cannot list SBOM directory: %v", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := os.Rename(filepath.Join(dir, e.Name()), filepath.Join(prevDir, e.Name())); err != nil {
+			return fmt.Errorf("failed to rotate %s: %v", e.Name(), err)
+		}
+	}
+	logInfo("ℹ️  Previous SBOMs (%s) moved to %s", source.Digest, prevDir)
+	return nil
+}
+
+// cycloneDX is the subset of a CycloneDX document needed to list packages.
+type cycloneDX struct {
+	Components []struct {
+		Type    string `json:"type"`
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Purl    string `json:"purl"`
+	} `json:"components"`
+}
+
+// loadSbomPackages returns package name → version from a CycloneDX SBOM.
+// Packages are keyed by purl without its version so the same name from
+// different ecosystems (e.g. deb vs. gem) stays distinct.
+func loadSbomPackages(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read SBOM %s: %v", path, err)
+	}
+	var doc cycloneDX
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse SBOM %s: %v", path, err)
+	}
+
+	pkgs := make(map[string]string)
+	for _, c := range doc.Components {
+		if c.Type != "library" && c.Type != "application" {
+			continue
+		}
+		key := c.Name
+		if c.Purl != "" {
+			key = strings.SplitN(strings.SplitN(c.Purl, "?", 2)[0], "@", 2)[0]
+		}
+		pkgs[key] = c.Version
+	}
+	return pkgs, nil
+}
+
+// sbomDiff is the package-level difference between two SBOMs.
+type sbomDiff struct {
+	Added   []string // "name version"
+	Removed []string
+	Changed []string // "name old → new"
+	Same    int
+}
+
+// diffSbomPackages compares the package sets of two CycloneDX SBOMs.
+func diffSbomPackages(prevPath, curPath string) (*sbomDiff, error) {
+	prev, err := loadSbomPackages(prevPath)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := loadSbomPackages(curPath)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &sbomDiff{}
+	for name, v := range cur {
+		old, ok := prev[name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, fmt.Sprintf("%s %s", name, v))
+		case old != v:
+			d.Changed = append(d.Changed, fmt.Sprintf("%s %s → %s", name, old, v))
+		default:
+			d.Same++
+		}
+	}
+	for name, v := range prev {
+		if _, ok := cur[name]; !ok {
+			d.Removed = append(d.Removed, fmt.Sprintf("%s %s", name, v))
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d, nil
+}
+
+// print writes a short console summary of the diff.
+func (d *sbomDiff) print(arch string) {
+	logInfo("📦 %s: %d added, %d removed, %d changed, %d unchanged",
+		arch, len(d.Added), len(d.Removed), len(d.Changed), d.Same)
+	for _, p := range d.Added {
+		logInfo("   + %s", p)
+	}
+	for _, p := range d.Removed {
+		logInfo("   - %s", p)
+	}
+	for _, p := range d.Changed {
+		logInfo("   ~ %s", p)
+	}
+}
+
+// writeMarkdown appends a section for arch to a markdown report.
+func (d *sbomDiff) writeMarkdown(b *strings.Builder, arch string) {
+	fmt.Fprintf(b, "## %s\n\n", arch)
+	fmt.Fprintf(b, "%d added, %d removed, %d changed, %d unchanged.\n\n", len(d.Added), len(d.Removed), len(d.Changed), d.Same)
+	sections := []struct {
+		title string
+		items []string
+	}{
+		{"Added", d.Added},
+		{"Removed", d.Removed},
+		{"Changed", d.Changed},
+	}
+	for _, s := range sections {
+		if len(s.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "### %s\n\n", s.title)
+		for _, item := range s.items {
+			fmt.Fprintf(b, "- `%s`\n", item)
+		}
+		b.WriteString("\n")
+	}
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,70 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAttachSbom(t *testing.T) {
+	reg := newFakeRegistry(t)
+	index := reg.putJSONManifest("team/app", "2.0.72", mediaTypeOCIIndex, testIndex())
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"amd64.spdx.json": `{"spdxVersion":"SPDX-2.3"}`,
+		"amd64.cdx.json":  `{"bomFormat":"CycloneDX"}`,
+		"arm64.spdx.json": `{"spdxVersion":"SPDX-2.3","arch":"arm64"}`,
+		"arm64.cdx.json":  `{"bomFormat":"CycloneDX","arch":"arm64"}`,
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digest, err := attachSbom(reg.host()+"/team/app:2.0.72", index, dir, []string{"amd64", "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := reg.manifest("team/app", sbomTag(index))
+	if !ok {
+		t.Fatalf("no manifest at %s", sbomTag(index))
+	}
+	if digestOf(m.body) != digest {
+		t.Errorf("returned digest %s does not match stored manifest", digest)
+	}
+
+	var got ociManifest
+	if err := json.Unmarshal(m.body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Layers) != len(files) {
+		t.Fatalf("got %d layers, want %d", len(got.Layers), len(files))
+	}
+	c := reg.client()
+	for _, l := range got.Layers {
+		name := l.Annotations[sbomTitleAnno]
+		data, err := c.GetBlob("team/app", l.Digest)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(data) != files[name] {
+			t.Errorf("%s: blob content %q, want %q", name, data, files[name])
+		}
+		wantType := "application/vnd.cyclonedx+json"
+		if filepath.Ext(name[:len(name)-len(".json")]) == ".spdx" {
+			wantType = "text/spdx+json"
+		}
+		if l.MediaType != wantType {
+			t.Errorf("%s: media type %s, want %s", name, l.MediaType, wantType)
+		}
+	}
+
+	if _, err := attachSbom(reg.host()+"/team/app:2.0.72", index, dir, []string{"s390x"}); err == nil {
+		t.Error("attachSbom succeeded without SBOM files")
+	}
+}
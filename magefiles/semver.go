@@ -0,0 +1,104 @@
+//go:build mage
+
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed MAJOR.MINOR.PATCH version with an optional pre-release suffix.
+type semver struct {
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+// parseSemver parses versions like "2.0.72", "v1.25.3" or "1.64.8-rc1".
+// Missing minor or patch components are treated as zero ("1.25" == "1.25.0").
+func parseSemver(s string) (semver, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s = strings.TrimPrefix(s, "go")
+	if s == "" {
+		return semver{}, false
+	}
+
+	var v semver
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		if s[i] == '-' {
+			v.Pre = s[i+1:]
+			if j := strings.Index(v.Pre, "+"); j >= 0 {
+				v.Pre = v.Pre[:j]
+			}
+		}
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return semver{}, false
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		*nums[i] = n
+	}
+	return v, true
+}
+
+// isStrictSemver reports whether s is exactly MAJOR.MINOR.PATCH: three decimal
+// numbers without leading zeros, prefix or suffix ("1.2.3", not "v1.2.3",
+// "go1.2.3" or "1.02.3").
+func isStrictSemver(s string) bool {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for _, p := range parts {
+		if p == "" || (len(p) > 1 && p[0] == '0') {
+			return false
+		}
+		for _, r := range p {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compare returns -1, 0 or 1 when v is older than, equal to, or newer than o.
+// A pre-release sorts before the matching release.
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	case v.Pre < o.Pre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// String formats the version as MAJOR.MINOR.PATCH[-PRE].
+func (v semver) String() string {
+	s := strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." + strconv.Itoa(v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
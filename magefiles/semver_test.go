@@ -0,0 +1,71 @@
+//go:build mage
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIsStrictSemver(t *testing.T) {
+	tests := map[string]bool{
+		"2.0.72":      true,
+		"0.0.0":       true,
+		"10.20.30":    true,
+		"v2.0.72":     false,
+		"go1.2.3":     false,
+		"1.02.3":      false,
+		"01.2.3":      false,
+		"1.2":         false,
+		"1.2.3.4":     false,
+		"1.2.3-rc1":   false,
+		"1.2.3+build": false,
+		"1..3":        false,
+		"stable":      false,
+	}
+	for in, want := range tests {
+		if got := isStrictSemver(in); got != want {
+			t.Errorf("isStrictSemver(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.0.72", "2.0.9", 1},
+		{"1.25", "1.25.0", 0},
+		{"1.64.8-rc1", "1.64.8", -1},
+		{"v1.2.3", "1.2.4", -1},
+	}
+	for _, tt := range tests {
+		a, _ := parseSemver(tt.a)
+		b, _ := parseSemver(tt.b)
+		if got := a.compare(b); got != tt.want {
+			t.Errorf("compare(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLatestUpstreamTag(t *testing.T) {
+	reg := newFakeRegistry(t)
+	image := reg.host() + "/factoriotools/factorio"
+	manifest := func(n int) []byte { return []byte(fmt.Sprintf(`{"schemaVersion":2,"n":%d}`, n)) }
+	for i, tag := range []string{"2.0.9", "2.0.10", "2.0.11", "02.0.99", "go9.9.9", "latest"} {
+		reg.putManifest("factoriotools/factorio", tag, mediaTypeOCIIndex, manifest(i))
+	}
+	// stable points at 2.0.10, which is older than the newest 2.0.11.
+	reg.putManifest("factoriotools/factorio", "stable", mediaTypeOCIIndex, manifest(1))
+
+	for channel, want := range map[string]string{"experimental": "2.0.11", "stable": "2.0.10"} {
+		got, err := latestUpstreamTag(image, channel)
+		if err != nil {
+			t.Fatalf("%s: %v", channel, err)
+		}
+		if got != want {
+			t.Errorf("%s: latestUpstreamTag = %q, want %q", channel, got, want)
+		}
+	}
+}
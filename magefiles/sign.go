@@ -0,0 +1,395 @@
+//go:build mage
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+)
+
+// Sign namespace signs pushed images with a locally held ECDSA P-256 key pair,
+// storing signatures as cosign-compatible OCI artifacts next to the image.
+// No Sigstore services (Fulcio, Rekor) are involved.
+type Sign mg.Namespace
+
+const (
+	// defaultSigningKey is the private key path, overridable with SIGNING_KEY. Never commit it.
+	defaultSigningKey = ".keys/cosign.key"
+	// defaultSigningPub is the public key path, overridable with SIGNING_PUB. Safe to commit.
+	defaultSigningPub = "cosign.pub"
+
+	mediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	mediaTypeOCIConfig     = "application/vnd.oci.image.config.v1+json"
+	cosignSignatureAnno    = "dev.cosignproject.cosign/signature"
+	cosignSignatureType    = "cosign container image signature"
+)
+
+// signingKeyPaths returns the private and public key paths in use.
+func signingKeyPaths() (string, string) {
+	priv := os.Getenv("SIGNING_KEY")
+	if priv == "" {
+		priv = defaultSigningKey
+	}
+	pub := os.Getenv("SIGNING_PUB")
+	if pub == "" {
+		pub = defaultSigningPub
+	}
+	return priv, pub
+}
+
+// simpleSigningPayload is the cosign "simple signing" document that is signed.
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// signatureTag returns the cosign tag convention for a digest: sha256-<hex>.sig.
+func signatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// resolveSignTarget splits image into its reference and resolves the digest to sign.
+// Images given by tag are resolved against the registry so the digest, not the tag, is signed.
+func resolveSignTarget(image string) (ImageRef, *registryClient, string, error) {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		return ImageRef{}, nil, "", err
+	}
+	client := newAuthenticatedRegistryClient(ref)
+
+	digest := ref.Reference
+	if !strings.HasPrefix(digest, "sha256:") {
+		digest, err = client.HeadManifest(ref.Repository, ref.Reference)
+		if err != nil {
+			return ImageRef{}, nil, "", fmt.Errorf("failed to resolve %s to a digest: %v", image, err)
+		}
+	}
+	return ref, client, digest, nil
+}
+
+// Keygen creates a new ECDSA P-256 signing key pair if none exists yet.
+// The private key is written with 0600 permissions; the public key can be committed.
+func (Sign) Keygen() (err error) {
+	run := startTarget("sign:keygen")
+	defer func() { run.finish(err) }()
+
+	privPath, pubPath := signingKeyPaths()
+	if _, err := os.Stat(privPath); err == nil {
+		logWarn("Signing key already exists at %s; not overwriting.", privPath)
+		return nil
+	}
+
+	if dryRun() {
+		plan(planWrite, "%s and %s (new ECDSA P-256 key pair)", privPath, pubPath)
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %w", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(privPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+
+	logInfo("Signing key pair created:")
+	logInfo("  private: %s (keep secret)", privPath)
+	logInfo("  public:  %s", pubPath)
+	return nil
+}
+
+// Image signs the image index digest of image (tag or @digest) and pushes the
+// signature to <repo>:sha256-<hex>.sig in the same repository.
+func (Sign) Image(image string) (err error) {
+	run := startTarget("sign:image")
+	defer func() { run.finish(err) }()
+
+	ref, client, digest, err := resolveSignTarget(image)
+	if err != nil {
+		return err
+	}
+
+	if dryRun() {
+		plan(planSign, "%s/%s@%s → push %s:%s", ref.Registry, ref.Repository, digest, ref.Repository, signatureTag(digest))
+		return nil
+	}
+	sigDigest, err := signImage(client, ref, digest)
+	if err != nil {
+		return err
+	}
+	logInfo("Signed %s/%s@%s", ref.Registry, ref.Repository, digest)
+	logInfo("  signature: %s:%s (%s)", ref.Repository, signatureTag(digest), sigDigest)
+	return nil
+}
+
+// Verify checks that image carries at least one valid signature from the local public key.
+func (Sign) Verify(image string) (err error) {
+	run := startTarget("sign:verify")
+	defer func() { run.finish(err) }()
+
+	ref, client, digest, err := resolveSignTarget(image)
+	if err != nil {
+		return err
+	}
+	if err := verifyImageSignature(client, ref, digest); err != nil {
+		return err
+	}
+	logInfo("Signature verified for %s/%s@%s", ref.Registry, ref.Repository, digest)
+	return nil
+}
+
+// signPushedImage signs digest in the repository of tag and verifies the result,
+// returning the signature manifest digest.
+func signPushedImage(tag, digest string) (string, error) {
+	ref, err := parseImageRef(tag)
+	if err != nil {
+		return "", err
+	}
+	client := newAuthenticatedRegistryClient(ref)
+
+	sigDigest, err := signImage(client, ref, digest)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyImageSignature(client, ref, digest); err != nil {
+		return "", err
+	}
+	return sigDigest, nil
+}
+
+// loadSigningKey reads the PKCS#8 PEM private key.
+func loadSigningKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read signing key (run mage sign:keygen): %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+	ec, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an ECDSA key", path)
+	}
+	return ec, nil
+}
+
+// loadVerifyKey reads the PKIX PEM public key (cosign.pub format).
+func loadVerifyKey(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	ec, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ECDSA key", path)
+	}
+	return ec, nil
+}
+
+// signImage signs digest and pushes the signature manifest, keeping any
+// signatures already attached by other keys. It returns the signature manifest digest.
+func signImage(client *registryClient, ref ImageRef, digest string) (string, error) {
+	privPath, _ := signingKeyPaths()
+	key, err := loadSigningKey(privPath)
+	if err != nil {
+		return "", err
+	}
+
+	var payload simpleSigningPayload
+	payload.Critical.Identity.DockerReference = ref.Registry + "/" + ref.Repository
+	payload.Critical.Image.DockerManifestDigest = digest
+	payload.Critical.Type = cosignSignatureType
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode signing payload: %w", err)
+	}
+
+	hash := sha256.Sum256(payloadBytes)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign payload: %w", err)
+	}
+
+	payloadDigest, err := client.PushBlob(ref.Repository, payloadBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload signature payload: %w", err)
+	}
+	layer := ociDescriptor{
+		MediaType:   mediaTypeSimpleSigning,
+		Size:        int64(len(payloadBytes)),
+		Digest:      payloadDigest,
+		Annotations: map[string]string{cosignSignatureAnno: base64.StdEncoding.EncodeToString(sig)},
+	}
+
+	// Keep signatures from other keys; replace our own earlier signature of the same payload.
+	var layers []ociDescriptor
+	existing, err := client.GetManifest(ref.Repository, signatureTag(digest))
+	switch {
+	case err == nil:
+		var m ociManifest
+		if err := json.Unmarshal(existing.Body, &m); err == nil {
+			for _, l := range m.Layers {
+				if l.Digest == payloadDigest {
+					old, err := base64.StdEncoding.DecodeString(l.Annotations[cosignSignatureAnno])
+					if err == nil && ecdsa.VerifyASN1(&key.PublicKey, hash[:], old) {
+						continue
+					}
+				}
+				layers = append(layers, l)
+			}
+		}
+	case !errors.Is(err, errManifestNotFound):
+		return "", fmt.Errorf("failed to check existing signatures: %w", err)
+	}
+	layers = append(layers, layer)
+
+	diffIDs := make([]string, 0, len(layers))
+	for _, l := range layers {
+		diffIDs = append(diffIDs, l.Digest)
+	}
+	config, err := json.Marshal(map[string]any{
+		"architecture": "",
+		"os":           "",
+		"config":       map[string]any{},
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": diffIDs},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode signature config: %w", err)
+	}
+	configDigest, err := client.PushBlob(ref.Repository, config)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload signature config: %w", err)
+	}
+
+	manifest, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        ociDescriptor{MediaType: mediaTypeOCIConfig, Size: int64(len(config)), Digest: configDigest},
+		Layers:        layers,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode signature manifest: %w", err)
+	}
+	return client.PutManifest(ref.Repository, signatureTag(digest), mediaTypeOCIManifest, manifest)
+}
+
+// verifyImageSignature succeeds if any signature layer attached to digest is a
+// valid signature by the local public key over a payload naming that digest.
+func verifyImageSignature(client *registryClient, ref ImageRef, digest string) error {
+	_, pubPath := signingKeyPaths()
+	pub, err := loadVerifyKey(pubPath)
+	if err != nil {
+		return err
+	}
+
+	sigManifest, err := client.GetManifest(ref.Repository, signatureTag(digest))
+	if errors.Is(err, errManifestNotFound) {
+		return fmt.Errorf("no signatures found for %s@%s", ref.Repository, digest)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch signatures: %w", err)
+	}
+
+	var m ociManifest
+	if err := json.Unmarshal(sigManifest.Body, &m); err != nil {
+		return fmt.Errorf("failed to parse signature manifest: %w", err)
+	}
+
+	var problems []string
+	for _, l := range m.Layers {
+		if l.MediaType != mediaTypeSimpleSigning {
+			continue
+		}
+		if err := verifySignatureLayer(client, ref, digest, l, pub); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", l.Digest, err))
+			continue
+		}
+		return nil
+	}
+
+	if len(problems) == 0 {
+		return fmt.Errorf("signature manifest for %s@%s has no signature layers", ref.Repository, digest)
+	}
+	return fmt.Errorf("no valid signature for %s@%s:\n  - %s", ref.Repository, digest, strings.Join(problems, "\n  - "))
+}
+
+// verifySignatureLayer checks one simple-signing layer against pub and digest.
+func verifySignatureLayer(client *registryClient, ref ImageRef, digest string, layer ociDescriptor, pub *ecdsa.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnno])
+	if err != nil || len(sig) == 0 {
+		return fmt.Errorf("missing or malformed signature annotation")
+	}
+
+	payloadBytes, err := client.GetBlob(ref.Repository, layer.Digest)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(payloadBytes)
+	if !ecdsa.VerifyASN1(pub, hash[:], sig) {
+		return fmt.Errorf("signature does not match public key")
+	}
+
+	var payload simpleSigningPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return fmt.Errorf("failed to parse signed payload: %w", err)
+	}
+	if payload.Critical.Type != cosignSignatureType {
+		return fmt.Errorf("unexpected payload type %q", payload.Critical.Type)
+	}
+	if payload.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("payload signs %s, not %s", payload.Critical.Image.DockerManifestDigest, digest)
+	}
+	if want := ref.Registry + "/" + ref.Repository; payload.Critical.Identity.DockerReference != want {
+		return fmt.Errorf("payload identity %s does not match %s", payload.Critical.Identity.DockerReference, want)
+	}
+	return nil
+}
@@ -3,12 +3,11 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,36 +15,42 @@ import (
 )
 
 // SrcDigest defines the namespace for managing the upstream Factorio source image digests.
-// It handles pulling, comparing, and syncing image digests across multiple architectures
+// It handles resolving, comparing, and syncing image digests across multiple architectures
 // to ensure reproducible builds before the hardened image is created.
 type SrcDigest mg.Namespace
 
-// Constants and configuration defaults.
+// Constants and configuration defaults. The upstream image, channel and tag
+// come from the project config (see config.go).
 const (
-	upstreamImage = "factoriotools/factorio"
-	// Change this tag whenever you want to baseline a new version.
-	factorioTag = "2.0.69"
+	// maxStableProbes bounds how many version tags are resolved while looking
+	// for the one the upstream "stable" tag points at.
+	maxStableProbes = 20
 )
 
+// supportedArches is the immutable set of architectures every baseline must pin.
+var supportedArches = []string{"amd64", "arm64"}
+
 // isValidArch returns true if the provided architecture should be included
 // in the multi-arch baseline. This enforces an immutable architecture policy.
 func isValidArch(arch string) bool {
-	switch strings.ToLower(strings.TrimSpace(arch)) {
-	case "amd64", "arm64":
-		return true
-	default:
-		return false
+	arch = strings.ToLower(strings.TrimSpace(arch))
+	for _, a := range supportedArches {
+		if a == arch {
+			return true
+		}
 	}
+	return false
 }
 
 // MultiArchMetadata represents stored metadata for all architectures
 // and the top-level manifest list digest.
 type MultiArchMetadata struct {
-	Repository   string            `json:"repository"`
-	Tag          string            `json:"tag"`
-	ManifestList string            `json:"manifest_list"` // top-level digest (multi-arch index)
-	Digests      map[string]string `json:"digests"`       // key = arch, value = digest
-	UpdatedAt    time.Time         `json:"updated_at"`
+	Repository   string            `json:"repository" yaml:"repository"`
+	Tag          string            `json:"tag" yaml:"tag"`
+	Version      string            `json:"version,omitempty" yaml:"version,omitempty"` // optional override of the Factorio version
+	ManifestList string            `json:"manifest_list" yaml:"manifest_list"`         // top-level digest (multi-arch index)
+	Digests      map[string]string `json:"digests" yaml:"digests"`                     // key = arch, value = digest
+	UpdatedAt    time.Time         `json:"updated_at" yaml:"updated_at"`
 }
 
 // getLocalArch returns the current GOARCH (normalized for Docker naming).
@@ -60,254 +65,311 @@ func getLocalArch() string {
 	}
 }
 
-// ensureDockerAvailable verifies that Docker is installed and accessible.
-func ensureDockerAvailable() error {
-	if _, err := exec.LookPath("docker"); err != nil {
-		return fmt.Errorf("docker not found in PATH: %v", err)
+// getUpstreamDigests queries the registry for the manifest list digest and
+// per-architecture digests of image. No Docker daemon or image pull is required.
+func getUpstreamDigests(image string) (string, map[string]string, error) {
+	listDigest, archDigests, err := fetchUpstreamIndex(image)
+	if err != nil {
+		return "", nil, err
 	}
-	return nil
+	if len(archDigests) == 0 {
+		return "", nil, fmt.Errorf("manifest list for %s contains no linux platforms", image)
+	}
+	return listDigest, archDigests, nil
 }
 
-// getLocalManifestListDigest retrieves the multi-arch manifest list digest.
-func getLocalManifestListDigest(image string) (string, error) {
-	cmd := exec.Command("docker", "inspect", "--format", "{{index .RepoDigests 0}}", image)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("failed to inspect manifest list digest: %v\n%s", err, string(output))
+// resolveFactorioTag picks the upstream tag to work against: the configured
+// factorioTag (FACTORIO_TAG) if set, otherwise the tag recorded in the baseline,
+// otherwise the newest tag for the configured channel.
+func resolveFactorioTag(cfg *ProjectConfig) (string, error) {
+	if cfg.FactorioTag != "" {
+		return cfg.FactorioTag, nil
 	}
-	parts := strings.SplitN(strings.TrimSpace(string(output)), "@", 2)
-	if len(parts) != 2 {
-		return "", fmt.Errorf("unexpected digest format: %s", string(output))
+	if meta, err := loadBaseline(); err == nil && meta.Tag != "" {
+		return meta.Tag, nil
 	}
-	return parts[1], nil // only the sha256:... part
+	return latestUpstreamTag(cfg.UpstreamImage, cfg.FactorioChannel)
 }
 
-// getLocalArchDigest retrieves the architecture-specific digest for the current platform.
-func getLocalArchDigest(image string) (string, error) {
-	cmd := exec.Command("docker", "manifest", "inspect", image)
-	output, err := cmd.CombinedOutput()
+// latestUpstreamTag lists the tags of upstreamImage and returns the newest
+// MAJOR.MINOR.PATCH tag for the channel. Experimental is simply the highest
+// version published; stable is the highest version whose digest matches the
+// upstream "stable" tag.
+func latestUpstreamTag(upstreamImage, channel string) (string, error) {
+	tags, err := listUpstreamTags(upstreamImage)
 	if err != nil {
-		return "", fmt.Errorf("failed to inspect manifest: %v\n%s", err, string(output))
+		return "", err
 	}
 
-	var manifest struct {
-		Manifests []struct {
-			Digest   string `json:"digest"`
-			Platform struct {
-				Architecture string `json:"architecture"`
-				OS           string `json:"os"`
-			} `json:"platform"`
-		} `json:"manifests"`
+	// Keep each tag as listed so the result always names a tag that exists upstream.
+	type versionTag struct {
+		tag string
+		v   semver
 	}
-	if err := json.Unmarshal(output, &manifest); err != nil {
-		return "", fmt.Errorf("failed to parse manifest JSON: %v", err)
+	var versions []versionTag
+	hasStable := false
+	for _, t := range tags {
+		if t == "stable" {
+			hasStable = true
+		}
+		if !isStrictSemver(t) {
+			continue
+		}
+		if v, ok := parseSemver(t); ok {
+			versions = append(versions, versionTag{t, v})
+		}
 	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no version tags found for %s", upstreamImage)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].v.compare(versions[j].v) > 0 })
 
-	localArch := getLocalArch()
-	for _, m := range manifest.Manifests {
-		if strings.EqualFold(m.Platform.Architecture, localArch) {
-			return m.Digest, nil
+	if channel == "experimental" || !hasStable {
+		if channel == "stable" {
+			logWarn("No \"stable\" tag published for %s; using newest version.", upstreamImage)
+		}
+		return versions[0].tag, nil
+	}
+
+	stableDigest, err := headUpstreamDigest(upstreamImage + ":stable")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve stable tag: %w", err)
+	}
+	for i, v := range versions {
+		if i >= maxStableProbes {
+			break
+		}
+		digest, err := headUpstreamDigest(fmt.Sprintf("%s:%s", upstreamImage, v.tag))
+		if err != nil {
+			return "", err
+		}
+		if digest == stableDigest {
+			return v.tag, nil
 		}
 	}
-	return "", fmt.Errorf("no digest found for architecture %s", localArch)
+	return "", fmt.Errorf("no version tag among the newest %d matches stable (%s)", maxStableProbes, stableDigest)
 }
 
 // All runs the full source digest maintenance workflow.
-func (SrcDigest) All() error {
-	fmt.Println("Running SrcDigest:All workflow...")
+func (SrcDigest) All() (err error) {
+	run := startTarget("srcDigest:all")
+	defer func() { run.finish(err) }()
+
+	logInfo("Running SrcDigest:All workflow...")
 
 	if err := (SrcDigest{}.Show()); err != nil {
-		fmt.Printf("Show step failed: %v\n", err)
+		logWarn("Show step failed: %v", err)
 	}
 
-	err := (SrcDigest{}.Compare())
+	err = (SrcDigest{}.Compare())
 
 	if err != nil && strings.Contains(strings.ToLower(err.Error()), "no baseline") {
-		fmt.Println("Baseline missing. Performing initial sync...")
+		logInfo("Baseline missing. Performing initial sync...")
 		if syncErr := (SrcDigest{}.Sync()); syncErr != nil {
 			return fmt.Errorf("initial sync failed: %v", syncErr)
 		}
-		fmt.Println("Baseline initialized successfully.")
+		logInfo("Baseline initialized successfully.")
 		return nil
 	}
 
 	if err == nil {
-		fmt.Println("Baseline is already up to date. No sync required.")
+		logInfo("Baseline is already up to date. No sync required.")
 		return nil
 	}
 
-	fmt.Printf("Change detected: %v\n", err)
-	fmt.Println("Synchronizing to target version...")
+	logInfo("Change detected: %v", err)
+	logInfo("Synchronizing to target version...")
 	if syncErr := (SrcDigest{}.Sync()); syncErr != nil {
 		return fmt.Errorf("sync failed: %v", syncErr)
 	}
 
-	fmt.Println("SrcDigest:All completed successfully.")
+	logInfo("SrcDigest:All completed successfully.")
 	return nil
 }
 
 // Show prints the current digest entry for the local architecture.
-func (SrcDigest) Show() error {
+func (SrcDigest) Show() (err error) {
+	run := startTarget("srcDigest:show")
+	defer func() { run.finish(err) }()
+
 	localArch := getLocalArch()
-	fmt.Printf("Fetching Factorio digests for architecture: %s\n", localArch)
+	logInfo("Fetching Factorio digests for architecture: %s", localArch)
 
-	data, err := os.ReadFile(baselineFile)
+	meta, err := loadBaseline()
 	if errors.Is(err, os.ErrNotExist) {
-		fmt.Println("No baseline found.")
+		logWarn("No baseline found.")
 		return nil
 	}
-
-	var meta MultiArchMetadata
-	if err := json.Unmarshal(data, &meta); err != nil {
-		return fmt.Errorf("failed to parse baseline file: %v", err)
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("Manifest list digest: %s\n", meta.ManifestList)
+	logInfo("Manifest list digest: %s", meta.ManifestList)
 	if digest, ok := meta.Digests[localArch]; ok {
-		fmt.Printf("Stored digest for %s: %s\n", localArch, digest)
+		logInfo("Stored digest for %s: %s", localArch, digest)
 	} else {
-		fmt.Printf("No digest found for %s in baseline.\n", localArch)
+		logWarn("No digest found for %s in baseline.", localArch)
 	}
 	return nil
 }
 
 // Compare checks whether the current manifest list or architecture digest differs from baseline.
-func (SrcDigest) Compare() error {
-	localArch := getLocalArch()
-	fullImage := fmt.Sprintf("%s:%s", upstreamImage, factorioTag)
-	fmt.Printf("Comparing digests for %s (%s)\n", localArch, fullImage)
+func (SrcDigest) Compare() (err error) {
+	run := startTarget("srcDigest:compare")
+	defer func() { run.finish(err) }()
 
-	if err := ensureDockerAvailable(); err != nil {
+	cfg, err := projectConfig()
+	if err != nil {
 		return err
 	}
-
-	currentList, err := getLocalManifestListDigest(fullImage)
+	localArch := getLocalArch()
+	tag, err := resolveFactorioTag(cfg)
 	if err != nil {
 		return err
 	}
-	currentArch, err := getLocalArchDigest(fullImage)
+	fullImage := fmt.Sprintf("%s:%s", cfg.UpstreamImage, tag)
+	logInfo("Comparing digests for %s (%s)", localArch, fullImage)
+
+	currentList, archDigests, err := getUpstreamDigests(fullImage)
 	if err != nil {
 		return err
 	}
+	currentArch, ok := archDigests[localArch]
+	if !ok {
+		return fmt.Errorf("no digest found for architecture %s", localArch)
+	}
 
-	data, err := os.ReadFile(baselineFile)
+	meta, err := loadBaseline()
 	if errors.Is(err, os.ErrNotExist) {
 		return fmt.Errorf("no baseline file found")
 	}
 	if err != nil {
-		return fmt.Errorf("cannot read baseline file: %v", err)
-	}
-
-	var meta MultiArchMetadata
-	if err := json.Unmarshal(data, &meta); err != nil {
-		return fmt.Errorf("failed to parse baseline: %v", err)
+		return err
 	}
 
 	if meta.ManifestList != currentList {
-		fmt.Printf("Manifest list updated.\nOld: %s\nNew: %s\n", meta.ManifestList, currentList)
+		logInfo("Manifest list updated: %s → %s", meta.ManifestList, currentList)
 		return fmt.Errorf("manifest list digest changed")
 	}
 
 	if oldArchDigest, ok := meta.Digests[localArch]; ok {
 		if oldArchDigest != currentArch {
-			fmt.Printf("Architecture digest updated for %s.\nOld: %s\nNew: %s\n", localArch, oldArchDigest, currentArch)
+			logInfo("Architecture digest updated for %s: %s → %s", localArch, oldArchDigest, currentArch)
 			return fmt.Errorf("digest changed for %s", localArch)
 		}
 	} else {
-		fmt.Printf("No digest found for %s in baseline.\nCurrent digest: %s\n", localArch, currentArch)
+		logWarn("No digest found for %s in baseline (current digest %s).", localArch, currentArch)
 		return fmt.Errorf("missing digest for %s", localArch)
 	}
 
-	fmt.Println("Baseline is up to date.")
+	logInfo("Baseline is up to date.")
 	return nil
 }
 
-// Sync pulls the Factorio image for the configured tag and updates (or creates) baseline.yaml.
-func (SrcDigest) Sync() error {
-	_ = os.MkdirAll("builddata", 0755)
-	localArch := getLocalArch()
-	fullImage := fmt.Sprintf("%s:%s", upstreamImage, factorioTag)
+// Latest finds the newest upstream Factorio tag for the configured channel
+// (factorioChannel, or FACTORIO_CHANNEL; stable by default) and syncs the
+// baseline to it if it has moved. It refuses to run while a tag is pinned
+// with factorioTag or FACTORIO_TAG, so a pinned baseline never moves.
+func (SrcDigest) Latest() (err error) {
+	run := startTarget("srcDigest:latest")
+	defer func() { run.finish(err) }()
 
-	fmt.Printf("Syncing Factorio image %s for architecture: %s\n", fullImage, localArch)
+	cfg, err := projectConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.FactorioTag != "" {
+		return fmt.Errorf("factorioTag is pinned to %s (%s); unset it to follow the %s channel, or run mage srcdigest:sync",
+			cfg.FactorioTag, cfg.Sources["factorioTag"], cfg.FactorioChannel)
+	}
+	channel := cfg.FactorioChannel
 
-	if err := ensureDockerAvailable(); err != nil {
+	logInfo("Looking up newest %s Factorio tag for %s...", channel, cfg.UpstreamImage)
+	latest, err := latestUpstreamTag(cfg.UpstreamImage, channel)
+	if err != nil {
 		return err
 	}
+	logInfo("Newest %s tag: %s", channel, latest)
 
-	cmd := exec.Command("docker", "pull", fullImage)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to pull upstream image: %v", err)
+	if meta, err := loadBaseline(); err == nil && meta.Tag != latest {
+		logInfo("Baseline tag moving from %s to %s.", meta.Tag, latest)
 	}
+	return syncTag(cfg.UpstreamImage, latest)
+}
+
+// Sync resolves the Factorio image digests directly from the registry and updates
+// (or creates) baseline.yaml. The tag comes from the configured factorioTag
+// (FACTORIO_TAG), the existing baseline, or the newest upstream tag, in that order.
+func (SrcDigest) Sync() (err error) {
+	run := startTarget("srcDigest:sync")
+	defer func() { run.finish(err) }()
 
-	listDigest, err := getLocalManifestListDigest(fullImage)
+	cfg, err := projectConfig()
 	if err != nil {
 		return err
 	}
-
-	cmd = exec.Command("docker", "manifest", "inspect", fullImage)
-	output, err := cmd.CombinedOutput()
+	run.step("resolve tag")
+	tag, err := resolveFactorioTag(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to inspect manifest: %v\n%s", err, string(output))
+		return err
 	}
+	run.step("record digests")
+	return syncTag(cfg.UpstreamImage, tag)
+}
 
-	var manifest struct {
-		Manifests []struct {
-			Digest   string `json:"digest"`
-			Platform struct {
-				Architecture string `json:"architecture"`
-				OS           string `json:"os"`
-			} `json:"platform"`
-		} `json:"manifests"`
+// syncTag records the manifest list and per-arch digests of upstreamImage:tag in baseline.yaml.
+func syncTag(upstreamImage, tag string) error {
+	if err := makeDirs(buildDataDir); err != nil {
+		return err
 	}
-	if err := json.Unmarshal(output, &manifest); err != nil {
-		return fmt.Errorf("failed to parse manifest: %v", err)
+	localArch := getLocalArch()
+	fullImage := fmt.Sprintf("%s:%s", upstreamImage, tag)
+
+	logInfo("Syncing Factorio image %s for architecture: %s", fullImage, localArch)
+
+	listDigest, archDigests, err := getUpstreamDigests(fullImage)
+	if err != nil {
+		return err
 	}
 
 	now := time.Now().UTC().Truncate(time.Second)
 
 	meta := MultiArchMetadata{
 		Repository:   upstreamImage,
-		Tag:          factorioTag,
+		Tag:          tag,
 		ManifestList: listDigest,
 		Digests:      make(map[string]string),
 		UpdatedAt:    now,
 	}
 	if data, err := os.ReadFile(baselineFile); err == nil {
-		var existing MultiArchMetadata
-		if err := json.Unmarshal(data, &existing); err == nil {
+		if existing, err := decodeBaseline(data); err == nil && existing.Tag == tag {
 			for k, v := range existing.Digests {
-				meta.Digests[k] = v
+				if isValidArch(k) {
+					meta.Digests[k] = v
+				}
 			}
 		}
 	}
 
-	for _, m := range manifest.Manifests {
-		arch := strings.TrimSpace(strings.ToLower(m.Platform.Architecture))
+	for arch, digest := range archDigests {
 		if !isValidArch(arch) {
-			fmt.Printf("Skipping unsupported arch %q (%s)\n", arch, m.Digest)
+			logWarn("Skipping unsupported arch %q (%s)", arch, digest)
 			continue
 		}
-		meta.Digests[arch] = m.Digest
+		meta.Digests[arch] = digest
 	}
 
-	file, err := os.Create(baselineFile)
-	if err != nil {
-		return fmt.Errorf("failed to write baseline file: %v", err)
+	if err := saveBaseline(meta); err != nil {
+		return err
 	}
-	defer file.Close()
-
-	enc := json.NewEncoder(file)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(meta); err != nil {
-		return fmt.Errorf("failed to encode baseline metadata: %v", err)
+	if err := recordBaselineHistory(meta); err != nil {
+		return err
 	}
 
-	fmt.Printf("Baseline updated for Factorio %s with manifest list %s and %d architectures.\n",
-		factorioTag, meta.ManifestList, len(meta.Digests))
-	for arch, digest := range meta.Digests {
-		fmt.Printf("  %s: %s\n", arch, digest)
+	logInfo("Baseline updated for Factorio %s with manifest list %s and %d architectures.",
+		tag, meta.ManifestList, len(meta.Digests))
+	for _, arch := range sortedKeys(meta.Digests) {
+		logInfo("  %s: %s", arch, meta.Digests[arch])
 	}
 	return nil
 }
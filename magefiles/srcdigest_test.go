@@ -0,0 +1,121 @@
+//go:build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// putUpstreamRelease publishes a multi-arch index for tag of the upstream
+// image in reg, distinguished by build, and returns its digest.
+func putUpstreamRelease(reg *fakeRegistry, tag string, build int) string {
+	idx := ImageIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex}
+	for _, arch := range supportedArches {
+		digest := reg.putManifest("factoriotools/factorio", "", mediaTypeOCIManifest,
+			[]byte(fmt.Sprintf(`{"schemaVersion":2,"arch":%q,"build":%d}`, arch, build)))
+		idx.Manifests = append(idx.Manifests, IndexManifest{MediaType: mediaTypeOCIManifest, Digest: digest,
+			Platform: &ImagePlatform{OS: "linux", Architecture: arch}})
+	}
+	return reg.putJSONManifest("factoriotools/factorio", tag, mediaTypeOCIIndex, idx)
+}
+
+func TestSrcDigestSyncAndCompare(t *testing.T) {
+	newTestProject(t)
+	reg := newFakeRegistry(t)
+	t.Setenv("UPSTREAM_IMAGE", reg.host()+"/factoriotools/factorio")
+	t.Setenv("FACTORIO_TAG", "2.0.72")
+	useProjectConfig(t)
+	fake := &FakeRunner{}
+	defer useRunner(fake)()
+
+	first := putUpstreamRelease(reg, "2.0.72", 1)
+	var err error
+	captureStdout(t, func() { err = (SrcDigest{}).Sync() })
+	if err != nil {
+		t.Fatalf("SrcDigest:Sync: %v", err)
+	}
+	meta, err := loadBaseline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Tag != "2.0.72" || meta.ManifestList != first || len(meta.Digests) != len(supportedArches) {
+		t.Errorf("baseline = %s %s %v, want 2.0.72 %s with every arch", meta.Tag, meta.ManifestList, meta.Digests, first)
+	}
+
+	captureStdout(t, func() { err = (SrcDigest{}).Compare() })
+	if err != nil {
+		t.Errorf("SrcDigest:Compare right after a sync: %v", err)
+	}
+
+	// Upstream re-publishes the tag.
+	second := putUpstreamRelease(reg, "2.0.72", 2)
+	captureStdout(t, func() { err = (SrcDigest{}).Compare() })
+	if err == nil || !strings.Contains(err.Error(), "manifest list digest changed") {
+		t.Errorf("SrcDigest:Compare after a re-publish = %v, want a changed digest", err)
+	}
+	captureStdout(t, func() { err = (SrcDigest{}).Sync() })
+	if err != nil {
+		t.Fatalf("SrcDigest:Sync: %v", err)
+	}
+	if meta, err := loadBaseline(); err != nil || meta.ManifestList != second {
+		t.Errorf("baseline after re-sync = %+v (%v), want manifest list %s", meta, err, second)
+	}
+
+	// The digests come from the registry API; no docker command is needed.
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("ran commands: %v", calls)
+	}
+	if !reg.sawRequest("GET /v2/factoriotools/factorio/manifests/2.0.72") {
+		t.Error("the upstream index was not fetched from the registry")
+	}
+}
+
+func TestSrcDigestSyncMissingTag(t *testing.T) {
+	newTestProject(t)
+	reg := newFakeRegistry(t)
+	t.Setenv("UPSTREAM_IMAGE", reg.host()+"/factoriotools/factorio")
+	t.Setenv("FACTORIO_TAG", "9.9.9")
+	useProjectConfig(t)
+	defer useRunner(&FakeRunner{})()
+
+	before, err := os.ReadFile(baselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureStdout(t, func() { err = (SrcDigest{}).Sync() })
+	if err == nil {
+		t.Fatal("SrcDigest:Sync succeeded for a tag the registry does not have")
+	}
+	if after, _ := os.ReadFile(baselineFile); string(after) != string(before) {
+		t.Errorf("failed sync rewrote %s", baselineFile)
+	}
+}
+
+func TestSrcDigestLatestKeepsPinnedTag(t *testing.T) {
+	newTestProject(t)
+	reg := newFakeRegistry(t)
+	t.Setenv("UPSTREAM_IMAGE", reg.host()+"/factoriotools/factorio")
+	t.Setenv("FACTORIO_TAG", "2.0.72")
+	useProjectConfig(t)
+	defer useRunner(&FakeRunner{})()
+	putUpstreamRelease(reg, "2.0.72", 1)
+	putUpstreamRelease(reg, "2.0.73", 1)
+
+	before, err := os.ReadFile(baselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureStdout(t, func() { err = (SrcDigest{}).Latest() })
+	if err == nil || !strings.Contains(err.Error(), "factorioTag is pinned to 2.0.72") {
+		t.Fatalf("SrcDigest:Latest with a pinned tag = %v, want a refusal", err)
+	}
+	if after, _ := os.ReadFile(baselineFile); string(after) != string(before) {
+		t.Errorf("SrcDigest:Latest moved the pinned baseline")
+	}
+	if reg.sawRequest("GET /v2/factoriotools/factorio/tags/list") {
+		t.Error("SrcDigest:Latest looked up upstream tags despite the pin")
+	}
+}
@@ -5,7 +5,6 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/magefile/mage/mg"
 )
@@ -15,8 +14,11 @@ import (
 type System mg.Namespace
 
 // Verify checks that required system tools are installed and that file permissions are secure.
-func (System) Verify() error {
-	fmt.Println("Verifying system tools and configuration...")
+func (System) Verify() (err error) {
+	run := startTarget("system:verify")
+	defer func() { run.finish(err) }()
+
+	logInfo("Verifying system tools and configuration...")
 
 	if err := verifySystemTools(); err != nil {
 		return err
@@ -26,19 +28,22 @@ func (System) Verify() error {
 		return err
 	}
 
-	fmt.Println("System verification completed successfully.")
+	logInfo("System verification completed successfully.")
 	return nil
 }
 
 // Deps ensures that required system tools are installed and that file permissions are secure.
 // It explicitly checks for insecure permissions even when Verify() passes, allowing self-healing.
-func (System) Deps() error {
-	fmt.Println("Ensuring system dependencies...")
+func (System) Deps() (err error) {
+	run := startTarget("system:deps")
+	defer func() { run.finish(err) }()
+
+	logInfo("Ensuring system dependencies...")
 
 	// Step 1: Verify required tools
 	verifyErr := (System{}).Verify()
 	if verifyErr != nil {
-		fmt.Println("Detected missing system tools. Attempting repair...")
+		logWarn("Detected missing system tools. Attempting repair...")
 		if err := installSystemTools(); err != nil {
 			return fmt.Errorf("failed to install system tools: %w", err)
 		}
@@ -46,19 +51,22 @@ func (System) Deps() error {
 
 	// Step 2: Always check permissions (even if Verify succeeded)
 	if permErr := (System{}).Permissions(); permErr != nil {
-		fmt.Println("Attempting to fix file permissions...")
+		logInfo("Attempting to fix file permissions...")
 		if fixErr := (System{}).FixPermissions(); fixErr != nil {
 			return fmt.Errorf("failed to fix file permissions: %w", fixErr)
 		}
 	}
 
 	// Step 3: Re-verify configuration
-	fmt.Println("Re-verifying system configuration...")
+	if dryRun() {
+		return nil
+	}
+	logInfo("Re-verifying system configuration...")
 	if err := (System{}).Verify(); err != nil {
 		return fmt.Errorf("system verification failed after repair: %w", err)
 	}
 
-	fmt.Println("System dependencies and configuration verified successfully.")
+	logInfo("System dependencies and configuration verified successfully.")
 	return nil
 }
 
@@ -66,7 +74,7 @@ func (System) Deps() error {
 func verifySystemTools() error {
 	required := []string{"curl", "git"}
 	for _, bin := range required {
-		if _, err := exec.LookPath(bin); err != nil {
+		if _, err := runner.LookPath(bin); err != nil {
 			return fmt.Errorf("%s not found in PATH", bin)
 		}
 	}
@@ -81,20 +89,21 @@ func installSystemTools() error {
 	}
 
 	for _, args := range cmds {
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-		if err := cmd.Run(); err != nil {
+		if err := runMutating(planRun, args[0], args[1:]...); err != nil {
 			return fmt.Errorf("failed running %v: %w", args, err)
 		}
 	}
 
-	fmt.Println("Base system tools installed successfully.")
+	logInfo("Base system tools installed successfully.")
 	return nil
 }
 
 // Permissions checks sensitive files for overly permissive modes.
 // It returns an error if any monitored file has insecure permissions.
-func (System) Permissions() error {
+func (System) Permissions() (err error) {
+	run := startTarget("system:permissions")
+	defer func() { run.finish(err) }()
+
 	files := []string{"Dockerfile", ".env", "magefiles/ghcr.go"}
 	insecure := false
 
@@ -106,7 +115,7 @@ func (System) Permissions() error {
 		mode := info.Mode().Perm()
 		if mode&0o022 != 0 {
 			insecure = true
-			fmt.Printf("Warning: %s has overly permissive permissions (%#o)\n", f, mode)
+			logWarn("%s has overly permissive permissions (%#o)", f, mode)
 		}
 	}
 
@@ -114,16 +123,17 @@ func (System) Permissions() error {
 		return fmt.Errorf("one or more files have insecure permissions")
 	}
 
-	fmt.Println("All monitored file permissions are secure.")
+	logInfo("All monitored file permissions are secure.")
 	return nil
 }
 
 // FixPermissions corrects overly permissive file modes for sensitive files.
 // It removes world and group write bits but preserves ownership and other flags.
-func (System) FixPermissions() error {
-	files := []string{"Dockerfile", ".env", "magefiles/ghcr.go"}
-	dryRun := os.Getenv("DRY_RUN") != ""
+func (System) FixPermissions() (err error) {
+	run := startTarget("system:fixPermissions")
+	defer func() { run.finish(err) }()
 
+	files := []string{"Dockerfile", ".env", "magefiles/ghcr.go"}
 	for _, f := range files {
 		info, err := os.Stat(f)
 		if err != nil {
@@ -136,18 +146,18 @@ func (System) FixPermissions() error {
 		}
 
 		newMode := mode &^ 0o022
-		if dryRun {
-			fmt.Printf("[dry-run] Would fix permissions for %s (%#o → %#o)\n", f, mode, newMode)
+		if dryRun() {
+			plan(planRun, "chmod %#o %s (was %#o)", newMode, f, mode)
 			continue
 		}
 
 		if err := os.Chmod(f, newMode); err != nil {
-			fmt.Printf("Failed to fix permissions for %s: %v\n", f, err)
+			logWarn("Failed to fix permissions for %s: %v", f, err)
 		} else {
-			fmt.Printf("Fixed permissions for %s (%#o → %#o)\n", f, mode, newMode)
+			logInfo("Fixed permissions for %s (%#o → %#o)", f, mode, newMode)
 		}
 	}
 
-	fmt.Println("Permission correction process completed.")
+	logInfo("Permission correction process completed.")
 	return nil
 }
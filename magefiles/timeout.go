@@ -0,0 +1,126 @@
+//go:build mage
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// commandWaitDelay is how long an interrupted command gets to exit after
+// SIGINT before it is killed. It stays under mage's 5s cleanup window.
+const commandWaitDelay = 3 * time.Second
+
+// rootCtx is cancelled by Ctrl-C or SIGTERM. Every external command and HTTP
+// call derives its context from it.
+var rootCtx context.Context
+
+func init() {
+	rootCtx, _ = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// StepError reports a command or HTTP call that hit its timeout or was interrupted.
+type StepError struct {
+	Step    string        // span label; outside a span, the timeout key or "http"
+	Op      string        // e.g. "docker pull factoriotools/factorio:2.0.72"
+	Timeout time.Duration // zero when interrupted
+	Err     error
+}
+
+func (e *StepError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("timed out after %s in step %s (%s)", e.Timeout, e.Step, e.Op)
+	}
+	return fmt.Sprintf("interrupted in step %s (%s)", e.Step, e.Op)
+}
+
+func (e *StepError) Unwrap() error { return e.Err }
+
+// stepTimeout returns the configured timeout for key. An unreadable config
+// falls back to the defaults; targets that load it report the problem.
+func stepTimeout(key string) time.Duration {
+	if cfg, err := projectConfig(); err == nil {
+		return cfg.Timeout(key)
+	}
+	return defaultTimeout(key)
+}
+
+// commandTimeoutKey picks the timeouts key that bounds c.
+func commandTimeoutKey(c Cmd) string {
+	args := strings.Join(c.Args, " ")
+	switch name := filepath.Base(c.Name); {
+	case name == "docker" && (strings.HasPrefix(args, "build ") || strings.HasPrefix(args, "buildx build ")):
+		return "build"
+	case name == "docker" && (strings.HasPrefix(args, "pull ") || strings.HasPrefix(args, "push ") || strings.HasPrefix(args, "save ")):
+		return "transfer"
+	case name == "trivy":
+		return "scan"
+	case name == "curl" && slices.Contains(c.Args, "-o"):
+		return "transfer"
+	case name == "curl":
+		return "api"
+	case name == "sudo" || name == "bash" || name == "apt-get":
+		return "install"
+	}
+	return "command"
+}
+
+// stepFailure converts a timeout or cancellation into a *StepError naming the
+// current step; other errors are returned unchanged.
+func stepFailure(err error, key string, timeout time.Duration, op string) error {
+	var netErr net.Error
+	timedOut := errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+	if !timedOut && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	step := currentStepLabel()
+	if step == "" {
+		step = key
+	}
+	se := &StepError{Step: step, Op: op, Err: err}
+	if timedOut && rootCtx.Err() == nil {
+		se.Timeout = timeout
+	}
+	return se
+}
+
+// runCommand runs c through the package runner under the timeout for its kind
+// of command. Ctrl-C sends the command SIGINT, then kills it after commandWaitDelay.
+func runCommand(c Cmd) error {
+	key := commandTimeoutKey(c)
+	timeout := stepTimeout(key)
+	ctx, cancel := context.WithTimeout(rootCtx, timeout)
+	defer cancel()
+
+	logDebug("$ %s", c)
+	err := runner.Run(ctx, c)
+	if err != nil && ctx.Err() != nil {
+		return stepFailure(ctx.Err(), key, timeout, c.short())
+	}
+	return err
+}
+
+// httpClient returns a client whose calls are bounded by the timeout for key.
+// Send requests with httpDo so that Ctrl-C cancels them.
+func httpClient(key string) *http.Client {
+	return &http.Client{Timeout: stepTimeout(key)}
+}
+
+// httpDo sends req under the root context, reporting a timeout or Ctrl-C as a *StepError.
+func httpDo(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req.WithContext(rootCtx))
+	if err != nil {
+		return nil, stepFailure(err, "http", client.Timeout, req.Method+" "+req.URL.Host+req.URL.Path)
+	}
+	return resp, nil
+}
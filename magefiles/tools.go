@@ -0,0 +1,533 @@
+//go:build mage
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/magefile/mage/mg"
+	"gopkg.in/yaml.v3"
+)
+
+// Tool lockfile and install locations. TOOLS_LOCK and TOOLS_BIN override them,
+// e.g. to install from fixtures served by a local file server.
+const (
+	defaultToolsLock = "tools.lock.yaml"
+	defaultToolsBin  = ".tools/bin"
+)
+
+// toolsLockHeader is written above the tool entries whenever the lockfile is rewritten.
+const toolsLockHeader = `# Pinned external tools, installed into ./.tools/bin by mage tools:install.
+# url, checksums and binary are templates over {{.Version}}, {{.OS}} and {{.Arch}},
+# where OS and Arch are GOOS/GOARCH mapped through the os and arch tables.
+# sha256 pins each platform's download. Installs fail without a pin for the host;
+# mage tools:pin <tool> (or all) records them from the release's checksums file for review.
+# docker, buildx, curl and git are system tools checked by System:Verify and Docker:Verify.
+`
+
+// toolPlatforms are the GOOS/GOARCH pairs Tools:Pin records checksums for.
+var toolPlatforms = []string{"darwin/amd64", "darwin/arm64", "linux/amd64", "linux/arm64"}
+
+// toolVersionPattern finds the version a tool reports, e.g. "Version: 0.65.0"
+// or "golangci-lint has version 2.4.0 built with go1.25.0".
+var toolVersionPattern = regexp.MustCompile(`(?i)\bversion:?\s+v?(\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?)`)
+
+// Tools namespace manages the project-local binaries pinned in tools.lock.yaml.
+type Tools mg.Namespace
+
+// ToolLock is one tool's entry in tools.lock.yaml.
+type ToolLock struct {
+	Version   string            `yaml:"version"`
+	URL       string            `yaml:"url"`
+	Checksums string            `yaml:"checksums,omitempty"` // published checksums file, "<hex>  <file>" per line
+	Binary    string            `yaml:"binary,omitempty"`    // path inside a .tar.gz; empty when url is the binary itself
+	Latest    string            `yaml:"latest,omitempty"`    // GitHub "latest release" API URL, for Tools:Outdated
+	OS        map[string]string `yaml:"os,omitempty"`
+	Arch      map[string]string `yaml:"arch,omitempty"`
+	SHA256    map[string]string `yaml:"sha256"` // "GOOS/GOARCH" → hex digest of the download
+}
+
+// toolsLockFile is the content of tools.lock.yaml.
+type toolsLockFile struct {
+	Tools map[string]*ToolLock `yaml:"tools"`
+}
+
+// toolTemplateData is what url, checksums and binary templates expand with.
+type toolTemplateData struct {
+	Version, OS, Arch string
+}
+
+// toolsLockPath returns the lockfile in use.
+func toolsLockPath() string {
+	if p := os.Getenv("TOOLS_LOCK"); p != "" {
+		return p
+	}
+	return defaultToolsLock
+}
+
+// toolsBinDir returns the directory tools are installed into.
+func toolsBinDir() string {
+	if d := os.Getenv("TOOLS_BIN"); d != "" {
+		return d
+	}
+	return defaultToolsBin
+}
+
+// hostPlatform is the GOOS/GOARCH key used in sha256 tables.
+func hostPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// loadToolsLock reads and validates the lockfile.
+func loadToolsLock() (*toolsLockFile, error) {
+	p := toolsLockPath()
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read tool lockfile: %v", err)
+	}
+	var lock toolsLockFile
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", p, err)
+	}
+	for name, t := range lock.Tools {
+		if t == nil || t.Version == "" || t.URL == "" {
+			return nil, fmt.Errorf("%s: tool %s needs a version and url", p, name)
+		}
+		if t.SHA256 == nil {
+			t.SHA256 = map[string]string{}
+		}
+	}
+	return &lock, nil
+}
+
+// writeToolsLock rewrites the lockfile, e.g. after pinning a new checksum.
+func writeToolsLock(lock *toolsLockFile) error {
+	var buf bytes.Buffer
+	buf.WriteString(toolsLockHeader)
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(lock); err != nil {
+		return fmt.Errorf("failed to encode tool lockfile: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to encode tool lockfile: %v", err)
+	}
+	return writeFile(toolsLockPath(), buf.Bytes(), 0o644)
+}
+
+// expand renders one of the tool's templates for the host platform.
+func (t *ToolLock) expand(tmpl string) (string, error) {
+	return t.expandFor(tmpl, hostPlatform())
+}
+
+// expandFor renders one of the tool's templates for a GOOS/GOARCH platform.
+func (t *ToolLock) expandFor(tmpl, platform string) (string, error) {
+	goos, goarch, _ := strings.Cut(platform, "/")
+	data := toolTemplateData{Version: t.Version, OS: goos, Arch: goarch}
+	if v, ok := t.OS[goos]; ok {
+		data.OS = v
+	}
+	if v, ok := t.Arch[goarch]; ok {
+		data.Arch = v
+	}
+	tp, err := template.New("tool").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("bad template %q: %v", tmpl, err)
+	}
+	var b strings.Builder
+	if err := tp.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("bad template %q: %v", tmpl, err)
+	}
+	return b.String(), nil
+}
+
+// Install downloads every tool in the lockfile that is missing from
+// .tools/bin or at the wrong version, verifying each download's SHA256.
+func (Tools) Install() (err error) {
+	run := startTarget("tools:install")
+	defer func() { run.finish(err) }()
+
+	lock, err := loadToolsLock()
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedToolNames(lock) {
+		if err := verifyTool(lock, name); err == nil {
+			logInfo("✅ %s %s already installed", name, lock.Tools[name].Version)
+			continue
+		}
+		if err := installTool(lock, name); err != nil {
+			return fmt.Errorf("failed to install %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Pin records the SHA256 of tool's download for every supported platform in
+// the lockfile, taken from the checksums file published with its release;
+// "all" pins every tool. Run it after changing a version, then review and
+// commit the lockfile.
+func (Tools) Pin(tool string) (err error) {
+	run := startTarget("tools:pin")
+	defer func() { run.finish(err) }()
+
+	lock, err := loadToolsLock()
+	if err != nil {
+		return err
+	}
+	names := []string{tool}
+	if tool == "all" {
+		names = names[:0]
+		for name := range lock.Tools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if err := pinTool(lock, name); err != nil {
+			return err
+		}
+	}
+	if dryRun() {
+		return nil
+	}
+	if err := writeToolsLock(lock); err != nil {
+		return err
+	}
+	logInfo("📌 %s pinned in %s; review and commit it.", strings.Join(names, ", "), toolsLockPath())
+	return nil
+}
+
+// pinTool fills in lock's sha256 table for name from its release checksums.
+func pinTool(lock *toolsLockFile, name string) error {
+	t, ok := lock.Tools[name]
+	if !ok {
+		return fmt.Errorf("%s is not in %s", name, toolsLockPath())
+	}
+	if t.Checksums == "" {
+		return fmt.Errorf("%s has no checksums url in %s; pin its sha256 by hand", name, toolsLockPath())
+	}
+	checksumsURL, err := t.expand(t.Checksums)
+	if err != nil {
+		return err
+	}
+	if dryRun() {
+		plan(planWrite, "%s (sha256 of %s %s from %s)", toolsLockPath(), name, t.Version, checksumsURL)
+		return nil
+	}
+	sums, err := httpGetBytes(httpClient("api"), checksumsURL)
+	if err != nil {
+		return err
+	}
+	pins := make(map[string]string, len(toolPlatforms))
+	for _, platform := range toolPlatforms {
+		url, err := t.expandFor(t.URL, platform)
+		if err != nil {
+			return err
+		}
+		if pins[platform], err = checksumFor(sums, path.Base(url)); err != nil {
+			return fmt.Errorf("%s %s for %s: %v", name, t.Version, platform, err)
+		}
+		logInfo("📌 %-13s %s  %s", platform, pins[platform], path.Base(url))
+	}
+	t.SHA256 = pins
+	return nil
+}
+
+// Verify checks that every tool in the lockfile is installed in .tools/bin
+// at its pinned version.
+func (Tools) Verify() (err error) {
+	run := startTarget("tools:verify")
+	defer func() { run.finish(err) }()
+
+	lock, err := loadToolsLock()
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, name := range sortedToolNames(lock) {
+		if err := verifyTool(lock, name); err != nil {
+			logError("%-15s %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		logInfo("✅ %-15s %s", name, lock.Tools[name].Version)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("tools not installed at their pinned version: %s (run mage tools:install)", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Outdated reports tools whose latest upstream release is newer than the pinned version.
+func (Tools) Outdated() (err error) {
+	run := startTarget("tools:outdated")
+	defer func() { run.finish(err) }()
+
+	lock, err := loadToolsLock()
+	if err != nil {
+		return err
+	}
+	client := httpClient("api")
+	fmt.Printf("  %-15s %-10s %s\n", "TOOL", "PINNED", "LATEST")
+	for _, name := range sortedToolNames(lock) {
+		t := lock.Tools[name]
+		if t.Latest == "" {
+			fmt.Printf("  %-15s %-10s %s\n", name, t.Version, "(no latest url)")
+			continue
+		}
+		latest, err := latestToolRelease(client, t.Latest)
+		if err != nil {
+			fmt.Printf("  %-15s %-10s (%v)\n", name, t.Version, err)
+			continue
+		}
+		note := ""
+		cur, ok1 := parseSemver(t.Version)
+		lat, ok2 := parseSemver(latest)
+		if ok1 && ok2 && lat.compare(cur) > 0 {
+			note = " ⬆️  update available"
+		}
+		fmt.Printf("  %-15s %-10s %s%s\n", name, t.Version, latest, note)
+	}
+	return nil
+}
+
+// sortedToolNames returns the lockfile's tool names in ascending order.
+func sortedToolNames(lock *toolsLockFile) []string {
+	names := make(map[string]string, len(lock.Tools))
+	for name := range lock.Tools {
+		names[name] = name
+	}
+	return sortedKeys(names)
+}
+
+// latestToolRelease returns the tag of a GitHub "latest release" API response.
+func latestToolRelease(client *http.Client, url string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := httpDo(client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", fmt.Errorf("failed to parse release from %s: %v", url, err)
+	}
+	return strings.TrimPrefix(release.TagName, "v"), nil
+}
+
+// toolBinary returns the executable for name, preferring .tools/bin over PATH.
+func toolBinary(name string) (string, error) {
+	local := filepath.Join(toolsBinDir(), name)
+	if _, err := os.Stat(local); err == nil {
+		return local, nil
+	}
+	if p, err := runner.LookPath(name); err == nil {
+		return p, nil
+	}
+	return "", fmt.Errorf("%s not found in %s or PATH", name, toolsBinDir())
+}
+
+// toolVersionOutput runs the tool's version command; tools disagree on
+// whether that is --version or a version subcommand.
+func toolVersionOutput(bin string) string {
+	var out []byte
+	for _, args := range [][]string{{"--version"}, {"version"}} {
+		o, err := cmdCombinedOutput(bin, args...)
+		if err == nil {
+			return string(o)
+		}
+		out = append(out, o...)
+	}
+	return string(out)
+}
+
+// reportedToolVersion returns the version in a tool's version output.
+func reportedToolVersion(out string) (string, bool) {
+	m := toolVersionPattern.FindStringSubmatch(out)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// verifyTool checks that name is installed in .tools/bin and reports exactly the pinned version.
+func verifyTool(lock *toolsLockFile, name string) error {
+	t, ok := lock.Tools[name]
+	if !ok {
+		return fmt.Errorf("%s is not in %s", name, toolsLockPath())
+	}
+	bin := filepath.Join(toolsBinDir(), name)
+	if _, err := os.Stat(bin); err != nil {
+		return fmt.Errorf("%s not installed in %s", name, toolsBinDir())
+	}
+	out := toolVersionOutput(bin)
+	got, ok := reportedToolVersion(out)
+	if !ok {
+		first := strings.TrimSpace(strings.SplitN(out, "\n", 2)[0])
+		return fmt.Errorf("unable to determine %s version from %q", name, first)
+	}
+	if got != strings.TrimPrefix(t.Version, "v") {
+		return fmt.Errorf("%s version mismatch: expected %s, got %s", name, t.Version, got)
+	}
+	return nil
+}
+
+// installPinnedTool installs name from the lockfile.
+func installPinnedTool(name string) error {
+	lock, err := loadToolsLock()
+	if err != nil {
+		return err
+	}
+	return installTool(lock, name)
+}
+
+// installTool downloads name for the host platform, verifies it against the
+// SHA256 pinned in the lockfile and installs the binary into .tools/bin. A
+// platform without a pin is an error: checksums are only ever taken from the
+// committed lockfile, never from the server that serves the download.
+func installTool(lock *toolsLockFile, name string) error {
+	t, ok := lock.Tools[name]
+	if !ok {
+		return fmt.Errorf("%s is not in %s", name, toolsLockPath())
+	}
+	url, err := t.expand(t.URL)
+	if err != nil {
+		return err
+	}
+	platform := hostPlatform()
+	want := strings.ToLower(t.SHA256[platform])
+	if want == "" {
+		return fmt.Errorf("no sha256 pinned for %s %s on %s in %s; run mage tools:pin %s and commit the result",
+			name, t.Version, platform, toolsLockPath(), name)
+	}
+	if dryRun() {
+		plan(planWrite, "%s (%s %s from %s)", filepath.Join(toolsBinDir(), name), name, t.Version, url)
+		return nil
+	}
+	logInfo("⬇️  Downloading %s %s for %s...", name, t.Version, platform)
+
+	data, err := httpGetBytes(httpClient("transfer"), url)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	got := hex.EncodeToString(sum[:])
+	if got != want {
+		return fmt.Errorf("checksum verification failed for %s: got %s, want %s", url, got, want)
+	}
+	logInfo("🔐 sha256 verified: %s", got)
+
+	binary := data
+	if t.Binary != "" {
+		inner, err := t.expand(t.Binary)
+		if err != nil {
+			return err
+		}
+		if binary, err = extractTarGzFile(data, inner); err != nil {
+			return fmt.Errorf("%s: %v", url, err)
+		}
+	}
+
+	dir := toolsBinDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	// Write next to the target and rename so a failed install never leaves a partial binary.
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, binary, 0o755); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to install %s: %v", name, err)
+	}
+
+	if err := verifyTool(lock, name); err != nil {
+		return err
+	}
+	logInfo("✅ %s %s installed → %s", name, t.Version, filepath.Join(dir, name))
+	return nil
+}
+
+// httpGetBytes downloads url into memory.
+func httpGetBytes(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpDo(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	return data, nil
+}
+
+// checksumFor returns the hex digest for name from a "<hex>  <name>" checksums
+// file, as published with GitHub releases.
+func checksumFor(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum published for %s", name)
+}
+
+// extractTarGzFile returns the contents of the regular file at name in a .tar.gz archive.
+func extractTarGzFile(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("not a gzip archive: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("archive has no %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Clean(hdr.Name) == path.Clean(name) {
+			return io.ReadAll(tr)
+		}
+	}
+}
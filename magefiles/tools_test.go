@@ -0,0 +1,201 @@
+//go:build mage
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// toolFixture serves the host's release archive of a fake "demo" tool and a
+// checksums file listing every platform in toolPlatforms from a test server.
+type toolFixture struct {
+	srv     *httptest.Server
+	archive []byte
+	sums    map[string]string // platform → sha256 of its archive
+	bin     string            // TOOLS_BIN
+}
+
+func newToolFixture(t *testing.T) *toolFixture {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	body := []byte("#!/bin/sh\necho demo\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "demo-1.2.3/demo", Mode: 0o755, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &toolFixture{archive: buf.Bytes(), sums: map[string]string{}, bin: t.TempDir()}
+	var checksums strings.Builder
+	hostSum := sha256.Sum256(f.archive)
+	f.sums[hostPlatform()] = hex.EncodeToString(hostSum[:])
+	for _, platform := range toolPlatforms {
+		if platform != hostPlatform() {
+			// Only the host archive is served; the others just need distinct sums.
+			sum := sha256.Sum256([]byte(platform))
+			f.sums[platform] = hex.EncodeToString(sum[:])
+		}
+		fmt.Fprintf(&checksums, "%s  demo-1.2.3-%s.tar.gz\n", f.sums[platform], strings.Replace(platform, "/", "-", 1))
+	}
+
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/demo-1.2.3-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH):
+			w.Write(f.archive)
+		case "/checksums.txt":
+			fmt.Fprint(w, checksums.String())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.srv.Close)
+	t.Setenv("TOOLS_BIN", f.bin)
+	return f
+}
+
+// writeLock writes a lockfile for demo with pin as the host's sha256 (none
+// when empty) and points TOOLS_LOCK at it.
+func (f *toolFixture) writeLock(t *testing.T, pin string) string {
+	t.Helper()
+	pins := "{}"
+	if pin != "" {
+		pins = fmt.Sprintf("\n      %s: %s", hostPlatform(), pin)
+	}
+	lock := fmt.Sprintf(`tools:
+  demo:
+    version: 1.2.3
+    url: %[1]s/demo-{{.Version}}-{{.OS}}-{{.Arch}}.tar.gz
+    checksums: %[1]s/checksums.txt
+    binary: demo-{{.Version}}/demo
+    sha256: %[2]s
+`, f.srv.URL, pins)
+	path := filepath.Join(t.TempDir(), "tools.lock.yaml")
+	if err := os.WriteFile(path, []byte(lock), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOOLS_LOCK", path)
+	return path
+}
+
+func TestInstallTool(t *testing.T) {
+	tests := []struct {
+		name    string
+		pin     string // "" for none, "host" for the archive's real sha256
+		version string // what the installed binary reports
+		wantErr string
+	}{
+		{"pinned", "host", "demo version 1.2.3 (abc)", ""},
+		{"checksum mismatch", strings.Repeat("0", 64), "demo version 1.2.3", "checksum verification failed"},
+		{"no pin", "", "demo version 1.2.3", "no sha256 pinned for demo 1.2.3"},
+		{"newer patch", "host", "demo version 1.2.30", "version mismatch: expected 1.2.3, got 1.2.30"},
+		{"prerelease", "host", "Version: v1.2.3-rc.1", "version mismatch: expected 1.2.3, got 1.2.3-rc.1"},
+		{"no version", "host", "demo (devel)", "unable to determine demo version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newToolFixture(t)
+			pin := tt.pin
+			if pin == "host" {
+				pin = f.sums[hostPlatform()]
+			}
+			lockPath := f.writeLock(t, pin)
+			before, _ := os.ReadFile(lockPath)
+			fake := &FakeRunner{Responses: []FakeResponse{
+				{Match: filepath.Join(f.bin, "demo") + " --version", Stdout: tt.version},
+			}}
+			defer useRunner(fake)()
+
+			var err error
+			captureStdout(t, func() { err = installPinnedTool("demo") })
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("install: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("install error = %v, want %q", err, tt.wantErr)
+			}
+
+			_, statErr := os.Stat(filepath.Join(f.bin, "demo"))
+			downloaded := tt.pin == "host"
+			if downloaded != (statErr == nil) {
+				t.Errorf("binary installed = %v, want %v", statErr == nil, downloaded)
+			}
+			if after, _ := os.ReadFile(lockPath); !bytes.Equal(before, after) {
+				t.Errorf("install rewrote the lockfile:\n%s", after)
+			}
+		})
+	}
+}
+
+func TestToolsPin(t *testing.T) {
+	f := newToolFixture(t)
+	f.writeLock(t, "")
+
+	var err error
+	captureStdout(t, func() { err = (Tools{}).Pin("demo") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := loadToolsLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := lock.Tools["demo"].SHA256
+	if len(got) != len(toolPlatforms) {
+		t.Errorf("pinned %d platforms, want %d: %v", len(got), len(toolPlatforms), got)
+	}
+	for _, platform := range toolPlatforms {
+		if got[platform] != f.sums[platform] {
+			t.Errorf("%s pinned %s, want %s", platform, got[platform], f.sums[platform])
+		}
+	}
+}
+
+func TestToolsPinAll(t *testing.T) {
+	f := newToolFixture(t)
+	lockPath := f.writeLock(t, "")
+	lock, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second tool released from the same fixture.
+	second := strings.Replace(string(lock[len("tools:\n"):]), "  demo:", "  other:", 1)
+	if err := os.WriteFile(lockPath, append(lock, second...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	captureStdout(t, func() { err = (Tools{}).Pin("all") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadToolsLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"demo", "other"} {
+		if got := loaded.Tools[name].SHA256; len(got) != len(toolPlatforms) || got[hostPlatform()] != f.sums[hostPlatform()] {
+			t.Errorf("%s pinned %v, want every platform", name, got)
+		}
+	}
+}
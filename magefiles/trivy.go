@@ -5,164 +5,143 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/exec"
-	"strings"
 
 	"github.com/magefile/mage/mg"
-	"github.com/magefile/mage/sh"
 )
 
 // Trivy namespace handles installation and execution of the Trivy vulnerability scanner.
 type Trivy mg.Namespace
 
-// Verify checks that Trivy is installed and available in PATH.
-func (Trivy) Verify() error {
-	fmt.Println("Verifying Trivy installation...")
+// Verify checks that Trivy is installed and matches the version pinned in tools.lock.yaml.
+func (Trivy) Verify() (err error) {
+	run := startTarget("trivy:verify")
+	defer func() { run.finish(err) }()
+
+	logInfo("Verifying Trivy installation...")
 	if err := verifyTrivy(); err != nil {
 		return err
 	}
-	fmt.Println("Trivy is correctly installed and available.")
+	logInfo("Trivy is correctly installed and available.")
 	return nil
 }
 
-// Deps ensures that Trivy is installed, installing it if necessary.
-func (Trivy) Deps() error {
-	fmt.Println("Ensuring Trivy dependencies...")
+// Deps ensures that Trivy is installed, installing the pinned release into
+// .tools/bin if necessary.
+func (Trivy) Deps() (err error) {
+	run := startTarget("trivy:deps")
+	defer func() { run.finish(err) }()
+
+	logInfo("Ensuring Trivy dependencies...")
 
 	if err := (Trivy{}).Verify(); err == nil {
-		fmt.Println("Trivy is already installed.")
+		logInfo("Trivy is already installed.")
 		return nil
 	}
 
-	fmt.Println("Installing Trivy vulnerability scanner...")
-	if err := installTrivy(); err != nil {
+	if err := installPinnedTool("trivy"); err != nil {
 		return fmt.Errorf("failed to install Trivy: %w", err)
 	}
 
-	fmt.Println("Re-verifying Trivy installation...")
+	if dryRun() {
+		return nil
+	}
+	logInfo("Re-verifying Trivy installation...")
 	if err := (Trivy{}).Verify(); err != nil {
 		return fmt.Errorf("Trivy installation did not verify successfully: %w", err)
 	}
 
-	fmt.Println("Trivy successfully installed and verified.")
+	logInfo("Trivy successfully installed and verified.")
 	return nil
 }
 
-// ImageScan runs a vulnerability scan on a specified container image using Trivy.
-// It automatically detects local vs. remote images and avoids network pulls for local tags.
-func (Trivy) ImageScan() error {
+// ImageScan runs the vulnerability scan on the image named by IMAGE and
+// evaluates it against policies/trivy/policy.yaml, writing the raw Trivy JSON
+// and the evaluation under builddata/.
+func (Trivy) ImageScan() (err error) {
+	run := startTarget("trivy:imageScan")
+	defer func() { run.finish(err) }()
+
 	image := os.Getenv("IMAGE")
 	if image == "" {
 		return fmt.Errorf("IMAGE not provided (use os.Setenv or mage var)")
 	}
+	_, err = scanImageVulns(image, scanDockerfile(), buildDataDir)
+	return err
+}
 
-	fmt.Printf("🔍 Scanning image %s for vulnerabilities...\n", image)
-
-	args := []string{
-		"image", "--severity", "CRITICAL,HIGH",
-		"--exit-code", "1", "--quiet",
-		"--ignore-unfixed",
-		"--scanners", "vuln",
-		image,
-	}
-
-	// Disable automatic pull if scanning local builds
-	if !strings.Contains(image, "ghcr.io") {
-		args = append(args, "--skip-update")
-	}
-
-	cmd := exec.Command("trivy", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Trivy scan failed for %s: %w", image, err)
+// runTrivy runs Trivy with args, streaming its output.
+func runTrivy(args ...string) error {
+	bin, err := toolBinary("trivy")
+	if err != nil {
+		return err
 	}
-
-	fmt.Println("✅ Trivy vulnerability scan completed successfully.")
-	return nil
+	return runCmd(bin, args...)
 }
 
-// verifyTrivy checks if Trivy is installed and available in PATH.
+// verifyTrivy checks that Trivy's download is pinned for this platform and
+// that the installed binary reports exactly the version in tools.lock.yaml.
 func verifyTrivy() error {
-	if _, err := exec.LookPath("trivy"); err != nil {
-		return fmt.Errorf("Trivy not found in PATH")
+	lock, err := loadToolsLock()
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-// installTrivy installs Aqua Security’s Trivy vulnerability scanner if not present.
-func installTrivy() error {
-	if _, err := exec.LookPath("trivy"); err == nil {
-		fmt.Println("Trivy is already installed.")
-		return nil
+	t, ok := lock.Tools["trivy"]
+	if !ok {
+		return fmt.Errorf("trivy is not in %s", toolsLockPath())
 	}
-
-	fmt.Println("Installing Trivy vulnerability scanner...")
-	return sh.RunV("sudo", "apt-get", "install", "-y", "trivy")
-}
-
-// ScanImage runs a Trivy scan on a given Docker image reference.
-// It fails the build if any *fixable* CRITICAL vulnerabilities are found.
-func (Trivy) ScanImage(image string) error {
-	fmt.Printf("Running Trivy vulnerability scan on image: %s\n", image)
-
-	if _, err := exec.LookPath("trivy"); err != nil {
-		fmt.Println("Trivy not found in PATH; skipping scan.")
-		return nil
+	// The scanner gates every release, so a version-only pin is not enough.
+	if t.SHA256[hostPlatform()] == "" {
+		return fmt.Errorf("trivy %s has no sha256 pinned for %s in %s; run mage tools:pin trivy and commit the result",
+			t.Version, hostPlatform(), toolsLockPath())
 	}
-
-	cmd := exec.Command(
-		"trivy", "image",
-		"--severity", "CRITICAL",
-		"--ignore-unfixed", // Only count fixable vulnerabilities
-		"--exit-code", "1", // Exit non-zero if vulnerabilities found
-		"--quiet",
-		image,
-	)
-
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("fixable critical vulnerabilities detected in image %s", image)
+	bin, err := toolBinary("trivy")
+	if err != nil {
+		return err
+	}
+	out, err := cmdOutput(bin, "--version")
+	if err != nil {
+		return fmt.Errorf("failed to run %s --version: %v", bin, err)
 	}
 
-	fmt.Println("Trivy scan passed (no fixable critical vulnerabilities).")
+	current, ok := reportedToolVersion(string(out))
+	if !ok {
+		return fmt.Errorf("unexpected output from '%s --version': %s", bin, string(out))
+	}
+	if current != t.Version {
+		return fmt.Errorf("Trivy version mismatch: found %s at %s, expected %s", current, bin, t.Version)
+	}
 	return nil
 }
 
-// Report generates a full JSON Trivy report for the given image,
-// including all severities and unfixed issues, for long-term auditing.
-func (Trivy) Report(image string) error {
-	fmt.Printf("Generating Trivy audit report for image: %s\n", image)
+// ScanImage runs the vulnerability scan on a given Docker image reference and
+// fails if it violates policies/trivy/policy.yaml. A missing Trivy is an
+// error; an image is never reported as scanned when it was not.
+func (Trivy) ScanImage(image string) (err error) {
+	run := startTarget("trivy:scanImage")
+	defer func() { run.finish(err) }()
 
-	if _, err := exec.LookPath("trivy"); err != nil {
-		return fmt.Errorf("Trivy not found in PATH; please install it to generate reports")
+	if _, err := toolBinary("trivy"); err != nil {
+		return fmt.Errorf("Trivy is required to scan %s: %v", image, err)
 	}
+	_, err = scanImageVulns(image, scanDockerfile(), buildDataDir)
+	return err
+}
 
-	// Ensure output directory exists
-	if err := os.MkdirAll("trivy", 0755); err != nil {
-		return fmt.Errorf("failed to create trivy report directory: %v", err)
-	}
-
-	reportPath := "trivy/report.json"
-
-	cmd := exec.Command(
-		"trivy", "image",
-		"--ignore-unfixed",
-		"--format", "json",
-		"--output", reportPath,
-		image,
-	)
+// Report generates a full JSON Trivy report for the given image at
+// trivy/report.json for long-term auditing, and its SARIF 2.1.0 form at
+// trivy/report.sarif for GitHub code scanning.
+func (Trivy) Report(image string) (err error) {
+	run := startTarget("trivy:report")
+	defer func() { run.finish(err) }()
 
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	logInfo("Generating Trivy audit report for image: %s", image)
 
-	if err := cmd.Run(); err != nil {
+	reportPath := "trivy/report.json"
+	report, err := runTrivyJSON(image, reportPath, "--ignore-unfixed")
+	if err != nil {
 		return fmt.Errorf("failed to generate Trivy report: %v", err)
 	}
+	logInfo("Full Trivy report generated at %s", reportPath)
 
-	fmt.Printf("Full Trivy report generated at %s\n", reportPath)
-	return nil
+	return writeSARIF(report, scanDockerfile(), "trivy/report.sarif")
 }
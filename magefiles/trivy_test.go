@@ -0,0 +1,56 @@
+//go:build mage
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyTrivy(t *testing.T) {
+	tests := []struct {
+		name    string
+		pinned  bool
+		version string
+		wantErr string
+	}{
+		{"pinned", true, "Version: 0.65.0\nVulnerability DB:\n  Version: 2\n", ""},
+		{"version only", false, "Version: 0.65.0\n", "no sha256 pinned for " + hostPlatform()},
+		{"newer patch", true, "Version: 0.65.01\n", "found 0.65.01"},
+		{"dev build", true, "Version: dev\n", "unexpected output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newToolFixture(t)
+			lock := "tools:\n  trivy:\n    version: 0.65.0\n    url: " + f.srv.URL + "/trivy.tar.gz\n    sha256: {}\n"
+			if tt.pinned {
+				lock = strings.Replace(lock, "{}", "\n      "+hostPlatform()+": "+strings.Repeat("a", 64), 1)
+			}
+			lockPath := filepath.Join(t.TempDir(), "tools.lock.yaml")
+			if err := os.WriteFile(lockPath, []byte(lock), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("TOOLS_LOCK", lockPath)
+			if err := os.WriteFile(filepath.Join(f.bin, "trivy"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			fake := &FakeRunner{Responses: []FakeResponse{
+				{Match: filepath.Join(f.bin, "trivy") + " --version", Stdout: tt.version},
+			}}
+			defer useRunner(fake)()
+
+			err := verifyTrivy()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyTrivy: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("verifyTrivy error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+//go:build mage
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trivy vulnerability DB locations. Scans use the project-local cache with
+// updates disabled, so the DB only changes through the Trivy:Db* targets.
+const (
+	trivyCacheDir   = ".cache/trivy"
+	trivyDBDir      = trivyCacheDir + "/db"
+	trivyDBPinFile  = buildDataDir + "/trivy-db.json"
+	defaultDBMaxAge = 72 * time.Hour
+)
+
+// trivyDBFiles are the files that make up the vulnerability DB.
+var trivyDBFiles = []string{"trivy.db", "metadata.json"}
+
+// trivyDBMetadata is db/metadata.json as written by Trivy.
+type trivyDBMetadata struct {
+	Version      int       `json:"Version"`
+	NextUpdate   time.Time `json:"NextUpdate"`
+	UpdatedAt    time.Time `json:"UpdatedAt"`
+	DownloadedAt time.Time `json:"DownloadedAt"`
+}
+
+// TrivyDBPin records the DB scans are expected to run against.
+type TrivyDBPin struct {
+	Version   int       `json:"Version"`
+	UpdatedAt time.Time `json:"UpdatedAt"` // when upstream built the DB
+	SHA256    string    `json:"SHA256"`    // digest of trivy.db
+	Source    string    `json:"Source"`    // "download" or the imported archive
+	PinnedAt  time.Time `json:"PinnedAt"`
+}
+
+// DbDownload downloads the current vulnerability DB into the project cache
+// and pins it.
+func (Trivy) DbDownload() (err error) {
+	run := startTarget("trivy:dbDownload")
+	defer func() { run.finish(err) }()
+
+	if err := verifyTrivy(); err != nil {
+		return err
+	}
+	if dryRun() {
+		plan(planWrite, "%s (download the current Trivy DB and pin it in %s)", trivyDBDir, trivyDBPinFile)
+		return nil
+	}
+	logInfo("⬇️  Downloading Trivy vulnerability DB into %s...", trivyCacheDir)
+	if err := runTrivy("image", "--cache-dir", trivyCacheDir, "--download-db-only"); err != nil {
+		return fmt.Errorf("failed to download Trivy DB: %v", err)
+	}
+	return pinTrivyDB("download")
+}
+
+// DbVerify checks that the cached DB is the pinned one and is not older than
+// maxDbAge from policies/trivy/policy.yaml.
+func (Trivy) DbVerify() (err error) {
+	run := startTarget("trivy:dbVerify")
+	defer func() { run.finish(err) }()
+
+	meta, err := checkTrivyDB()
+	if err != nil {
+		return err
+	}
+	logInfo("✅ Trivy DB v%d built %s (%s old) matches the pin.",
+		meta.Version, meta.UpdatedAt.Format(time.RFC3339), time.Since(meta.UpdatedAt).Round(time.Minute))
+	return nil
+}
+
+// DbExport writes the cached DB to a .tar.gz archive at path, for machines
+// without network access.
+func (Trivy) DbExport(path string) (err error) {
+	run := startTarget("trivy:dbExport")
+	defer func() { run.finish(err) }()
+
+	if _, err := checkTrivyDB(); err != nil {
+		return err
+	}
+	if dryRun() {
+		plan(planWrite, "%s (archive of %s)", path, trivyDBDir)
+		return nil
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	if err := writeTrivyDBArchive(out); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	// A failed close can leave a truncated archive behind.
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	logInfo("📦 Trivy DB exported → %s", path)
+	return nil
+}
+
+// writeTrivyDBArchive writes the cached DB files to w as a .tar.gz stream.
+func writeTrivyDBArchive(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range trivyDBFiles {
+		if err := addFileToTar(tw, filepath.Join(trivyDBDir, name), name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// DbImport replaces the cached DB with one exported by Trivy:DbExport and pins it.
+func (Trivy) DbImport(path string) (err error) {
+	run := startTarget("trivy:dbImport")
+	defer func() { run.finish(err) }()
+
+	if dryRun() {
+		// Validate the archive without extracting it anywhere.
+		meta, err := inspectTrivyDBArchive(path)
+		if err != nil {
+			return err
+		}
+		plan(planWrite, "%s (replace the cached DB with %s, built %s, and pin it in %s)",
+			trivyDBDir, path, meta.UpdatedAt.Format(time.RFC3339), trivyDBPinFile)
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %v", path, err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s is not a gzip archive: %v", path, err)
+	}
+
+	// Extract into a staging directory so a broken archive leaves the cache intact.
+	staging := trivyDBDir + ".import"
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("failed to clear %s: %v", staging, err)
+	}
+	defer os.RemoveAll(staging)
+	if err := extractTar(gz, staging); err != nil {
+		return fmt.Errorf("failed to extract %s: %v", path, err)
+	}
+	for _, name := range trivyDBFiles {
+		if _, err := os.Stat(filepath.Join(staging, name)); err != nil {
+			return fmt.Errorf("%s does not contain %s", path, name)
+		}
+	}
+	if _, err := readTrivyDBMetadata(filepath.Join(staging, "metadata.json")); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(trivyDBDir); err != nil {
+		return fmt.Errorf("failed to remove old DB: %v", err)
+	}
+	if err := os.Rename(staging, trivyDBDir); err != nil {
+		return fmt.Errorf("failed to install imported DB: %v", err)
+	}
+	logInfo("📥 Trivy DB imported from %s", path)
+	return pinTrivyDB(path)
+}
+
+// inspectTrivyDBArchive reads the archive at path as DbImport would, checking
+// that it holds every DB file and valid metadata, without writing anything.
+func inspectTrivyDBArchive(path string) (*trivyDBMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %v", path, err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a gzip archive: %v", path, err)
+	}
+
+	var meta *trivyDBMetadata
+	found := map[string]bool{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		found[name] = true
+		if name == "metadata.json" {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", path, err)
+			}
+			if meta, err = parseTrivyDBMetadata(data, path+":metadata.json"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range trivyDBFiles {
+		if !found[name] {
+			return nil, fmt.Errorf("%s does not contain %s", path, name)
+		}
+	}
+	return meta, nil
+}
+
+// pinTrivyDB records the cached DB as the one scans must use.
+func pinTrivyDB(source string) error {
+	meta, err := readTrivyDBMetadata(filepath.Join(trivyDBDir, "metadata.json"))
+	if err != nil {
+		return err
+	}
+	sum, err := fileSHA256(filepath.Join(trivyDBDir, "trivy.db"))
+	if err != nil {
+		return err
+	}
+	pin := TrivyDBPin{Version: meta.Version, UpdatedAt: meta.UpdatedAt, SHA256: sum, Source: source, PinnedAt: time.Now().UTC()}
+
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pin, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode DB pin: %v", err)
+	}
+	if err := writeFile(trivyDBPinFile, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write DB pin: %v", err)
+	}
+	logInfo("📌 Trivy DB built %s pinned (%s) → %s", meta.UpdatedAt.Format(time.RFC3339), sum, trivyDBPinFile)
+	return nil
+}
+
+// readTrivyDBMetadata parses a DB metadata.json.
+func readTrivyDBMetadata(path string) (*trivyDBMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Trivy DB metadata: %w", err)
+	}
+	return parseTrivyDBMetadata(data, path)
+}
+
+// parseTrivyDBMetadata parses the content of a metadata.json read from name.
+func parseTrivyDBMetadata(data []byte, name string) (*trivyDBMetadata, error) {
+	var meta trivyDBMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	if meta.UpdatedAt.IsZero() {
+		return nil, fmt.Errorf("%s has no UpdatedAt", name)
+	}
+	return &meta, nil
+}
+
+// checkTrivyDB fails unless the cached DB exists, matches the pin and is no
+// older than the policy's maxDbAge.
+func checkTrivyDB() (*trivyDBMetadata, error) {
+	meta, err := readTrivyDBMetadata(filepath.Join(trivyDBDir, "metadata.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no Trivy DB in %s; run mage trivy:dbDownload or trivy:dbImport", trivyCacheDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(trivyDBPinFile)
+	if err != nil {
+		return nil, fmt.Errorf("Trivy DB is not pinned (%v); run mage trivy:dbDownload or trivy:dbImport", err)
+	}
+	var pin TrivyDBPin
+	if err := json.Unmarshal(data, &pin); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", trivyDBPinFile, err)
+	}
+	sum, err := fileSHA256(filepath.Join(trivyDBDir, "trivy.db"))
+	if err != nil {
+		return nil, err
+	}
+	if sum != pin.SHA256 {
+		return nil, fmt.Errorf("cached Trivy DB (%s) is not the pinned one (%s from %s)", sum, pin.SHA256, pin.Source)
+	}
+
+	policy, err := loadVulnPolicy(vulnPolicyPath)
+	if err != nil {
+		return nil, err
+	}
+	maxAge := defaultDBMaxAge
+	if policy.maxDBAge > 0 {
+		maxAge = policy.maxDBAge
+	}
+	if age := time.Since(meta.UpdatedAt); age > maxAge {
+		return nil, fmt.Errorf("Trivy DB was built %s (%s ago), older than the %s limit; run mage trivy:dbDownload",
+			meta.UpdatedAt.Format(time.RFC3339), age.Round(time.Hour), maxAge)
+	}
+	return meta, nil
+}
+
+// parseAge parses a Go duration, also accepting whole days such as "3d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// addFileToTar writes the file at path into tw as name.
+func addFileToTar(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %v", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat %s: %v", path, err)
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to archive %s: %v", path, err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to archive %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+//go:build mage
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTrivyDB caches a fresh, pinned fake Trivy DB in the current directory.
+func writeTrivyDB(t *testing.T) {
+	t.Helper()
+	if err := os.MkdirAll(trivyDBDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	db := filepath.Join(trivyDBDir, "trivy.db")
+	if err := os.WriteFile(db, []byte("fake db"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := json.Marshal(trivyDBMetadata{Version: 2, UpdatedAt: time.Now().Add(-time.Hour)})
+	if err := os.WriteFile(filepath.Join(trivyDBDir, "metadata.json"), meta, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileSHA256(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, _ := json.Marshal(TrivyDBPin{Version: 2, SHA256: sum, Source: "download"})
+	if err := os.MkdirAll(filepath.Dir(trivyDBPinFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(trivyDBPinFile, pin, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writePolicy writes body as the project's vulnerability policy.
+func writePolicy(t *testing.T, body string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(vulnPolicyPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(vulnPolicyPath, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckTrivyDB(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string // "" leaves the policy missing
+		wantErr string
+	}{
+		{"default age", "failOn: HIGH\n", ""},
+		{"policy age exceeded", "failOn: HIGH\nmaxDbAge: 30m\n", "older than the 30m0s limit"},
+		{"missing policy", "", "cannot read vulnerability policy"},
+		{"bad max age", "failOn: HIGH\nmaxDbAge: soon\n", "maxDbAge"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestProject(t)
+			writeTrivyDB(t)
+			if tt.policy != "" {
+				writePolicy(t, tt.policy)
+			}
+			_, err := checkTrivyDB()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkTrivyDB: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkTrivyDB error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTrivyScanRequiresTrivy(t *testing.T) {
+	newTestProject(t)
+	t.Setenv("TOOLS_BIN", t.TempDir())
+	fake := &FakeRunner{}
+	defer useRunner(fake)()
+
+	err := (Trivy{}).ScanImage("factorio-hardened:test")
+	if err == nil || !strings.Contains(err.Error(), "Trivy is required to scan factorio-hardened:test") {
+		t.Fatalf("trivy:scanImage without Trivy = %v, want an error", err)
+	}
+	err = trivyScan("factorio-hardened:test")
+	if err == nil || !strings.Contains(err.Error(), "Trivy is required") {
+		t.Fatalf("trivyScan without Trivy = %v, want an error", err)
+	}
+	if fake.Ran("docker") {
+		t.Errorf("ran commands after a failed scan: %v", fake.Calls())
+	}
+}
+
+func TestTrivyDbExportImport(t *testing.T) {
+	newTestProject(t)
+	writePolicy(t, "maxDbAge: 3d\n")
+	writeTrivyDB(t)
+	archive := filepath.Join(t.TempDir(), "trivy-db.tar.gz")
+
+	var err error
+	captureStdout(t, func() { err = (Trivy{}).DbExport(archive) })
+	if err != nil {
+		t.Fatalf("Trivy:DbExport: %v", err)
+	}
+	if err := os.RemoveAll(trivyDBDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run validates the archive without staging or installing it.
+	t.Setenv("DRY_RUN", "1")
+	before := snapshotTree(t)
+	out := captureStdout(t, func() { err = (Trivy{}).DbImport(archive) })
+	if err != nil {
+		t.Fatalf("Trivy:DbImport dry run: %v", err)
+	}
+	if !strings.Contains(out, "would write: "+trivyDBDir+" (replace the cached DB with "+archive) {
+		t.Errorf("import not planned:\n%s", out)
+	}
+	if after := snapshotTree(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("dry run import changed the tree: %v", after)
+	}
+	if _, err := os.Stat(trivyDBDir + ".import"); !os.IsNotExist(err) {
+		t.Errorf("dry run import staged the archive")
+	}
+
+	t.Setenv("DRY_RUN", "")
+	captureStdout(t, func() { err = (Trivy{}).DbImport(archive) })
+	if err != nil {
+		t.Fatalf("Trivy:DbImport: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(trivyDBDir, "trivy.db")); err != nil || string(data) != "fake db" {
+		t.Errorf("imported trivy.db = %q (%v)", data, err)
+	}
+	if _, err := checkTrivyDB(); err != nil {
+		t.Errorf("imported DB does not match its pin: %v", err)
+	}
+}
+
+func TestTrivyDbImportDryRunRejectsIncompleteArchive(t *testing.T) {
+	newTestProject(t)
+	t.Setenv("DRY_RUN", "1")
+	archive := filepath.Join(t.TempDir(), "trivy-db.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	meta, _ := json.Marshal(trivyDBMetadata{Version: 2, UpdatedAt: time.Now()})
+	tw.WriteHeader(&tar.Header{Name: "metadata.json", Mode: 0o644, Size: int64(len(meta)), Typeflag: tar.TypeReg})
+	tw.Write(meta)
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	captureStdout(t, func() { err = (Trivy{}).DbImport(archive) })
+	if err == nil || !strings.Contains(err.Error(), "does not contain trivy.db") {
+		t.Fatalf("Trivy:DbImport dry run = %v, want a missing trivy.db", err)
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 // Verify namespace coordinates non-mutating environment and dependency validation
-// across all namespaces (System, Go, Docker, Github, Lint, Trivy).
+// across all namespaces (System, Go, Docker, Github, Lint, Tools).
 // It performs comprehensive read-only checks to confirm the environment
 // is ready for secure, reproducible builds without altering host state.
 type Verify mg.Namespace
@@ -17,8 +17,10 @@ type Verify mg.Namespace
 // All runs all verification checks in sequence.
 // Unlike Deps.All, this does not install or modify anything.
 // It is intended for CI pipelines or post-installation validation.
-func (Verify) All() error {
-	fmt.Println("Running full environment verification for Factorio-Hardened...")
+func (Verify) All() (err error) {
+	run := startTarget("verify:all")
+	defer func() { run.finish(err) }()
+	logInfo("Running full environment verification for Factorio-Hardened...")
 
 	steps := []struct {
 		name string
@@ -28,26 +30,30 @@ func (Verify) All() error {
 		{"Go toolchain", func() error { return (Go{}).Verify() }},
 		{"Docker installation and authentication", func() error { return (Docker{}).Verify() }},
 		{"GolangCI-Lint installation", func() error { return (Lint{}).Verify() }},
-		{"Trivy vulnerability scanner", func() error { return (Trivy{}).Verify() }},
+		{"Pinned tools (tools.lock.yaml)", func() error { return (Tools{}).Verify() }},
 		{"GitHub authentication and token scopes", func() error { return (Github{}).ValidateAll() }},
 	}
 
 	for _, step := range steps {
-		fmt.Printf("Starting: %s...\n", step.name)
+		run.step(step.name)
+		logInfo("Starting: %s...", step.name)
 		if err := step.fn(); err != nil {
 			return fmt.Errorf("%s failed: %w", step.name, err)
 		}
-		fmt.Printf("Completed: %s verified successfully.\n\n", step.name)
+		logInfo("Completed: %s verified successfully.", step.name)
 	}
 
-	fmt.Println("All environment verification checks completed successfully.")
+	logInfo("All environment verification checks completed successfully.")
 	return nil
 }
 
 // Summary provides a high-level health report of the development environment.
 // It performs quick checks on each subsystem without detailed validation output.
-func (Verify) Summary() error {
-	fmt.Println("Factorio-Hardened Environment Summary")
+func (Verify) Summary() (err error) {
+	run := startTarget("verify:summary")
+	defer func() { run.finish(err) }()
+
+	logInfo("Factorio-Hardened Environment Summary")
 
 	systems := []struct {
 		name string
@@ -57,7 +63,7 @@ func (Verify) Summary() error {
 		{"Go", func() error { return (Go{}).Verify() }},
 		{"Docker", func() error { return (Docker{}).Verify() }},
 		{"Lint", func() error { return (Lint{}).Verify() }},
-		{"Trivy", func() error { return (Trivy{}).Verify() }},
+		{"Tools", func() error { return (Tools{}).Verify() }},
 		{"GitHub", func() error { return (Github{}).Verify() }},
 	}
 
@@ -76,7 +82,7 @@ func (Verify) Summary() error {
 
 	fmt.Println()
 	if allPassed {
-		fmt.Println("All systems are healthy and ready for builds.")
+		logInfo("All systems are healthy and ready for builds.")
 		return nil
 	}
 
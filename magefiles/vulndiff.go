@@ -0,0 +1,303 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// vulnDiffDir holds scans made for Trivy:Diff and the markdown summary.
+const vulnDiffDir = buildDataDir + "/vulndiff"
+
+// unsafeFileChars matches characters replaced when an image reference becomes a file name.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// vulnSource is one side of a vulnerability diff.
+type vulnSource struct {
+	Label  string // what the user passed, resolved to tag and digest where known
+	Base   string // upstream image the build was based on, if known
+	Report *TrivyReport
+}
+
+// vulnKey identifies a finding across reports.
+type vulnKey struct {
+	ID      string
+	Package string
+}
+
+// vulnDiff is the CVE-level difference between two scans.
+type vulnDiff struct {
+	Fixed     []VulnFinding // only in the older scan
+	New       []VulnFinding // only in the newer scan
+	Unchanged []VulnFinding // in both, as reported by the newer scan
+}
+
+// Diff compares the vulnerabilities of two builds and prints fixed, new and
+// unchanged CVEs by severity, writing a markdown summary for release notes to
+// builddata/vulndiff/<from>..<to>.md. Each argument may be "test" or "prod"
+// (the last build record of that env), a path to a saved builddata.json or a
+// Trivy JSON report, or an image reference. Records reuse their stored Trivy
+// report when present; anything else is scanned.
+func (Trivy) Diff(from, to string) (err error) {
+	run := startTarget("trivy:diff")
+	defer func() { run.finish(err) }()
+
+	older, err := loadVulnSource(from)
+	if err != nil {
+		return err
+	}
+	newer, err := loadVulnSource(to)
+	if err != nil {
+		return err
+	}
+
+	d := diffVulns(older.Report, newer.Report)
+	d.print(older, newer)
+
+	if err := makeDirs(vulnDiffDir); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s..%s.md", safeFileName(from), safeFileName(to))
+	out := filepath.Join(vulnDiffDir, name)
+	if err := writeFile(out, []byte(d.markdown(older, newer)), 0o644); err != nil {
+		return fmt.Errorf("failed to write vulnerability diff: %v", err)
+	}
+	logInfo("🧾 Vulnerability diff written → %s", out)
+	return nil
+}
+
+// loadVulnSource resolves a Diff argument to a Trivy report, scanning if needed.
+func loadVulnSource(arg string) (*vulnSource, error) {
+	if arg == envTest || arg == envProd {
+		rec, err := readBuildRecord(arg)
+		if err != nil {
+			return nil, err
+		}
+		return vulnSourceFromRecord(arg, rec)
+	}
+
+	if data, err := os.ReadFile(arg); err == nil {
+		var probe struct {
+			SchemaVersion int    `json:"SchemaVersion"`
+			Env           string `json:"Env"`
+		}
+		if err := json.Unmarshal(data, &probe); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", arg, err)
+		}
+		switch {
+		case probe.SchemaVersion > 0:
+			report, err := readTrivyReport(arg)
+			if err != nil {
+				return nil, err
+			}
+			return &vulnSource{Label: report.ArtifactName, Report: report}, nil
+		case probe.Env != "":
+			var rec BuildRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return nil, fmt.Errorf("failed to parse build record %s: %v", arg, err)
+			}
+			return vulnSourceFromRecord(arg, &rec)
+		default:
+			return nil, fmt.Errorf("%s is neither a build record nor a Trivy JSON report", arg)
+		}
+	}
+
+	report, err := runTrivyJSON(arg, filepath.Join(vulnDiffDir, safeFileName(arg)+".trivy.json"))
+	if err != nil {
+		return nil, err
+	}
+	return &vulnSource{Label: arg, Report: report}, nil
+}
+
+// vulnSourceFromRecord loads the Trivy report stored with rec, or scans the
+// recorded image when the report is missing. A stored report of some other
+// image is an error rather than a silent substitute.
+func vulnSourceFromRecord(name string, rec *BuildRecord) (*vulnSource, error) {
+	src := &vulnSource{Label: rec.Tag, Base: rec.BaseImage}
+	if rec.Digest != "" {
+		src.Label = fmt.Sprintf("%s (%s)", rec.Tag, rec.Digest)
+	}
+
+	if rec.Scan != nil && rec.Scan.Report != "" {
+		if report, err := readTrivyReport(rec.Scan.Report); err == nil {
+			if err := reportMatchesRecord(report, rec); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			src.Report = report
+			return src, nil
+		}
+		logInfo("ℹ️  Stored Trivy report %s for %s is unavailable; rescanning.", rec.Scan.Report, name)
+	}
+
+	image, err := recordImage(rec)
+	if err != nil {
+		return nil, err
+	}
+	report, err := runTrivyJSON(image, filepath.Join(vulnDiffDir, safeFileName(image)+".trivy.json"))
+	if err != nil {
+		return nil, err
+	}
+	src.Report = report
+	return src, nil
+}
+
+// reportMatchesRecord fails unless report is the scan recorded in rec: it must
+// name the scanned image and carry the recorded image ID or, for records that
+// predate ImageID, the recorded digest.
+func reportMatchesRecord(report *TrivyReport, rec *BuildRecord) error {
+	path := rec.Scan.Report
+	if report.ArtifactName != rec.Scan.Image {
+		return fmt.Errorf("Trivy report %s is for %s, not the scanned %s", path, report.ArtifactName, rec.Scan.Image)
+	}
+	if rec.Scan.ImageID != "" {
+		if report.Metadata.ImageID != rec.Scan.ImageID {
+			return fmt.Errorf("Trivy report %s is for image %s, not the scanned %s", path, report.Metadata.ImageID, rec.Scan.ImageID)
+		}
+		return nil
+	}
+	_, want, _ := strings.Cut(rec.Digest, "@")
+	if want == "" {
+		want = rec.Digest
+	}
+	if want != "" && report.Metadata.ImageID == want {
+		return nil
+	}
+	for _, d := range report.Metadata.RepoDigests {
+		if _, got, _ := strings.Cut(d, "@"); want != "" && got == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("Trivy report %s does not match %s (%s); it may be from a later scan", path, rec.Tag, rec.Digest)
+}
+
+// safeFileName turns an argument into a file name component.
+func safeFileName(s string) string {
+	return strings.Trim(unsafeFileChars.ReplaceAllString(filepath.Base(s), "_"), "_")
+}
+
+// reportFindings flattens a Trivy report into findings keyed by CVE and package.
+func reportFindings(report *TrivyReport) map[vulnKey]VulnFinding {
+	findings := make(map[vulnKey]VulnFinding)
+	for _, r := range report.Results {
+		for _, v := range r.Vulnerabilities {
+			f := newVulnFinding(r, v)
+			findings[vulnKey{f.ID, f.Package}] = f
+		}
+	}
+	return findings
+}
+
+// diffVulns compares two reports by CVE and package.
+func diffVulns(older, newer *TrivyReport) *vulnDiff {
+	prev, cur := reportFindings(older), reportFindings(newer)
+	d := &vulnDiff{}
+	for k, f := range cur {
+		if _, ok := prev[k]; ok {
+			d.Unchanged = append(d.Unchanged, f)
+		} else {
+			d.New = append(d.New, f)
+		}
+	}
+	for k, f := range prev {
+		if _, ok := cur[k]; !ok {
+			d.Fixed = append(d.Fixed, f)
+		}
+	}
+	sortVulnFindings(d.Fixed)
+	sortVulnFindings(d.New)
+	sortVulnFindings(d.Unchanged)
+	return d
+}
+
+// bySeverity counts findings per severity.
+func bySeverity(fs []VulnFinding) map[string]int {
+	counts := make(map[string]int)
+	for _, f := range fs {
+		counts[f.Severity]++
+	}
+	return counts
+}
+
+// verdict summarises the direction of the diff in one word.
+func (d *vulnDiff) verdict() string {
+	switch {
+	case len(d.New) == 0 && len(d.Fixed) == 0:
+		return "unchanged"
+	case len(d.New) == 0:
+		return "better"
+	case len(d.Fixed) == 0:
+		return "worse"
+	default:
+		return "mixed"
+	}
+}
+
+// print writes per-severity counts and the new and fixed CVEs to the console.
+func (d *vulnDiff) print(older, newer *vulnSource) {
+	logInfo("🔁 Vulnerability diff: %s → %s (%s)", older.Label, newer.Label, d.verdict())
+	fixed, added, same := bySeverity(d.Fixed), bySeverity(d.New), bySeverity(d.Unchanged)
+	fmt.Printf("  %-9s %6s %6s %10s\n", "SEVERITY", "FIXED", "NEW", "UNCHANGED")
+	for _, s := range severityOrder {
+		fmt.Printf("  %-9s %6d %6d %10d\n", s, fixed[s], added[s], same[s])
+	}
+	for _, f := range d.New {
+		fmt.Printf("   + %-8s %s %s %s\n", f.Severity, f.ID, f.Package, f.Installed)
+	}
+	for _, f := range d.Fixed {
+		fmt.Printf("   - %-8s %s %s %s\n", f.Severity, f.ID, f.Package, f.Installed)
+	}
+}
+
+// markdown renders the diff as a release-notes section.
+func (d *vulnDiff) markdown(older, newer *vulnSource) string {
+	var b strings.Builder
+	b.WriteString("## Vulnerability changes\n\n")
+	fmt.Fprintf(&b, "- From: `%s`", older.Label)
+	if older.Base != "" {
+		fmt.Fprintf(&b, " (base `%s`)", older.Base)
+	}
+	fmt.Fprintf(&b, "\n- To: `%s`", newer.Label)
+	if newer.Base != "" {
+		fmt.Fprintf(&b, " (base `%s`)", newer.Base)
+	}
+	fmt.Fprintf(&b, "\n\nSecurity is **%s**: %d fixed, %d new, %d unchanged.\n\n",
+		d.verdict(), len(d.Fixed), len(d.New), len(d.Unchanged))
+
+	fixed, added, same := bySeverity(d.Fixed), bySeverity(d.New), bySeverity(d.Unchanged)
+	b.WriteString("| Severity | Fixed | New | Unchanged |\n|---|---:|---:|---:|\n")
+	for _, s := range severityOrder {
+		fmt.Fprintf(&b, "| %s | %d | %d | %d |\n", s, fixed[s], added[s], same[s])
+	}
+	b.WriteString("\n")
+
+	sections := []struct {
+		title    string
+		findings []VulnFinding
+	}{
+		{"New", d.New},
+		{"Fixed", d.Fixed},
+	}
+	for _, s := range sections {
+		if len(s.findings) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "### %s\n\n", s.title)
+		for _, f := range s.findings {
+			fmt.Fprintf(&b, "- **%s** `%s` in `%s`", f.Severity, f.ID, f.Package)
+			if f.Installed != "" {
+				fmt.Fprintf(&b, " %s", f.Installed)
+			}
+			if f.Fixed != "" {
+				fmt.Fprintf(&b, " (fixed in %s)", f.Fixed)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
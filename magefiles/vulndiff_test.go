@@ -0,0 +1,104 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testImageID = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	laterID     = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// writeTrivyReportFile writes a minimal Trivy JSON report for image to path.
+func writeTrivyReportFile(t *testing.T, path, image, imageID string, repoDigests ...string) {
+	t.Helper()
+	var report TrivyReport
+	report.SchemaVersion = 2
+	report.ArtifactName = image
+	report.ArtifactType = "container_image"
+	report.Metadata.ImageID = imageID
+	report.Metadata.RepoDigests = repoDigests
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeepTrivyReport(t *testing.T) {
+	dir := t.TempDir()
+	scratch := filepath.Join(dir, "trivy.json")
+	writeTrivyReportFile(t, scratch, localTestTag, testImageID)
+	report, err := readTrivyReport(scratch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := keepTrivyReport(scratch, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "trivy-"+strings.TrimPrefix(testImageID, "sha256:")+".json"); path != want {
+		t.Errorf("report kept at %s, want %s", path, want)
+	}
+	if _, err := os.Stat(scratch); !os.IsNotExist(err) {
+		t.Errorf("%s still exists after keeping the report", scratch)
+	}
+}
+
+func TestVulnSourceFromRecord(t *testing.T) {
+	const repoDigest = "ghcr.io/henryhall897/factorio-hardened@sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	tests := []struct {
+		name        string
+		scanImageID string // recorded ImageID; "" for records that predate it
+		digest      string // recorded image digest
+		reportImage string
+		reportID    string
+		repoDigests []string
+		wantErr     string
+	}{
+		{"matching image ID", testImageID, repoDigest, localTestTag, testImageID, nil, ""},
+		{"overwritten by a later scan", testImageID, repoDigest, localTestTag, laterID, nil, "not the scanned " + testImageID},
+		{"other image", testImageID, repoDigest, "factorio-hardened:other", testImageID, nil, "not the scanned " + localTestTag},
+		{"legacy record by repo digest", "", repoDigest, localTestTag, laterID, []string{repoDigest}, ""},
+		{"legacy record by image ID", "", testImageID, localTestTag, testImageID, nil, ""},
+		{"legacy record mismatch", "", repoDigest, localTestTag, laterID, nil, "may be from a later scan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trivy.json")
+			writeTrivyReportFile(t, path, tt.reportImage, tt.reportID, tt.repoDigests...)
+			rec := &BuildRecord{
+				Env:    envTest,
+				Tag:    localTestTag,
+				Digest: tt.digest,
+				Scan:   &ScanSummary{Scanner: "trivy", Image: localTestTag, ImageID: tt.scanImageID, Report: path},
+			}
+
+			src, err := vulnSourceFromRecord("record", rec)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("vulnSourceFromRecord: %v", err)
+				}
+				if src.Report == nil || src.Report.ArtifactName != localTestTag {
+					t.Errorf("loaded report %+v, want the stored one", src.Report)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("vulnSourceFromRecord error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
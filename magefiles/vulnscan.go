@@ -0,0 +1,427 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// vulnPolicyPath is the vulnerability policy every scan is evaluated against.
+const vulnPolicyPath = "policies/trivy/policy.yaml"
+
+// severityRank orders Trivy severities from least to most severe.
+var severityRank = map[string]int{
+	"UNKNOWN":  0,
+	"LOW":      1,
+	"MEDIUM":   2,
+	"HIGH":     3,
+	"CRITICAL": 4,
+}
+
+// severityOrder lists severities from most to least severe, for reporting.
+var severityOrder = []string{"CRITICAL", "HIGH", "MEDIUM", "LOW", "UNKNOWN"}
+
+// TrivyReport is the subset of `trivy image --format json` output we use.
+type TrivyReport struct {
+	SchemaVersion int    `json:"SchemaVersion"`
+	ArtifactName  string `json:"ArtifactName"`
+	ArtifactType  string `json:"ArtifactType"`
+	Metadata      struct {
+		ImageID     string         `json:"ImageID"`
+		DiffIDs     []string       `json:"DiffIDs"`
+		RepoTags    []string       `json:"RepoTags"`
+		RepoDigests []string       `json:"RepoDigests"`
+		ImageConfig ociImageConfig `json:"ImageConfig"`
+	} `json:"Metadata"`
+	Results []TrivyResult `json:"Results"`
+}
+
+// TrivyResult groups the findings of one scan target (OS packages, a lockfile...).
+type TrivyResult struct {
+	Target          string               `json:"Target"`
+	Class           string               `json:"Class"`
+	Type            string               `json:"Type"`
+	Vulnerabilities []TrivyVulnerability `json:"Vulnerabilities"`
+}
+
+// TrivyVulnerability is one vulnerable package reported by Trivy.
+type TrivyVulnerability struct {
+	VulnerabilityID  string `json:"VulnerabilityID"`
+	PkgName          string `json:"PkgName"`
+	PkgPath          string `json:"PkgPath,omitempty"`
+	InstalledVersion string `json:"InstalledVersion"`
+	FixedVersion     string `json:"FixedVersion,omitempty"`
+	Status           string `json:"Status,omitempty"`
+	Severity         string `json:"Severity"`
+	Title            string `json:"Title,omitempty"`
+	Description      string `json:"Description,omitempty"`
+	PrimaryURL       string `json:"PrimaryURL,omitempty"`
+	Layer            struct {
+		Digest string `json:"Digest,omitempty"`
+		DiffID string `json:"DiffID,omitempty"`
+	} `json:"Layer"`
+}
+
+// VulnPolicy is the content of policies/trivy/policy.yaml.
+type VulnPolicy struct {
+	FailOn      string       `yaml:"failOn"`
+	FixableOnly bool         `yaml:"fixableOnly"`
+	MaxDBAge    string       `yaml:"maxDbAge"` // e.g. "72h" or "3d"
+	Allowlist   []AllowEntry `yaml:"allowlist"`
+
+	maxDBAge time.Duration
+}
+
+// AllowEntry accepts one vulnerability, optionally only in one package, until it expires.
+type AllowEntry struct {
+	ID            string `yaml:"id"`
+	Package       string `yaml:"package,omitempty"`
+	Expires       string `yaml:"expires"`
+	Justification string `yaml:"justification"`
+
+	expiresAt time.Time // end of the Expires day, UTC
+}
+
+// VulnFinding is one vulnerability as evaluated against the policy.
+type VulnFinding struct {
+	ID        string `json:"ID"`
+	Package   string `json:"Package"`
+	Installed string `json:"Installed"`
+	Fixed     string `json:"Fixed,omitempty"`
+	Severity  string `json:"Severity"`
+	Target    string `json:"Target"`
+	Title     string `json:"Title,omitempty"`
+}
+
+// newVulnFinding flattens one Trivy vulnerability of result r.
+// Severities Trivy may add later are treated as UNKNOWN.
+func newVulnFinding(r TrivyResult, v TrivyVulnerability) VulnFinding {
+	f := VulnFinding{
+		ID:        v.VulnerabilityID,
+		Package:   v.PkgName,
+		Installed: v.InstalledVersion,
+		Fixed:     v.FixedVersion,
+		Severity:  strings.ToUpper(v.Severity),
+		Target:    r.Target,
+		Title:     v.Title,
+	}
+	if _, ok := severityRank[f.Severity]; !ok {
+		f.Severity = "UNKNOWN"
+	}
+	return f
+}
+
+// sortVulnFindings orders findings by severity (most severe first), ID and package.
+func sortVulnFindings(fs []VulnFinding) {
+	sort.Slice(fs, func(i, j int) bool {
+		if fs[i].Severity != fs[j].Severity {
+			return severityRank[fs[i].Severity] > severityRank[fs[j].Severity]
+		}
+		if fs[i].ID != fs[j].ID {
+			return fs[i].ID < fs[j].ID
+		}
+		return fs[i].Package < fs[j].Package
+	})
+}
+
+// loadVulnPolicy reads and validates the vulnerability policy at path.
+func loadVulnPolicy(path string) (*VulnPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vulnerability policy: %v", err)
+	}
+	// Unknown keys are errors, so a typo cannot silently drop a setting.
+	var policy VulnPolicy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	policy.FailOn = strings.ToUpper(strings.TrimSpace(policy.FailOn))
+	if policy.FailOn == "" {
+		policy.FailOn = "HIGH"
+	}
+	if _, ok := severityRank[policy.FailOn]; !ok {
+		return nil, fmt.Errorf("%s: unknown failOn severity %q", path, policy.FailOn)
+	}
+	if policy.MaxDBAge != "" {
+		if policy.maxDBAge, err = parseAge(policy.MaxDBAge); err != nil {
+			return nil, fmt.Errorf("%s: maxDbAge: %v", path, err)
+		}
+	}
+	for i := range policy.Allowlist {
+		e := &policy.Allowlist[i]
+		if e.ID == "" {
+			return nil, fmt.Errorf("%s: allowlist entry %d has no id", path, i+1)
+		}
+		if strings.TrimSpace(e.Justification) == "" {
+			return nil, fmt.Errorf("%s: allowlist entry %s has no justification", path, e.ID)
+		}
+		day, err := time.Parse("2006-01-02", e.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("%s: allowlist entry %s: expires must be YYYY-MM-DD: %v", path, e.ID, err)
+		}
+		e.expiresAt = day.AddDate(0, 0, 1)
+	}
+	return &policy, nil
+}
+
+// severities returns the severities at or above FailOn, most severe first.
+func (p *VulnPolicy) severities() []string {
+	var out []string
+	for _, s := range severityOrder {
+		if severityRank[s] >= severityRank[p.FailOn] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// allowed returns the unexpired allowlist entry covering f, if any.
+func (p *VulnPolicy) allowed(f VulnFinding, now time.Time) *AllowEntry {
+	for i := range p.Allowlist {
+		e := &p.Allowlist[i]
+		if e.ID == f.ID && (e.Package == "" || e.Package == f.Package) && now.Before(e.expiresAt) {
+			return e
+		}
+	}
+	return nil
+}
+
+// VulnScanResult is a parsed Trivy report evaluated against the policy.
+type VulnScanResult struct {
+	Image       string         `json:"Image"`
+	ImageID     string         `json:"ImageID,omitempty"` // image ID Trivy reported for Image
+	Report      string         `json:"Report"`            // path of the raw Trivy JSON
+	Policy      string         `json:"Policy"`
+	FailOn      string         `json:"FailOn"`
+	FixableOnly bool           `json:"FixableOnly"`
+	Counts      map[string]int `json:"Counts"` // all findings per severity
+	Blocking    []VulnFinding  `json:"Blocking"`
+	Allowed     []VulnFinding  `json:"Allowed"`
+	Expired     []string       `json:"Expired,omitempty"` // allowlist entries past their expiry
+	DBUpdatedAt time.Time      `json:"DBUpdatedAt"`       // build time of the vulnerability DB used
+	Passed      bool           `json:"Passed"`
+	ScannedAt   time.Time      `json:"ScannedAt"`
+}
+
+// evaluateVulns applies policy to report at time now.
+func evaluateVulns(report *TrivyReport, policy *VulnPolicy, now time.Time) *VulnScanResult {
+	res := &VulnScanResult{
+		FailOn:      policy.FailOn,
+		FixableOnly: policy.FixableOnly,
+		Counts:      make(map[string]int),
+		ScannedAt:   now.UTC(),
+	}
+	for _, r := range report.Results {
+		for _, v := range r.Vulnerabilities {
+			f := newVulnFinding(r, v)
+			res.Counts[f.Severity]++
+
+			if severityRank[f.Severity] < severityRank[policy.FailOn] || (policy.FixableOnly && f.Fixed == "") {
+				continue
+			}
+			if policy.allowed(f, now) != nil {
+				res.Allowed = append(res.Allowed, f)
+				continue
+			}
+			res.Blocking = append(res.Blocking, f)
+		}
+	}
+	for _, e := range policy.Allowlist {
+		if !now.Before(e.expiresAt) {
+			res.Expired = append(res.Expired, fmt.Sprintf("%s (expired %s)", e.ID, e.Expires))
+		}
+	}
+
+	sortVulnFindings(res.Blocking)
+	sortVulnFindings(res.Allowed)
+	res.Passed = len(res.Blocking) == 0
+	return res
+}
+
+// runTrivyJSON scans image with Trivy and writes its JSON report to output.
+// Severity filtering is left to the caller so every finding is recorded.
+// Scans run against the pinned project-local DB and fail if it is too old.
+func runTrivyJSON(image, output string, extra ...string) (*TrivyReport, error) {
+	if err := verifyTrivy(); err != nil {
+		return nil, err
+	}
+	if _, err := checkTrivyDB(); err != nil {
+		return nil, err
+	}
+	output, cleanup, err := scratchFile(output)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	args := append([]string{"image", "--quiet", "--cache-dir", trivyCacheDir, "--skip-db-update",
+		"--scanners", "vuln", "--format", "json", "--output", output}, extra...)
+	if err := runTrivy(append(args, image)...); err != nil {
+		return nil, fmt.Errorf("Trivy scan of %s failed to run: %v", image, err)
+	}
+	return readTrivyReport(output)
+}
+
+// readTrivyReport parses a Trivy JSON report.
+func readTrivyReport(path string) (*TrivyReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Trivy report: %v", err)
+	}
+	var report TrivyReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse Trivy report %s: %v", path, err)
+	}
+	return &report, nil
+}
+
+// scanImageVulns is the single scan runner: it scans image, keeps the raw
+// Trivy JSON in reportDir/trivy-<image ID>.json so later scans cannot replace
+// it, writes its SARIF form, located in dockerfile, to reportDir/trivy.sarif,
+// evaluates it against the vulnerability policy, writes the evaluation to
+// reportDir/vulns.json and prints a summary.
+// The returned error lists the blocking CVEs when the policy is not met.
+func scanImageVulns(image, dockerfile, reportDir string) (*VulnScanResult, error) {
+	policy, err := loadVulnPolicy(vulnPolicyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := ""
+	if policy.FixableOnly {
+		scope = ", fixable only"
+	}
+	logInfo("🔍 Scanning %s for vulnerabilities (fail on %s%s)...", image, policy.FailOn, scope)
+	scratch := filepath.Join(reportDir, "trivy.json")
+	report, err := runTrivyJSON(image, scratch)
+	if err != nil {
+		return nil, err
+	}
+	reportPath, err := keepTrivyReport(scratch, report)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeSARIF(report, dockerfile, filepath.Join(reportDir, "trivy.sarif")); err != nil {
+		return nil, err
+	}
+
+	res := evaluateVulns(report, policy, time.Now())
+	res.Image = image
+	res.ImageID = report.Metadata.ImageID
+	res.Report = reportPath
+	res.Policy = vulnPolicyPath
+	if meta, err := readTrivyDBMetadata(filepath.Join(trivyDBDir, "metadata.json")); err == nil {
+		res.DBUpdatedAt = meta.UpdatedAt
+	}
+	printVulnSummary(res)
+
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode scan result: %v", err)
+	}
+	resultPath := filepath.Join(reportDir, "vulns.json")
+	if err := writeFile(resultPath, append(data, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write scan result: %v", err)
+	}
+	logInfo("🧾 Scan result written → %s", resultPath)
+
+	if !res.Passed {
+		return res, fmt.Errorf("%d vulnerabilities in %s violate %s: %s",
+			len(res.Blocking), image, vulnPolicyPath, blockingSummary(res.Blocking))
+	}
+	logInfo("✅ Vulnerability policy satisfied.")
+	return res, nil
+}
+
+// keepTrivyReport moves the report just written to scratch to a path keyed by
+// the scanned image ID and returns that path. Reports without an ID stay put.
+func keepTrivyReport(scratch string, report *TrivyReport) (string, error) {
+	_, hex, ok := strings.Cut(report.Metadata.ImageID, ":")
+	if !ok || hex == "" {
+		return scratch, nil
+	}
+	path := filepath.Join(filepath.Dir(scratch), "trivy-"+hex+".json")
+	if dryRun() {
+		plan(planWrite, "%s (moved from %s)", path, scratch)
+		return path, nil
+	}
+	if err := os.Rename(scratch, path); err != nil {
+		return "", fmt.Errorf("failed to keep Trivy report: %v", err)
+	}
+	return path, nil
+}
+
+// printVulnSummary prints per-severity counts, blocking and allowlisted findings.
+func printVulnSummary(res *VulnScanResult) {
+	var counts []string
+	for _, s := range severityOrder {
+		counts = append(counts, fmt.Sprintf("%s %d", s, res.Counts[s]))
+	}
+	logInfo("   Findings: %s", strings.Join(counts, ", "))
+
+	printTable := func(title string, fs []VulnFinding) {
+		if len(fs) == 0 {
+			return
+		}
+		fmt.Println(title)
+		fmt.Printf("  %-9s %-20s %-24s %-20s %s\n", "SEVERITY", "ID", "PACKAGE", "INSTALLED", "FIXED")
+		for _, f := range fs {
+			fmt.Printf("  %-9s %-20s %-24s %-20s %s\n", f.Severity, f.ID, f.Package, f.Installed, f.Fixed)
+		}
+	}
+	printTable("❌ Blocking vulnerabilities:", res.Blocking)
+	printTable("ℹ️  Allowlisted vulnerabilities:", res.Allowed)
+	for _, e := range res.Expired {
+		logWarn("Allowlist entry %s no longer applies; review %s.", e, res.Policy)
+	}
+}
+
+// blockingSummary lists blocking findings as "ID (package)" for error messages.
+func blockingSummary(fs []VulnFinding) string {
+	const maxListed = 10
+	var parts []string
+	for i, f := range fs {
+		if i == maxListed {
+			parts = append(parts, fmt.Sprintf("+%d more", len(fs)-maxListed))
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s)", f.ID, f.Package))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// scanSummary converts a scan result into the build record summary.
+func scanSummary(res *VulnScanResult) *ScanSummary {
+	s := &ScanSummary{
+		Scanner:       "trivy",
+		Image:         res.Image,
+		ImageID:       res.ImageID,
+		Policy:        res.Policy,
+		Severities:    strings.Join((&VulnPolicy{FailOn: res.FailOn}).severities(), ","),
+		IgnoreUnfixed: res.FixableOnly,
+		Passed:        res.Passed,
+		Counts:        res.Counts,
+		Report:        res.Report,
+	}
+	for _, f := range res.Blocking {
+		s.Blocking = append(s.Blocking, f.ID)
+	}
+	for _, f := range res.Allowed {
+		s.Allowlisted = append(s.Allowlisted, f.ID)
+	}
+	return s
+}
@@ -0,0 +1,140 @@
+//go:build mage
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vulnReport is a Trivy report of one OS package result holding vulns.
+func vulnReport(vulns ...TrivyVulnerability) *TrivyReport {
+	return &TrivyReport{Results: []TrivyResult{{Target: "debian 12.7", Class: "os-pkgs", Vulnerabilities: vulns}}}
+}
+
+func vuln(id, pkg, severity, fixed string) TrivyVulnerability {
+	return TrivyVulnerability{VulnerabilityID: id, PkgName: pkg, InstalledVersion: "1.0", FixedVersion: fixed, Severity: severity}
+}
+
+func TestLoadVulnPolicy(t *testing.T) {
+	if _, err := loadVulnPolicy(filepath.Join("..", vulnPolicyPath)); err != nil {
+		t.Fatalf("repository policy: %v", err)
+	}
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"allowlist", "failOn: high\nallowlist:\n  - id: CVE-2024-0001\n    package: libc6\n    expires: 2099-01-31\n    justification: test\n", ""},
+		{"typo in an allowlist entry", "allowlist:\n  - id: CVE-2024-0001\n    expire: 2099-01-31\n    justification: test\n", "field expire not found"},
+		{"typo in a setting", "failOn: HIGH\nfixable_only: true\n", "field fixable_only not found"},
+		{"unknown severity", "failOn: SEVERE\n", `unknown failOn severity "SEVERE"`},
+		{"allowlist without justification", "allowlist:\n  - id: CVE-2024-0001\n    expires: 2099-01-31\n", "has no justification"},
+		{"allowlist bad expiry", "allowlist:\n  - id: CVE-2024-0001\n    expires: 31/01/2099\n    justification: test\n", "expires must be YYYY-MM-DD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestProject(t)
+			writePolicy(t, tt.body)
+			policy, err := loadVulnPolicy(vulnPolicyPath)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if policy.FailOn != "HIGH" || len(policy.Allowlist) != 1 || policy.Allowlist[0].expiresAt.IsZero() {
+					t.Errorf("loaded %+v", policy)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("loadVulnPolicy error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateVulns(t *testing.T) {
+	now := time.Date(2025, 10, 27, 12, 0, 0, 0, time.UTC)
+	allow := func(id, pkg, expires string) AllowEntry {
+		day, err := time.Parse("2006-01-02", expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return AllowEntry{ID: id, Package: pkg, Expires: expires, Justification: "test", expiresAt: day.AddDate(0, 0, 1)}
+	}
+	tests := []struct {
+		name         string
+		policy       VulnPolicy
+		vulns        []TrivyVulnerability
+		wantBlocking []string // ID/package, most severe first
+		wantAllowed  []string
+		wantExpired  []string
+	}{
+		{"below threshold passes",
+			VulnPolicy{FailOn: "HIGH"},
+			[]TrivyVulnerability{vuln("CVE-1", "libc6", "MEDIUM", "2"), vuln("CVE-2", "bash", "LOW", "2")},
+			nil, nil, nil},
+		{"at and above threshold block",
+			VulnPolicy{FailOn: "HIGH"},
+			[]TrivyVulnerability{vuln("CVE-1", "libc6", "HIGH", "2"), vuln("CVE-2", "bash", "critical", "2"), vuln("CVE-3", "zlib", "MEDIUM", "2")},
+			[]string{"CVE-2/bash", "CVE-1/libc6"}, nil, nil},
+		{"unknown severity only fails on UNKNOWN",
+			VulnPolicy{FailOn: "UNKNOWN"},
+			[]TrivyVulnerability{vuln("CVE-1", "libc6", "WHATEVER", "2")},
+			[]string{"CVE-1/libc6"}, nil, nil},
+		{"allowlisted until expiry",
+			VulnPolicy{FailOn: "HIGH", Allowlist: []AllowEntry{allow("CVE-1", "", "2025-10-27")}},
+			[]TrivyVulnerability{vuln("CVE-1", "libc6", "CRITICAL", "2")},
+			nil, []string{"CVE-1/libc6"}, nil},
+		{"expired allowlist entry fails the scan",
+			VulnPolicy{FailOn: "HIGH", Allowlist: []AllowEntry{allow("CVE-1", "", "2025-10-26")}},
+			[]TrivyVulnerability{vuln("CVE-1", "libc6", "CRITICAL", "2")},
+			[]string{"CVE-1/libc6"}, nil, []string{"CVE-1 (expired 2025-10-26)"}},
+		{"package-scoped entry covers only its package",
+			VulnPolicy{FailOn: "HIGH", Allowlist: []AllowEntry{allow("CVE-1", "libc6", "2099-01-31")}},
+			[]TrivyVulnerability{vuln("CVE-1", "libc6", "HIGH", "2"), vuln("CVE-1", "libc-bin", "HIGH", "2")},
+			[]string{"CVE-1/libc-bin"}, []string{"CVE-1/libc6"}, nil},
+		{"fixableOnly ignores findings without a fix",
+			VulnPolicy{FailOn: "HIGH", FixableOnly: true},
+			[]TrivyVulnerability{vuln("CVE-1", "libc6", "CRITICAL", ""), vuln("CVE-2", "bash", "HIGH", "5.2-3")},
+			[]string{"CVE-2/bash"}, nil, nil},
+		{"without fixableOnly unfixed findings block",
+			VulnPolicy{FailOn: "HIGH"},
+			[]TrivyVulnerability{vuln("CVE-1", "libc6", "CRITICAL", "")},
+			[]string{"CVE-1/libc6"}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := evaluateVulns(vulnReport(tt.vulns...), &tt.policy, now)
+
+			ids := func(fs []VulnFinding) []string {
+				var out []string
+				for _, f := range fs {
+					out = append(out, f.ID+"/"+f.Package)
+				}
+				return out
+			}
+			if got := ids(res.Blocking); strings.Join(got, ",") != strings.Join(tt.wantBlocking, ",") {
+				t.Errorf("blocking = %v, want %v", got, tt.wantBlocking)
+			}
+			if got := ids(res.Allowed); strings.Join(got, ",") != strings.Join(tt.wantAllowed, ",") {
+				t.Errorf("allowed = %v, want %v", got, tt.wantAllowed)
+			}
+			if strings.Join(res.Expired, ",") != strings.Join(tt.wantExpired, ",") {
+				t.Errorf("expired = %v, want %v", res.Expired, tt.wantExpired)
+			}
+			if res.Passed != (len(tt.wantBlocking) == 0) {
+				t.Errorf("passed = %v with %d blocking findings", res.Passed, len(res.Blocking))
+			}
+			total := 0
+			for _, n := range res.Counts {
+				total += n
+			}
+			if total != len(tt.vulns) {
+				t.Errorf("counted %d findings, want every one of %d", total, len(tt.vulns))
+			}
+		})
+	}
+}
@@ -39,13 +39,14 @@ echo "[Buildx] Enabling QEMU multi-arch emulation..."
 docker run --privileged --rm tonistiigi/binfmt --install all >/dev/null
 
 # Ensure a containerized builder exists
-if ! docker buildx inspect hardened-builder &>/dev/null; then
-  echo "[Buildx] Creating containerized builder 'hardened-builder'..."
-  docker buildx create --name hardened-builder --driver docker-container --use
-  docker buildx inspect hardened-builder --bootstrap >/dev/null
+BUILDER="${BUILDX_BUILDER_NAME:-hardened-builder}"
+if ! docker buildx inspect "$BUILDER" &>/dev/null; then
+  echo "[Buildx] Creating containerized builder '${BUILDER}'..."
+  docker buildx create --name "$BUILDER" --driver docker-container --use
+  docker buildx inspect "$BUILDER" --bootstrap >/dev/null
 else
-  echo "[Buildx] Builder 'hardened-builder' already exists. Setting active..."
-  docker buildx use hardened-builder
+  echo "[Buildx] Builder '${BUILDER}' already exists. Setting active..."
+  docker buildx use "$BUILDER"
 fi
 
 # Verify